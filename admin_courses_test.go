@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DarinM223/bookcycle/server"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminCourses(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Admin",
+		Lastname:  "User",
+		Email:     "testadmin@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	defer bookTesting.DB.Where("user_email = ?", testUser.Email).Delete(server.CourseAudit{})
+	defer coursesDB.Where("department = ?", "Test Admin Studies").Delete(server.Course{})
+
+	courseForm := func(courseID, professor string) url.Values {
+		return url.Values{"department": {"Test Admin Studies"}, "course_id": {courseID}, "professor": {professor}}
+	}
+	var change server.CourseChangeResult
+
+	// test that only admins can change the catalog
+	status, _ := bookTesting.PostTestJSONWithCookie(bookTesting.AdminCoursesURL()+"/new/json", courseForm("1", "Staff"),
+		loginCookie, &change)
+	if status != http.StatusForbidden {
+		t.Errorf("Users that are not admins should be forbidden: %d", status)
+	}
+	var out bytes.Buffer
+	if err := MakeAdminCommand(bookTesting.DB, []string{testUser.Email}, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	// test that admins can create and edit courses and that the changes are recorded
+	if _, err := bookTesting.PostTestJSONWithCookie(bookTesting.AdminCoursesURL()+"/new/json", courseForm("1", "Staff"),
+		loginCookie, &change); err != nil {
+		t.Fatal(err)
+	}
+	duplicate := change.Course
+	if duplicate.ID == 0 || change.Audit.Action != server.CourseActionCreate || change.Audit.UserEmail != testUser.Email {
+		t.Errorf("Created course with an audit entry expected: %+v", change)
+	}
+	if _, err := bookTesting.PostTestJSONWithCookie(bookTesting.AdminCoursesURL()+"/new/json", courseForm("1", "Jane Doe"),
+		loginCookie, &change); err != nil {
+		t.Fatal(err)
+	}
+	course := change.Course
+	editURL := bookTesting.AdminCoursesURL() + "/" + strconv.Itoa(duplicate.ID) + "/edit/json"
+	if _, err := bookTesting.PostTestJSONWithCookie(editURL, courseForm("1", "J. Doe"), loginCookie, &change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Course.Professor != "J. Doe" || !strings.Contains(change.Audit.Before, "Staff") {
+		t.Errorf("Edited course with the old course in the audit entry expected: %+v", change)
+	}
+
+	// test that merging moves the listings to the other course and retires the duplicate
+	book := server.Book{Title: "Admin Book", ISBN: "0735619670", CourseID: duplicate.ID, Price: 10.0, Condition: server.ConditionGood}
+	if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	for _, scheduled := range []server.ScheduledCourse{
+		{UserID: user.ID, CourseID: duplicate.ID, TermID: 1},
+		{UserID: user.ID, CourseID: course.ID, TermID: 1},
+		{UserID: user.ID, CourseID: duplicate.ID, TermID: 2},
+	} {
+		bookTesting.DB.Create(&scheduled)
+	}
+	for _, wanted := range []server.WantedBook{
+		{UserID: user.ID, CourseID: duplicate.ID, ISBN: "9780735619678", MaxPrice: 20.0},
+		{UserID: user.ID, CourseID: course.ID, ISBN: "9780735619678", MaxPrice: 20.0},
+		{UserID: user.ID, CourseID: duplicate.ID, ISBN: "9780201633610", MaxPrice: 20.0},
+	} {
+		bookTesting.DB.Create(&wanted)
+	}
+	mergeURL := bookTesting.AdminCoursesURL() + "/" + strconv.Itoa(duplicate.ID) + "/merge/json"
+	if _, err := bookTesting.PostTestJSONWithCookie(mergeURL, url.Values{"into_id": {strconv.Itoa(course.ID)}},
+		loginCookie, &change); err != nil {
+		t.Fatal(err)
+	}
+	if !change.Course.Retired || change.Course.MergedIntoID != course.ID || change.Audit.BooksMoved != 1 {
+		t.Errorf("Retired course with \"1\" book moved expected: %+v", change)
+	}
+	var moved server.Book
+	bookTesting.DB.Where("user_id = ?", user.ID).First(&moved)
+	if moved.CourseID != course.ID {
+		t.Errorf("The book should be moved to course %d: %d", course.ID, moved.CourseID)
+	}
+	// test that the schedules and wanted books that the user already had for the course are not duplicated
+	var scheduled []server.ScheduledCourse
+	bookTesting.DB.Where("user_id = ? AND course_id = ?", user.ID, course.ID).Order("term_id").Find(&scheduled)
+	if len(scheduled) != 2 || scheduled[0].TermID != 1 || scheduled[1].TermID != 2 {
+		t.Errorf("Course %d expected once in terms \"1\" and \"2\": %+v", course.ID, scheduled)
+	}
+	var wanted []server.WantedBook
+	bookTesting.DB.Where("user_id = ? AND course_id = ?", user.ID, course.ID).Order("i_s_b_n").Find(&wanted)
+	if len(wanted) != 2 || wanted[0].ISBN != "9780201633610" || wanted[1].ISBN != "9780735619678" {
+		t.Errorf("\"2\" different wanted books expected for course %d: %+v", course.ID, wanted)
+	}
+	status, _ = bookTesting.PostTestJSONWithCookie(mergeURL, url.Values{"into_id": {strconv.Itoa(course.ID)}}, loginCookie, &change)
+	if status != http.StatusBadRequest {
+		t.Errorf("Retired courses should not be merged again: %d", status)
+	}
+
+	// test that retired courses are not searchable
+	search := func() []server.Course {
+		var courses []server.Course
+		params := url.Values{"type": {"professor"}, "department": {"Test Admin Studies"}, "course_id": {"1"}, "term": {server.AllTerms}}
+		if err := bookTesting.GetTestJSON(bookTesting.CourseSearchURL(params), &courses); err != nil {
+			t.Fatal(err)
+		}
+		return courses
+	}
+	if courses := search(); len(courses) != 1 || courses[0].ID != course.ID {
+		t.Errorf("Only course %d should be searchable: %+v", course.ID, courses)
+	}
+	retireURL := bookTesting.AdminCoursesURL() + "/" + strconv.Itoa(course.ID) + "/retire/json"
+	if _, err := bookTesting.PostTestJSONWithCookie(retireURL, url.Values{}, loginCookie, &change); err != nil {
+		t.Fatal(err)
+	}
+	if courses := search(); len(courses) != 0 {
+		t.Errorf("Retired courses should not be searchable: %+v", courses)
+	}
+
+	// test that the catalog page lists the changes with who made them
+	var adminCourses server.AdminCourses
+	params := url.Values{"department": {"Test Admin Studies"}}
+	if err := bookTesting.GetTestJSONWithCookie(bookTesting.AdminCoursesURL()+"/json?"+params.Encode(), loginCookie, &adminCourses); err != nil {
+		t.Fatal(err)
+	}
+	if len(adminCourses.Courses) != 2 || len(adminCourses.Changes) < 5 || adminCourses.Changes[0].Action != server.CourseActionRetire {
+		t.Errorf("\"2\" courses and the retirement as the latest of \"5\" changes expected: %+v", adminCourses)
+	}
+	page, err := bookTesting.GetTestPageWithCookie(bookTesting.AdminCoursesURL()+"/"+strconv.Itoa(duplicate.ID)+"/edit", loginCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, "merged into") || !strings.Contains(page, testUser.Email) {
+		t.Errorf("The edit page should show the merge and who made it:\n%s", page)
+	}
+
+	// test that the changes are made again to a new courses.database, like after a deploy
+	const deployedCoursesDatabase = "./sqlite_courses_deployed_test.db"
+	if err := copyFile("./courses.database", deployedCoursesDatabase); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(deployedCoursesDatabase)
+	deployedDB, err := gorm.Open("sqlite3", deployedCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deployedDB.Close()
+	deployedDB.LogMode(false)
+	deployedDB.AutoMigrate(&server.Course{}, &server.Term{}, &server.CourseTextbook{}, &server.Institution{},
+		&server.DepartmentAlias{}, &server.CrossListing{}, &server.Professor{}, &server.CourseProfessor{})
+	server.MigrateCourses(deployedDB)
+	server.MigrateProfessors(deployedDB)
+	if err := server.ApplyCourseAudits(bookTesting.DB, deployedDB); err != nil {
+		t.Fatal(err)
+	}
+	var deployed []server.Course
+	deployedDB.Where("department = ?", "Test Admin Studies").Order("id").Find(&deployed)
+	if len(deployed) != 2 || deployed[0].ID != duplicate.ID || deployed[0].MergedIntoID != course.ID ||
+		deployed[0].Professor != "J. Doe" || !deployed[1].Retired {
+		t.Errorf("The merged and the retired course expected after a deploy: %+v", deployed)
+	}
+
+	// Delete mock created user, books, wanted books and schedules
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Unscoped().Where("user_id = ?", user.ID).Delete(server.WantedBook{})
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.ScheduledCourse{})
+	bookTesting.DB.Delete(&user)
+}
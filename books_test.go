@@ -1,9 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"github.com/DarinM223/bookcycle/server"
+	"image"
+	"image/png"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var bookTesting BookTesting
@@ -49,7 +61,7 @@ func TestCreateBook(t *testing.T) {
 		ISBN:      "0735619670",
 		CourseID:  1,
 		Price:     12.50,
-		Condition: 5,
+		Condition: server.ConditionGood,
 		Details:   "Sample text",
 		UserID:    1,
 	}
@@ -66,8 +78,11 @@ func TestCreateBook(t *testing.T) {
 	if books[0].Title != "Title" {
 		t.Errorf("\"Title\" expected: %s", books[0].Title)
 	}
-	if books[0].ISBN != "0735619670" {
-		t.Errorf("\"0735619670\" expected: %s", books[0].ISBN)
+	if books[0].ISBN != "9780735619678" {
+		t.Errorf("\"9780735619678\" expected: %s", books[0].ISBN)
+	}
+	if books[0].OriginalISBN != "0735619670" {
+		t.Errorf("\"0735619670\" expected: %s", books[0].OriginalISBN)
 	}
 	if books[0].CourseID != 1 {
 		t.Errorf("\"1\" expected: %d", books[0].CourseID)
@@ -75,8 +90,8 @@ func TestCreateBook(t *testing.T) {
 	if books[0].Price != 12.50 {
 		t.Errorf("\"12.50\" expected: %f", books[0].Price)
 	}
-	if books[0].Condition != 5 {
-		t.Errorf("\"5\" expected: %d", books[0].Condition)
+	if books[0].Condition != server.ConditionGood {
+		t.Errorf("\"good\" expected: %s", books[0].Condition)
 	}
 	if books[0].Details != "Sample text" {
 		t.Errorf("\"Sample Text\" expected: %s", books[0].Details)
@@ -92,12 +107,12 @@ func TestCreateBook(t *testing.T) {
 
 func TestDeleteBook(t *testing.T) {
 	// test that deleting book without being logged in should fail
-	request, err := http.NewRequest("GET", bookTesting.DeleteBookURL(1), nil)
+	request, err := http.NewRequest("POST", bookTesting.DeleteBookURL(1), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 401 {
-		t.Fatal("GET 401 expected")
+		t.Fatal("POST 401 expected")
 	}
 
 	testUser := server.User{
@@ -120,7 +135,7 @@ func TestDeleteBook(t *testing.T) {
 
 	// test that deleting book while being logged in fails if book does not exist
 	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 401 {
-		t.Fatal("GET 401 expected")
+		t.Fatal("POST 401 expected")
 	}
 
 	testBook := server.Book{
@@ -128,14 +143,37 @@ func TestDeleteBook(t *testing.T) {
 		ISBN:      "0735619670",
 		CourseID:  1,
 		Price:     12.50,
-		Condition: 5,
+		Condition: server.ConditionGood,
 		Details:   "Sample text",
 		UserID:    1,
 	}
 	bookTesting.MakeTestBook(testBook, loginCookie)
 
 	var myBook server.Book
-	bookTesting.DB.Where("i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+
+	// test that ids that are not numbers are not found instead of being run as SQL
+	injectedID := url.PathEscape("0 OR 1 = 1")
+	for _, test := range []struct {
+		method string
+		path   string
+	}{
+		{"POST", "/books/" + injectedID + "/delete"},
+		{"GET", "/books/" + injectedID + "/json"},
+	} {
+		request, err := http.NewRequest(test.method, bookTesting.Server.URL+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.AddCookie(loginCookie)
+		if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s %s 404 expected", test.method, test.path)
+		}
+	}
+	var count int
+	if bookTesting.DB.Model(&server.Book{}).Count(&count); count != 1 {
+		t.Fatalf("The book should not be deleted: %d books", count)
+	}
 
 	// test that deleting book that you do not own when logged in fails
 	newTestUser := server.User{
@@ -155,7 +193,7 @@ func TestDeleteBook(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	request, err = http.NewRequest("GET", bookTesting.DeleteBookURL(myBook.ID), nil)
+	request, err = http.NewRequest("POST", bookTesting.DeleteBookURL(myBook.ID), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -168,8 +206,19 @@ func TestDeleteBook(t *testing.T) {
 	}
 	request.AddCookie(loginCookie)
 
+	// test that deleting book with GET does nothing
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 404 {
+		t.Fatal("GET 404 expected")
+	}
+
+	request, err = http.NewRequest("POST", bookTesting.DeleteBookURL(myBook.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(loginCookie)
+
 	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
-		t.Fatal("GET 200 expected")
+		t.Fatal("POST 200 expected")
 	}
 
 	// test that there is no more books after deleting
@@ -186,3 +235,1330 @@ func TestDeleteBook(t *testing.T) {
 	bookTesting.DB.Delete(&user)
 	bookTesting.DB.Delete(&newUser)
 }
+
+func TestEditBook(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBook := server.Book{
+		Title:     "Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+
+	// test that editing book without being logged in fails
+	request, err := http.NewRequest("GET", bookTesting.EditBookURL(myBook.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 401 {
+		t.Fatal("GET 401 expected")
+	}
+
+	// test that editing book that you do not own fails
+	newTestUser := server.User{
+		Firstname: "New",
+		Lastname:  "User",
+		Email:     "newuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err = bookTesting.MakeTestUser(newTestUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	newLoginCookie, err := bookTesting.LoginUser(newTestUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editedBook := testBook
+	editedBook.Price = 8.75
+	editedBook.Condition = server.ConditionLikeNew
+	editedBook.Details = "Edited text"
+	if err = bookTesting.EditTestBook(myBook.ID, editedBook, newLoginCookie); err == nil {
+		t.Fatal("Editing book that you do not own should return error")
+	}
+
+	// test that editing your own book updates it in place
+	request.AddCookie(loginCookie)
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		t.Fatal("GET 200 expected")
+	}
+	if err = bookTesting.EditTestBook(myBook.ID, editedBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var books []server.Book
+	bookTesting.DB.Find(&books)
+	if len(books) != 1 {
+		t.Fatalf("Books length should be 1, instead: %d", len(books))
+	}
+	if books[0].ID != myBook.ID {
+		t.Errorf("\"%d\" expected: %d", myBook.ID, books[0].ID)
+	}
+	if !books[0].CreatedAt.Equal(myBook.CreatedAt) {
+		t.Errorf("\"%s\" expected: %s", myBook.CreatedAt, books[0].CreatedAt)
+	}
+	if books[0].Price != 8.75 {
+		t.Errorf("\"8.75\" expected: %f", books[0].Price)
+	}
+	if books[0].Condition != server.ConditionLikeNew {
+		t.Errorf("\"like_new\" expected: %s", books[0].Condition)
+	}
+	if books[0].Details != "Edited text" {
+		t.Errorf("\"Edited text\" expected: %s", books[0].Details)
+	}
+
+	// test that a book saved before ISBNs were validated can be edited as long as its ISBN is unchanged
+	bookTesting.DB.Model(&books[0]).UpdateColumns(server.Book{ISBN: "12345", OriginalISBN: "12345"})
+	legacyBook := editedBook
+	legacyBook.ISBN = "12345"
+	legacyBook.Price = 7.25
+	if err = bookTesting.EditTestBook(myBook.ID, legacyBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	bookTesting.DB.First(&books[0], myBook.ID)
+	if books[0].ISBN != "12345" || books[0].Price != 7.25 {
+		t.Errorf("\"12345\" and 7.25 expected: %s and %f", books[0].ISBN, books[0].Price)
+	}
+	legacyBook.ISBN = "54321"
+	if err = bookTesting.EditTestBook(myBook.ID, legacyBook, loginCookie); err == nil {
+		t.Error("Changing the ISBN to an invalid ISBN should return error")
+	}
+
+	// Delete mock created users and book
+	var user, newUser server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Where("email LIKE ?", newTestUser.Email).First(&newUser)
+	bookTesting.DB.Delete(&books[0])
+	bookTesting.DB.Delete(&user)
+	bookTesting.DB.Delete(&newUser)
+}
+
+func TestBookStatus(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBook := server.Book{
+		Title:     "Status Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+	if myBook.Status != server.BookAvailable {
+		t.Fatalf("\"available\" expected: %s", myBook.Status)
+	}
+
+	// test that a book cannot skip to an unknown status
+	if err = bookTesting.ChangeTestBookStatus(myBook.ID, "lost", loginCookie); err == nil {
+		t.Fatal("Changing book to an unknown status should return error")
+	}
+
+	// test that the owner can reserve and then sell the book
+	if err = bookTesting.ChangeTestBookStatus(myBook.ID, server.BookReserved, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err = bookTesting.ChangeTestBookStatus(myBook.ID, server.BookSold, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	// test that a sold book cannot be made available again
+	if err = bookTesting.ChangeTestBookStatus(myBook.ID, server.BookAvailable, loginCookie); err == nil {
+		t.Fatal("Changing sold book to available should return error")
+	}
+
+	bookTesting.DB.First(&myBook, myBook.ID)
+	if myBook.Status != server.BookSold {
+		t.Errorf("\"sold\" expected: %s", myBook.Status)
+	}
+
+	var changes []server.BookStatusChange
+	bookTesting.DB.Where("book_id = ?", myBook.ID).Order("id").Find(&changes)
+	if len(changes) != 2 {
+		t.Fatalf("Status changes length should be 2, instead: %d", len(changes))
+	}
+	if changes[1].OldStatus != server.BookReserved || changes[1].NewStatus != server.BookSold {
+		t.Errorf("\"reserved -> sold\" expected: %s -> %s", changes[1].OldStatus, changes[1].NewStatus)
+	}
+
+	// test that sold books are hidden from search results
+	res, err := http.Get(bookTesting.Server.URL + "/search_results.json?query=Status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searchResults server.BookSearchResults
+	json.NewDecoder(res.Body).Decode(&searchResults)
+	res.Body.Close()
+	if len(searchResults.Books) != 0 {
+		t.Errorf("Search results length 0 expected: %d", len(searchResults.Books))
+	}
+
+	// Delete mock created user, book and status changes
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&server.BookStatusChange{}, "book_id = ?", myBook.ID)
+	bookTesting.DB.Delete(&myBook)
+	bookTesting.DB.Delete(&user)
+}
+
+func TestBookPhotos(t *testing.T) {
+	photoDir, err := ioutil.TempDir("", "bookcycle_photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(photoDir)
+	server.SetPhotoStorage(server.NewLocalPhotoStorage(photoDir, "/uploads"))
+
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBook := server.Book{
+		Title:     "Photo Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+
+	// test that uploading something that is not an image fails
+	if err = bookTesting.MakeTestBookWithPhotos(testBook, [][]byte{[]byte("not an image")}, loginCookie); err == nil {
+		t.Fatal("Uploading an invalid image should return error")
+	}
+
+	var photo bytes.Buffer
+	png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 800, 400)))
+	if err = bookTesting.MakeTestBookWithPhotos(testBook, [][]byte{photo.Bytes()}, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+
+	var photos []server.BookPhoto
+	bookTesting.DB.Where("book_id = ?", myBook.ID).Find(&photos)
+	if len(photos) != 1 {
+		t.Fatalf("Photos length should be 1, instead: %d", len(photos))
+	}
+
+	thumbFile, err := os.Open(filepath.Join(photoDir, filepath.FromSlash(photos[0].ThumbnailName)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, _, err := image.DecodeConfig(thumbFile)
+	thumbFile.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 200 || config.Height != 100 {
+		t.Errorf("\"200x100\" expected: %dx%d", config.Width, config.Height)
+	}
+
+	// test that the book page shows the uploaded photo
+	res, err := http.Get(bookTesting.ShowBookURL(myBook.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), photos[0].ThumbnailURL()) {
+		t.Errorf("Book page should contain thumbnail %s", photos[0].ThumbnailURL())
+	}
+
+	// Delete mock created user, book and photos
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&photos[0])
+	bookTesting.DB.Delete(&myBook)
+	bookTesting.DB.Delete(&user)
+}
+
+func TestNormalizeISBN(t *testing.T) {
+	validISBNs := map[string]string{
+		"0735619670":        "9780735619678",
+		"0-7356-1967-0":     "9780735619678",
+		"9780735619678":     "9780735619678",
+		"978 0 7356 1967 8": "9780735619678",
+		"080442957X":        "9780804429573",
+		"080442957x":        "9780804429573",
+	}
+	for isbn, expected := range validISBNs {
+		normalized, err := server.NormalizeISBN(isbn)
+		if err != nil {
+			t.Errorf("%s should be valid: %s", isbn, err.Error())
+		} else if normalized != expected {
+			t.Errorf("\"%s\" expected: %s", expected, normalized)
+		}
+	}
+
+	invalidISBNs := []string{"", "0735619671", "9780735619679", "12345", "073561967A", "1234567890128"}
+	for _, isbn := range invalidISBNs {
+		if _, err := server.NormalizeISBN(isbn); err == nil {
+			t.Errorf("%s should be invalid", isbn)
+		}
+	}
+}
+
+func TestMigrateBookISBNs(t *testing.T) {
+	// books saved before ISBNs were normalized have no original ISBN, including books in the trash
+	legacyBooks := []server.Book{
+		{Title: "Listed", ISBN: "0-7356-1967-0", Price: 10.0, Condition: server.ConditionGood, UserID: 1},
+		{Title: "Trashed", ISBN: "0-8044-2957-X", Price: 10.0, Condition: server.ConditionGood, UserID: 1},
+		{Title: "Invalid", ISBN: "12345", Price: 10.0, Condition: server.ConditionGood, UserID: 1},
+	}
+	for i := range legacyBooks {
+		bookTesting.DB.Create(&legacyBooks[i])
+	}
+	bookTesting.DB.Delete(&legacyBooks[1])
+
+	if err := server.MigrateBookISBNs(bookTesting.DB); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ isbn, originalISBN string }{
+		{"9780735619678", "0-7356-1967-0"},
+		{"9780804429573", "0-8044-2957-X"},
+		{"12345", "12345"},
+	}
+	for i, book := range legacyBooks {
+		var migrated server.Book
+		bookTesting.DB.Unscoped().First(&migrated, book.ID)
+		if migrated.ISBN != expected[i].isbn || migrated.OriginalISBN != expected[i].originalISBN {
+			t.Errorf("%s: ISBN %s from %s expected: %s from %s", book.Title, expected[i].isbn, expected[i].originalISBN,
+				migrated.ISBN, migrated.OriginalISBN)
+		}
+	}
+
+	// Delete mock created books
+	for i := range legacyBooks {
+		bookTesting.DB.Unscoped().Delete(&legacyBooks[i])
+	}
+}
+
+func TestBookMetadata(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the title defaults to the catalog title
+	testBook := server.Book{
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+	bookTesting.DB.Delete(&server.BookMetadata{}, "i_s_b_n = ?", "9780735619678")
+	lookups := atomic.LoadInt32(&metadataLookups)
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the metadata is only looked up once and then cached
+	if newLookups := atomic.LoadInt32(&metadataLookups) - lookups; newLookups != 1 {
+		t.Errorf("\"1\" lookup expected: %d", newLookups)
+	}
+
+	// test that ISBNs that are not found are not looked up again either
+	unknownBook := testBook
+	unknownBook.ISBN = "0262033844"
+	unknownBook.Title = "Unknown Title"
+	lookups = atomic.LoadInt32(&metadataLookups)
+	if err = bookTesting.MakeTestBook(unknownBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err = bookTesting.MakeTestBook(unknownBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	if newLookups := atomic.LoadInt32(&metadataLookups) - lookups; newLookups != 1 {
+		t.Errorf("\"1\" lookup of an unknown ISBN expected: %d", newLookups)
+	}
+	bookTesting.DB.Where("original_i_s_b_n = ?", unknownBook.ISBN).Delete(&server.Book{})
+
+	var metadata server.BookMetadata
+	if result := bookTesting.DB.Where("i_s_b_n = ?", "9780735619678").First(&metadata); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if metadata.Authors != "Steve McConnell" {
+		t.Errorf("\"Steve McConnell\" expected: %s", metadata.Authors)
+	}
+	if metadata.Publisher != "Microsoft Press" {
+		t.Errorf("\"Microsoft Press\" expected: %s", metadata.Publisher)
+	}
+	if metadata.Edition != "Second Edition" {
+		t.Errorf("\"Second Edition\" expected: %s", metadata.Edition)
+	}
+
+	var books []server.Book
+	bookTesting.DB.Find(&books)
+	if len(books) != 2 {
+		t.Fatalf("Books length should be 2, instead: %d", len(books))
+	}
+	if books[0].Title != "Code Complete" {
+		t.Errorf("\"Code Complete\" expected: %s", books[0].Title)
+	}
+
+	// test that the book json includes the catalog metadata
+	res, err := http.Get(bookTesting.ShowBookURL(books[0].ID) + "/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bookJSON server.Book
+	json.NewDecoder(res.Body).Decode(&bookJSON)
+	res.Body.Close()
+	if bookJSON.Metadata.CoverURL != "http://books.example.com/code_complete.jpg" {
+		t.Errorf("\"http://books.example.com/code_complete.jpg\" expected: %s", bookJSON.Metadata.CoverURL)
+	}
+
+	// test that books can be searched by author
+	res, err = http.Get(bookTesting.Server.URL + "/search_results.json?query=McConnell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searchResults server.BookSearchResults
+	json.NewDecoder(res.Body).Decode(&searchResults)
+	res.Body.Close()
+	if len(searchResults.Books) != 2 {
+		t.Errorf("Search results length 2 expected: %d", len(searchResults.Books))
+	}
+
+	// Delete mock created user and books
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&books[0])
+	bookTesting.DB.Delete(&books[1])
+	bookTesting.DB.Delete(&user)
+}
+
+func TestBookCondition(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// test that books with unknown conditions are rejected
+	testBook := server.Book{
+		Title:     "Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: "5",
+		Details:   "Sample text",
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err == nil {
+		t.Fatal("Creating book with an invalid condition should return error")
+	}
+
+	// test that the condition is marshalled to JSON as a string
+	testBook.Condition = server.ConditionAcceptable
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+
+	res, err := http.Get(bookTesting.ShowBookURL(myBook.ID) + "/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bookJSON map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&bookJSON)
+	res.Body.Close()
+	if bookJSON["condition"] != "acceptable" {
+		t.Errorf("\"acceptable\" expected: %v", bookJSON["condition"])
+	}
+
+	// Delete mock created user and book
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&myBook)
+	bookTesting.DB.Delete(&user)
+}
+
+func TestMigrateBookConditions(t *testing.T) {
+	// books saved before conditions were typed have a condition from 1 to 10, or none at all
+	if result := bookTesting.DB.Exec("ALTER TABLE books ADD COLUMN condition integer"); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	legacyBooks := []server.Book{
+		{Title: "Like New", ISBN: "9780735619678", Price: 10.0, UserID: 1},
+		{Title: "Trashed", ISBN: "9780735619678", Price: 10.0, UserID: 1},
+		{Title: "No Condition", ISBN: "9780735619678", Price: 10.0, UserID: 1},
+	}
+	for i, score := range []interface{}{9, 5, nil} {
+		bookTesting.DB.Create(&legacyBooks[i])
+		bookTesting.DB.Exec("UPDATE books SET condition = ? WHERE id = ?", score, legacyBooks[i].ID)
+	}
+	bookTesting.DB.Delete(&legacyBooks[1])
+
+	if err := server.MigrateBookConditions(bookTesting.DB); err != nil {
+		t.Fatal(err)
+	}
+	expected := []server.BookCondition{server.ConditionLikeNew, server.ConditionAcceptable, server.ConditionPoor}
+	for i, book := range legacyBooks {
+		var migrated server.Book
+		bookTesting.DB.Unscoped().First(&migrated, book.ID)
+		if migrated.Condition != expected[i] {
+			t.Errorf("%s: %q expected: %q", book.Title, expected[i], migrated.Condition)
+		}
+	}
+	if result := bookTesting.DB.Exec("SELECT condition FROM books"); result.Error == nil {
+		t.Error("The old condition column should be dropped")
+	}
+
+	// Delete mock created books
+	for i := range legacyBooks {
+		bookTesting.DB.Unscoped().Delete(&legacyBooks[i])
+	}
+}
+
+func TestBookExpiration(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBook := server.Book{
+		Title:     "Expiring Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+	if !myBook.ExpiresAt.After(time.Now()) {
+		t.Fatalf("Expiration date should be in the future: %s", myBook.ExpiresAt)
+	}
+
+	// test that books are only expired after their expiration date
+	if expired, err := server.ExpireBooks(bookTesting.DB, time.Now()); err != nil || expired != 0 {
+		t.Fatalf("\"0\" expired books expected: %d", expired)
+	}
+	expired, err := server.ExpireBooks(bookTesting.DB, myBook.ExpiresAt.Add(time.Minute))
+	if err != nil || expired != 1 {
+		t.Fatalf("\"1\" expired book expected: %d", expired)
+	}
+	bookTesting.DB.First(&myBook, myBook.ID)
+	if myBook.Status != server.BookExpired {
+		t.Fatalf("\"expired\" expected: %s", myBook.Status)
+	}
+
+	// test that expired books are hidden from search but still shown to their owner
+	res, err := http.Get(bookTesting.Server.URL + "/search_results.json?query=Expiring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var searchResults server.BookSearchResults
+	json.NewDecoder(res.Body).Decode(&searchResults)
+	res.Body.Close()
+	if len(searchResults.Books) != 0 {
+		t.Errorf("Search results length 0 expected: %d", len(searchResults.Books))
+	}
+
+	request, err := http.NewRequest("GET", bookTesting.ShowBooksURL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(loginCookie)
+	res, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), fmt.Sprintf("/books/%d", myBook.ID)) {
+		t.Error("Expired book should be shown in my books")
+	}
+
+	// test that renewing an expired book makes it available again
+	request, err = http.NewRequest("POST", bookTesting.RenewBookURL(myBook.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(loginCookie)
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		t.Fatal("POST 200 expected")
+	}
+	bookTesting.DB.First(&myBook, myBook.ID)
+	if myBook.Status != server.BookAvailable {
+		t.Errorf("\"available\" expected: %s", myBook.Status)
+	}
+	if !myBook.ExpiresAt.After(time.Now().Add(server.ListingLifetime - time.Hour)) {
+		t.Errorf("Expiration date should have been extended: %s", myBook.ExpiresAt)
+	}
+
+	// Delete mock created user, book and status changes
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&server.BookStatusChange{}, "book_id = ?", myBook.ID)
+	bookTesting.DB.Delete(&myBook)
+	bookTesting.DB.Delete(&user)
+}
+
+func TestBookTrash(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testBook := server.Book{
+		Title:     "Trash Title",
+		ISBN:      "0735619670",
+		CourseID:  1,
+		Price:     12.50,
+		Condition: server.ConditionGood,
+		Details:   "Sample text",
+	}
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	var myBook server.Book
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+
+	postAsUser := func(url string) int {
+		request, err := http.NewRequest("POST", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.AddCookie(loginCookie)
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// test that restoring a book that is not in the trash fails
+	if status := postAsUser(bookTesting.RestoreBookURL(myBook.ID)); status != 401 {
+		t.Fatalf("\"401\" expected: %d", status)
+	}
+
+	// test that deleted books are kept in the trash
+	if status := postAsUser(bookTesting.DeleteBookURL(myBook.ID)); status != 200 {
+		t.Fatalf("\"200\" expected: %d", status)
+	}
+	var deletedBook server.Book
+	if result := bookTesting.DB.Unscoped().First(&deletedBook, myBook.ID); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	if deletedBook.DeletedAt == nil {
+		t.Fatal("Deleted book should have a deletion date")
+	}
+
+	request, err := http.NewRequest("GET", bookTesting.TrashURL(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.AddCookie(loginCookie)
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), testBook.Title) {
+		t.Error("Deleted book should be shown in the trash")
+	}
+
+	// test that restoring a deleted book brings it back
+	if status := postAsUser(bookTesting.RestoreBookURL(myBook.ID)); status != 200 {
+		t.Fatalf("\"200\" expected: %d", status)
+	}
+	if result := bookTesting.DB.First(&myBook, myBook.ID); result.Error != nil {
+		t.Fatal("Restored book should not be deleted")
+	}
+
+	// test that purging a deleted book removes it permanently
+	if status := postAsUser(bookTesting.DeleteBookURL(myBook.ID)); status != 200 {
+		t.Fatalf("\"200\" expected: %d", status)
+	}
+	if status := postAsUser(bookTesting.PurgeBookURL(myBook.ID)); status != 200 {
+		t.Fatalf("\"200\" expected: %d", status)
+	}
+	if result := bookTesting.DB.Unscoped().First(&deletedBook, myBook.ID); !result.RecordNotFound() {
+		t.Error("Purged book should not exist")
+	}
+
+	// test that books are purged automatically after the retention window
+	if err = bookTesting.MakeTestBook(testBook, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	bookTesting.DB.Where("original_i_s_b_n LIKE ?", testBook.ISBN).First(&myBook)
+	bookTesting.DB.Delete(&myBook)
+	if purged, err := server.PurgeDeletedBooks(bookTesting.DB, time.Now()); err != nil || purged != 0 {
+		t.Fatalf("\"0\" purged books expected: %d", purged)
+	}
+	if _, err = server.PurgeDeletedBooks(bookTesting.DB, time.Now().Add(server.TrashRetention+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if result := bookTesting.DB.Unscoped().First(&deletedBook, myBook.ID); !result.RecordNotFound() {
+		t.Error("Book should be purged after the retention window")
+	}
+
+	// Delete mock created user
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+	bookTesting.DB.Delete(&user)
+}
+
+func TestImportBooks(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	csv := "isbn,title,course,price,condition,details\n" +
+		"0735619670,,1,20.00,good,Some highlighting\n" +
+		"0201633612,Design Patterns,2,35.50,like_new,\n" +
+		"1234567890,Bad ISBN,1,10.00,good,\n" +
+		"0201633612,Bad Price,1,cheap,good,\n"
+
+	// test that all-or-nothing imports do not save anything if a row is rejected
+	report, err := bookTesting.ImportTestBooks(csv, true, loginCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Committed || report.Created != 0 || report.Rejected != 2 {
+		t.Fatalf("Expected rolled back import with 2 rejected rows: %+v", report)
+	}
+	if report.Rows[0].Status != server.ImportSkipped || report.Rows[2].Status != server.ImportRejected {
+		t.Errorf("Unexpected row statuses: %+v", report.Rows)
+	}
+	var count int
+	bookTesting.DB.Model(&server.Book{}).Where("user_id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Fatalf("All-or-nothing import should not create books: %d", count)
+	}
+
+	// test that the valid rows are created otherwise
+	report, err = bookTesting.ImportTestBooks(csv, false, loginCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Committed || report.Created != 2 || report.Rejected != 2 {
+		t.Fatalf("Expected 2 created and 2 rejected rows: %+v", report)
+	}
+	if report.Rows[0].Row != 2 || report.Rows[3].Row != 5 {
+		t.Errorf("Rows should be numbered by their line in the file: %+v", report.Rows)
+	}
+	if report.Rows[2].Error == "" {
+		t.Error("Rejected rows should have an error")
+	}
+
+	var imported server.Book
+	bookTesting.DB.First(&imported, report.Rows[0].BookID)
+	if imported.UserID != user.ID || imported.ISBN != "9780735619678" || imported.Condition != server.ConditionGood {
+		t.Errorf("Imported book has wrong fields: %+v", imported)
+	}
+	if imported.Title != "Code Complete" {
+		t.Errorf("Missing titles should come from the book metadata: %s", imported.Title)
+	}
+
+	// test that files without a header use the default column order
+	report, err = bookTesting.ImportTestBooks("0201633612,Design Patterns,2,35.50,poor,\n", true, loginCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Committed || report.Created != 1 || report.Rows[0].Row != 1 {
+		t.Fatalf("Expected committed import of 1 row: %+v", report)
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestSearchBooks(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	testBooks := []server.Book{
+		{Title: "Writing Solid Code", ISBN: "0201633612", CourseID: 1, Price: 10.0, Condition: server.ConditionGood,
+			Details: "Pairs well with code complete"},
+		{Title: "Code Complete", ISBN: "0735619670", CourseID: 2, Price: 20.0, Condition: server.ConditionGood,
+			Details: "Second edition"},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		titles []string
+	}{
+		// multiple words can match different fields
+		{"complete mcconnell", []string{"Code Complete"}},
+		// books that match in the title are ranked above books that match in the details
+		{"complete", []string{"Code Complete", "Writing Solid Code"}},
+		// words are matched as prefixes
+		{"writ sol", []string{"Writing Solid Code"}},
+		{"0-7356-1967-0", []string{"Code Complete"}},
+		{"9780201633610", []string{"Writing Solid Code"}},
+		// the course is searched by department and course number
+		{"African American M124", []string{"Code Complete"}},
+		{"no such book", []string{}},
+	}
+	for _, test := range tests {
+		titles, err := bookTesting.SearchTestBooks(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(test.titles) {
+			t.Errorf("Search for %q expected %v: %v", test.query, test.titles, titles)
+		}
+	}
+
+	// test that the JSON search results come with the metadata of their books
+	var searchResults server.BookSearchResults
+	if err = bookTesting.GetTestJSON(bookTesting.Server.URL+"/search_results.json?query=mcconnell", &searchResults); err != nil {
+		t.Fatal(err)
+	}
+	if len(searchResults.Books) != 1 || searchResults.Books[0].Metadata.Authors != "Steve McConnell" {
+		t.Errorf("Code Complete by \"Steve McConnell\" expected: %+v", searchResults.Books)
+	}
+
+	// test that the typeahead gets the distinct titles of the books that match the query, most relevant first
+	if err = bookTesting.MakeTestBook(testBooks[1], loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	var titleBooks []server.Book
+	if err = bookTesting.GetTestJSON(bookTesting.Server.URL+"/search_titles.json?query=complete", &titleBooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(titleBooks) != 2 || titleBooks[0].Title != "Code Complete" || titleBooks[1].Title != "Writing Solid Code" {
+		t.Errorf("Titles [Code Complete Writing Solid Code] expected: %v", titleBooks)
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestFuzzySearch(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	testBooks := []server.Book{
+		{Title: "Calculus Early Transcendentals", ISBN: "0735619670", CourseID: 1, Price: 10.0,
+			Condition: server.ConditionGood},
+		{Title: "Organic Chemistry", ISBN: "0201633612", CourseID: 2, Price: 20.0, Condition: server.ConditionGood},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		titles []string
+	}{
+		// misspelled words are replaced by the most similar indexed word
+		{"Calclus Early Transcendentals", []string{"Calculus Early Transcendentals"}},
+		{"early transendentals", []string{"Calculus Early Transcendentals"}},
+		{"orgnic chemstry", []string{"Organic Chemistry"}},
+		// the typeahead searches misspelled prefixes
+		{"calclus", []string{"Calculus Early Transcendentals"}},
+		// words that are not similar to any indexed word do not match
+		{"calclus zyxwvut", []string{}},
+		{"qwertyuiop", []string{}},
+	}
+	for _, test := range tests {
+		titles, err := bookTesting.SearchTestBooks(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(test.titles) {
+			t.Errorf("Search for %q expected %v: %v", test.query, test.titles, titles)
+		}
+	}
+
+	// test that the typeahead suggests the titles of misspelled queries too
+	var titleBooks []server.Book
+	if err = bookTesting.GetTestJSON(bookTesting.Server.URL+"/search_titles.json?query=calclus", &titleBooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(titleBooks) != 1 || titleBooks[0].Title != "Calculus Early Transcendentals" {
+		t.Errorf("Titles [Calculus Early Transcendentals] expected: %v", titleBooks)
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestISBNStats(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	testBooks := []server.Book{
+		{Title: "The C Programming Language", ISBN: "0131103628", CourseID: 1, Price: 10.0, Condition: server.ConditionGood},
+		{Title: "The C Programming Language", ISBN: "0131103628", CourseID: 1, Price: 20.0, Condition: server.ConditionGood},
+		{Title: "The C Programming Language", ISBN: "0131103628", CourseID: 1, Price: 30.0, Condition: server.ConditionGood},
+		{Title: "The C Programming Language", ISBN: "0131103628", CourseID: 1, Price: 50.0, Condition: server.ConditionNew},
+		{Title: "The C Programming Language", ISBN: "0131103628", CourseID: 1, Price: 5.0, Condition: server.ConditionPoor},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var soldBook server.Book
+	bookTesting.DB.Where("user_id = ? AND price = ?", user.ID, 30.0).First(&soldBook)
+	if err = bookTesting.ChangeTestBookStatus(soldBook.ID, server.BookSold, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	// test that the asking prices only include available listings and are broken down by condition
+	var stats server.ISBNStats
+	if err = bookTesting.GetTestJSON(bookTesting.ISBNStatsURL("0-13-110362-8")+"/json", &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ISBN != "9780131103627" {
+		t.Errorf("ISBN-13 expected: %s", stats.ISBN)
+	}
+	if stats.Active.Count != 4 || stats.Active.Min != 5.0 || stats.Active.Median != 15.0 || stats.Active.Max != 50.0 {
+		t.Errorf("\"4\" listings from $5 to $50 with a median of $15 expected: %+v", stats.Active)
+	}
+	conditions := []server.BookCondition{}
+	for _, conditionStats := range stats.ActiveByCondition {
+		conditions = append(conditions, conditionStats.Condition)
+		if conditionStats.Condition == server.ConditionGood &&
+			(conditionStats.Count != 2 || conditionStats.Median != 15.0) {
+			t.Errorf("\"2\" good listings with a median of $15 expected: %+v", conditionStats)
+		}
+	}
+	if fmt.Sprint(conditions) != "[new good poor]" {
+		t.Errorf("Conditions with listings expected: %v", conditions)
+	}
+
+	// test that sold listings are reported as sold prices
+	if stats.Sold.Count != 1 || stats.Sold.Median != 30.0 || len(stats.RecentSales) != 1 ||
+		stats.RecentSales[0].BookID != soldBook.ID {
+		t.Errorf("\"1\" sale for $30 expected: %+v %+v", stats.Sold, stats.RecentSales)
+	}
+
+	// test that the stats page and the book page show the prices
+	for _, pageURL := range []string{bookTesting.ISBNStatsURL("9780131103627"), bookTesting.ShowBookURL(soldBook.ID)} {
+		res, err := http.Get(pageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || !strings.Contains(string(body), "$50.00") {
+			t.Errorf("%s should show the price stats", pageURL)
+		}
+	}
+
+	if res, err := http.Get(bookTesting.ISBNStatsURL("1234")); err != nil || res.StatusCode != 400 {
+		t.Error("Stats for an invalid ISBN should be a bad request")
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestSearchFilters(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	// courses 1 and 2 are in African American Studies and course 100 is in another department
+	testBooks := []server.Book{
+		{Title: "Filter Cheap", ISBN: "0735619670", CourseID: 1, Price: 5.0, Condition: server.ConditionPoor},
+		{Title: "Filter Middle", ISBN: "0735619670", CourseID: 2, Price: 15.0, Condition: server.ConditionGood},
+		{Title: "Filter Expensive", ISBN: "0735619670", CourseID: 100, Price: 40.0, Condition: server.ConditionGood},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		params url.Values
+		titles []string
+	}{
+		{url.Values{"query": {"filter"}, "sort": {"price_asc"}},
+			[]string{"Filter Cheap", "Filter Middle", "Filter Expensive"}},
+		{url.Values{"query": {"filter"}, "sort": {"price_desc"}},
+			[]string{"Filter Expensive", "Filter Middle", "Filter Cheap"}},
+		{url.Values{"query": {"filter"}, "sort": {"newest"}},
+			[]string{"Filter Expensive", "Filter Middle", "Filter Cheap"}},
+		{url.Values{"query": {"filter"}, "sort": {"price_asc"}, "min_price": {"10"}, "max_price": {"20"}},
+			[]string{"Filter Middle"}},
+		{url.Values{"query": {"filter"}, "sort": {"price_asc"}, "condition": {"poor", "good"}},
+			[]string{"Filter Cheap", "Filter Middle", "Filter Expensive"}},
+		{url.Values{"query": {"filter"}, "condition": {"poor"}}, []string{"Filter Cheap"}},
+		{url.Values{"query": {"filter"}, "course_id": {"2"}}, []string{"Filter Middle"}},
+		{url.Values{"query": {"filter"}, "sort": {"price_asc"}, "department": {"African American Studies"}},
+			[]string{"Filter Cheap", "Filter Middle"}},
+		{url.Values{"seller_id": {fmt.Sprint(user.ID)}, "sort": {"price_asc"}, "max_price": {"20"}},
+			[]string{"Filter Cheap", "Filter Middle"}},
+	}
+	for _, test := range tests {
+		results, err := bookTesting.SearchTestBooksWith(test.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		titles := make([]string, len(results.Books))
+		for i, book := range results.Books {
+			titles[i] = book.Title
+		}
+		if fmt.Sprint(titles) != fmt.Sprint(test.titles) {
+			t.Errorf("Search for %v expected %v: %v", test.params, test.titles, titles)
+		}
+	}
+
+	// test that facets are counted without their own filter but with the other filters
+	results, err := bookTesting.SearchTestBooksWith(url.Values{
+		"query":     {"filter"},
+		"condition": {"good"},
+		"max_price": {"20"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conditionCounts := map[string]int{}
+	for _, facet := range results.Facets.Conditions {
+		conditionCounts[facet.Value] = facet.Count
+		if facet.Selected != (facet.Value == "good") {
+			t.Errorf("Only the good condition should be selected: %+v", facet)
+		}
+	}
+	if conditionCounts["good"] != 1 || conditionCounts["poor"] != 1 || conditionCounts["new"] != 0 {
+		t.Errorf("Unexpected condition facets: %+v", results.Facets.Conditions)
+	}
+	if len(results.Facets.Departments) != 1 || results.Facets.Departments[0].Label != "African American Studies" ||
+		results.Facets.Departments[0].Count != 1 {
+		t.Errorf("Unexpected department facets: %+v", results.Facets.Departments)
+	}
+	if len(results.Facets.Courses) != 1 || results.Facets.Courses[0].Value != "2" {
+		t.Errorf("Unexpected course facets: %+v", results.Facets.Courses)
+	}
+
+	// test that the search page shows the facet counts
+	res, err := http.Get(bookTesting.Server.URL + "/search_results?query=filter&condition=good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "Good (2)") || !strings.Contains(string(body), "Poor (1)") {
+		t.Error("Search page should show the condition facet counts")
+	}
+
+	// test that unknown sorts are rejected
+	res, err = http.Get(bookTesting.SearchBooksURL(url.Values{"query": {"filter"}, "sort": {"cheapest"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		t.Errorf("\"400\" expected: %d", res.StatusCode)
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestPagination(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	for _, price := range []float64{30, 10, 20} {
+		book := server.Book{Title: "Paged Book", ISBN: "0735619670", CourseID: 1, Price: price, Condition: server.ConditionGood}
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getResults := func(url string) server.BookSearchResults {
+		var results server.BookSearchResults
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != 200 {
+			t.Fatalf("GET %s should be 200: %d", url, res.StatusCode)
+		}
+		json.NewDecoder(res.Body).Decode(&results)
+		return results
+	}
+	prices := func(results server.BookSearchResults) string {
+		var p []float64
+		for _, book := range results.Books {
+			p = append(p, book.Price)
+		}
+		return fmt.Sprint(p)
+	}
+
+	// test that following the next and previous links walks through every page
+	first := getResults(bookTesting.SearchBooksURL(url.Values{"query": {"paged"}, "sort": {"price_asc"}, "page_size": {"2"}}))
+	if prices(first) != "[10 20]" || first.Page.Next == "" || first.Page.Prev != "" {
+		t.Fatalf("Unexpected first page %s: %+v", prices(first), first.Page)
+	}
+	second := getResults(bookTesting.Server.URL + first.Page.NextURL)
+	if prices(second) != "[30]" || second.Page.Next != "" || second.Page.Prev == "" {
+		t.Fatalf("Unexpected second page %s: %+v", prices(second), second.Page)
+	}
+	back := getResults(bookTesting.Server.URL + second.Page.PrevURL)
+	if prices(back) != "[10 20]" || back.Page.Next == "" || back.Page.Prev != "" {
+		t.Fatalf("Unexpected page after going back %s: %+v", prices(back), back.Page)
+	}
+
+	// test that pages of relevance ranked results do not repeat books
+	seen := map[int]bool{}
+	results := getResults(bookTesting.SearchBooksURL(url.Values{"query": {"paged"}, "page_size": {"1"}}))
+	for pages := 1; ; pages++ {
+		for _, book := range results.Books {
+			if seen[book.ID] {
+				t.Errorf("Book %d is on more than one page", book.ID)
+			}
+			seen[book.ID] = true
+		}
+		if results.Page.NextURL == "" || pages > 3 {
+			break
+		}
+		results = getResults(bookTesting.Server.URL + results.Page.NextURL)
+	}
+	if len(seen) != 3 {
+		t.Errorf("\"3\" books expected over all pages: %d", len(seen))
+	}
+
+	// test that invalid cursors are rejected
+	res, err := http.Get(bookTesting.SearchBooksURL(url.Values{"query": {"paged"}, "cursor": {"not a cursor"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		t.Errorf("\"400\" expected: %d", res.StatusCode)
+	}
+
+	// test that messages are paged newest first
+	for i := 0; i < 3; i++ {
+		message := server.Message{SenderID: user.ID + 1, ReceiverID: user.ID, Message: fmt.Sprint(i),
+			CreatedAt: time.Now().Add(time.Duration(i) * time.Minute)}
+		bookTesting.DB.Create(&message)
+	}
+	getMessages := func(url string) server.MessagesPage {
+		var messages server.MessagesPage
+		request, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.AddCookie(loginCookie)
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&messages)
+		return messages
+	}
+	messages := getMessages(bookTesting.Server.URL + "/messages?page_size=2")
+	if len(messages.Messages) != 2 || messages.Messages[0].Message != "2" || messages.Page.NextURL == "" {
+		t.Fatalf("Unexpected first page of messages: %+v", messages)
+	}
+	messages = getMessages(bookTesting.Server.URL + messages.Page.NextURL)
+	if len(messages.Messages) != 1 || messages.Messages[0].Message != "0" || messages.Page.NextURL != "" {
+		t.Fatalf("Unexpected second page of messages: %+v", messages)
+	}
+
+	// test that messages sent at the same time by different users are not skipped between pages
+	sentAt := time.Now().Add(time.Hour)
+	for i := 1; i <= 2; i++ {
+		message := server.Message{SenderID: user.ID + i, ReceiverID: user.ID, Message: fmt.Sprint("same time ", i),
+			CreatedAt: sentAt}
+		bookTesting.DB.Create(&message)
+	}
+	messages = getMessages(bookTesting.Server.URL + "/messages?page_size=1")
+	firstMessage := messages.Messages[0].Message
+	messages = getMessages(bookTesting.Server.URL + messages.Page.NextURL)
+	if len(messages.Messages) != 1 || messages.Messages[0].Message == firstMessage ||
+		!strings.HasPrefix(messages.Messages[0].Message, "same time") {
+		t.Fatalf("Unexpected page of messages sent at the same time: %+v", messages)
+	}
+
+	// Delete mock created user, books and messages
+	bookTesting.DB.Where("receiver_id = ?", user.ID).Delete(server.Message{})
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
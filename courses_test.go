@@ -0,0 +1,724 @@
+package main
+
+import (
+	"bytes"
+	"github.com/DarinM223/bookcycle/server"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCourseListings(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	// courses 39 to 43 are all Anthropology 19 with different professors and course 100 is Anthropology 297
+	testBooks := []server.Book{
+		{Title: "Course Book", ISBN: "0735619670", CourseID: 39, Price: 20.0, Condition: server.ConditionGood},
+		{Title: "Course Book", ISBN: "0735619670", CourseID: 41, Price: 12.0, Condition: server.ConditionGood},
+		{Title: "Other Course Book", ISBN: "0735619670", CourseID: 100, Price: 5.0, Condition: server.ConditionGood},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// test that a course includes the listings for every professor teaching it
+	var course server.CourseListings
+	if err = bookTesting.GetTestJSON(bookTesting.CourseURL(40)+"/json", &course); err != nil {
+		t.Fatal(err)
+	}
+	if course.ID != 40 || course.Department != "Anthropology" || course.CourseID != "19" {
+		t.Errorf("Unexpected course: %+v", course.Course)
+	}
+	if len(course.Professors) != 5 || len(course.SectionIDs) != 5 {
+		t.Errorf("\"5\" professors expected: %v", course.Professors)
+	}
+	if course.ListingCount != 2 || course.LowestPrice != 12.0 || len(course.Books) != 2 {
+		t.Errorf("\"2\" listings from $12 expected: %d from $%.2f", course.ListingCount, course.LowestPrice)
+	}
+
+	// test that a department includes the listings for every course
+	var department server.DepartmentListings
+	if err = bookTesting.GetTestJSON(bookTesting.DepartmentURL("Anthropology")+"/json", &department); err != nil {
+		t.Fatal(err)
+	}
+	if department.ListingCount != 3 || department.LowestPrice != 5.0 || len(department.Books) != 3 {
+		t.Errorf("\"3\" listings from $5 expected: %d from $%.2f", department.ListingCount, department.LowestPrice)
+	}
+	for _, departmentCourse := range department.Courses {
+		if departmentCourse.CourseID == "19" && departmentCourse.ListingCount != 2 {
+			t.Errorf("\"2\" listings for Anthropology 19 expected: %d", departmentCourse.ListingCount)
+		}
+	}
+
+	// test that the pages show the course listings
+	for _, pageURL := range []string{bookTesting.CourseURL(40), bookTesting.DepartmentURL("Anthropology")} {
+		res, err := http.Get(pageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != 200 || !strings.Contains(string(body), "Course Book") {
+			t.Errorf("%s should show the course listings", pageURL)
+		}
+	}
+
+	// test that unknown courses and departments are not found
+	for _, pageURL := range []string{bookTesting.CourseURL(1000000), bookTesting.DepartmentURL("No Such Department")} {
+		res, err := http.Get(pageURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != 404 {
+			t.Errorf("GET %s 404 expected: %d", pageURL, res.StatusCode)
+		}
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestImportCourses(t *testing.T) {
+	coursesDB, err := gorm.Open("sqlite3", "./sqlite_courses_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("./sqlite_courses_test.db")
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	coursesDB.DropTable(&server.Course{})
+	coursesDB.DropTable(&server.Term{})
+	coursesDB.DropTable(&server.Professor{})
+	coursesDB.DropTable(&server.CourseProfessor{})
+	coursesDB.AutoMigrate(&server.Course{}, &server.Term{}, &server.Professor{}, &server.CourseProfessor{})
+	coursesDB.Create(&server.Course{Department: "Computer Science", CourseID: "31", Professor: "David Smallberg"})
+	coursesDB.Create(&server.Course{Department: "computer science", CourseID: "32", Professor: "Carey Nachenberg"})
+	coursesDB.Create(&server.Course{Department: "Mathematics", CourseID: "31A", Professor: "Staff"})
+
+	catalog := "Subject,Number,First,Last\n" +
+		"Computer Science,31,David,Smallberg\n" +
+		"Computer  Science,32,Carey,Nachenberg\n" +
+		"Computer Science,33,Paul,Eggert\n" +
+		"Computer Science,,Paul,Eggert\n" +
+		"Computer Science,33,Paul,Eggert\n"
+	catalogFile, err := ioutil.TempFile("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(catalogFile.Name())
+	catalogFile.WriteString(catalog)
+	catalogFile.Close()
+
+	args := []string{"--format", "csv", "--map", "department=Subject,course_id=Number,professor_first_name=First,professor_last_name=Last"}
+
+	// test that a dry run reports the changes without saving them
+	var out bytes.Buffer
+	if err := ImportCoursesCommand(coursesDB, append(args, "--dry-run", catalogFile.Name()), &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"Created 1 courses",
+		"row 4: + Computer Science 33 (Paul Eggert)",
+		"Updated 1 courses",
+		"row 3: computer science 32 (Carey Nachenberg) -> Computer Science 32 (Carey Nachenberg)",
+		"Unchanged 1 courses",
+		"Skipped 2 rows",
+		"row 5: Missing course_id column \"Number\"",
+		"row 6: Same course as row 4",
+		"Kept 1 courses that are not in the file",
+		"Dry run, nothing was saved",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Import summary should contain %q:\n%s", line, out.String())
+		}
+	}
+	var count int
+	coursesDB.Model(&server.Course{}).Count(&count)
+	if count != 3 {
+		t.Errorf("Dry run should not save courses: %d", count)
+	}
+
+	// test that the import upserts the courses
+	out.Reset()
+	if err := ImportCoursesCommand(coursesDB, append(args, catalogFile.Name()), &out); err != nil {
+		t.Fatal(err)
+	}
+	coursesDB.Model(&server.Course{}).Count(&count)
+	if count != 4 {
+		t.Errorf("\"4\" courses expected: %d", count)
+	}
+	var updated server.Course
+	coursesDB.Where("course_id = ?", "32").First(&updated)
+	if updated.ID != 2 || updated.Department != "Computer Science" {
+		t.Errorf("Course 2 should be updated in place: %+v", updated)
+	}
+	var eggert server.Professor
+	coursesDB.Where("last_name = ?", "Eggert").First(&eggert)
+	coursesDB.Model(&server.CourseProfessor{}).Where("professor_id = ?", eggert.ID).Count(&count)
+	if eggert.FirstName != "Paul" || count != 1 {
+		t.Errorf("The new course should be linked to its professor: %+v, %d courses", eggert, count)
+	}
+
+	// test that importing the same catalog again changes nothing, even for courses from before terms and
+	// campuses were added, which have NULL instead of 0
+	coursesDB.Exec("UPDATE courses SET term_id = NULL, institution_id = NULL")
+	report, err := server.ImportCourses(coursesDB, strings.NewReader(`[
+		{"department": "Computer Science", "course_id": 31, "professor": "David Smallberg"},
+		{"department": "Computer Science", "course_id": "33", "professor": "Paul Eggert"}
+	]`), "json", server.CourseColumnMapping{}, server.Institution{}, server.Term{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 || len(report.Updated) != 0 || report.Unchanged != 2 || report.Missing != 2 {
+		t.Errorf("\"2\" unchanged courses expected: %+v", report)
+	}
+
+	// test that a catalog for a new term creates the term and adds its own courses
+	out.Reset()
+	termArgs := append(args, "--term", "Fall 2026", "--term-start", "2026-09-24", "--term-end", "2026-12-11", catalogFile.Name())
+	if err := ImportCoursesCommand(coursesDB, termArgs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Created term Fall 2026") || !strings.Contains(out.String(), "Created 3 courses") {
+		t.Errorf("Import should create the term and its courses:\n%s", out.String())
+	}
+	var term server.Term
+	if result := coursesDB.Where("name = ?", "Fall 2026").First(&term); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	coursesDB.Model(&server.Course{}).Where("term_id = ?", term.ID).Count(&count)
+	if count != 3 {
+		t.Errorf("\"3\" courses in Fall 2026 expected: %d", count)
+	}
+
+	// test that a term that does not exist needs dates
+	if err := ImportCoursesCommand(coursesDB, append(args, "--term", "Winter 2027", catalogFile.Name()), &out); err == nil {
+		t.Error("Importing a new term without dates should fail")
+	}
+}
+
+func TestCourseTerms(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+
+	now := time.Now()
+	lastTerm := server.Term{Name: "Test Last Term", StartsOn: now.AddDate(0, -6, 0), EndsOn: now.AddDate(0, -3, 0)}
+	currentTerm := server.Term{Name: "Test Current Term", StartsOn: now.AddDate(0, 0, -7), EndsOn: now.AddDate(0, 2, 0)}
+	coursesDB.Create(&lastTerm)
+	coursesDB.Create(&currentTerm)
+	lastCourse := server.Course{Department: "Test Terms", CourseID: "1", Professor: "Last Professor", TermID: lastTerm.ID}
+	currentCourse := server.Course{Department: "Test Terms", CourseID: "1", Professor: "Current Professor", TermID: currentTerm.ID}
+	everyTermCourse := server.Course{Department: "Test Terms", CourseID: "1", Professor: "Every Professor"}
+	for _, course := range []*server.Course{&lastCourse, &currentCourse, &everyTermCourse} {
+		coursesDB.Create(course)
+	}
+	// courses from before terms were added have a NULL term
+	coursesDB.Exec("UPDATE courses SET term_id = NULL WHERE id = ?", everyTermCourse.ID)
+	defer func() {
+		coursesDB.Where("department = ?", "Test Terms").Delete(server.Course{})
+		coursesDB.Where("id IN (?)", []int{lastTerm.ID, currentTerm.ID}).Delete(server.Term{})
+	}()
+
+	professors := func(term string) []string {
+		params := url.Values{}
+		params.Set("type", "professor")
+		params.Set("department", "Test Terms")
+		params.Set("course_id", "1")
+		if term != "" {
+			params.Set("term", term)
+		}
+		var courses []server.Course
+		if err := bookTesting.GetTestJSON(bookTesting.CourseSearchURL(params), &courses); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(courses))
+		for i, course := range courses {
+			names[i] = course.Professor
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	// test that the course search defaults to the current term and can switch terms
+	expected := map[string][]string{
+		"":                        {"Current Professor", "Every Professor"},
+		strconv.Itoa(lastTerm.ID): {"Every Professor", "Last Professor"},
+		server.AllTerms:           {"Current Professor", "Every Professor", "Last Professor"},
+	}
+	for term, names := range expected {
+		if found := professors(term); !reflect.DeepEqual(found, names) {
+			t.Errorf("Term %q should find %v: %v", term, names, found)
+		}
+	}
+
+	// test that a listing can name the term the book was used in
+	book := server.Book{Title: "Term Book", ISBN: "0735619670", CourseID: lastCourse.ID, Price: 10.0,
+		Condition: server.ConditionGood, TermID: lastTerm.ID}
+	if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	missingTermBook := book
+	missingTermBook.TermID = lastTerm.ID + currentTerm.ID
+	if err = bookTesting.MakeTestBook(missingTermBook, loginCookie); err == nil {
+		t.Error("Listing a book used in a term that does not exist should fail")
+	}
+	var savedBook server.Book
+	bookTesting.DB.Where("title = ?", "Term Book").First(&savedBook)
+	if savedBook.TermID != lastTerm.ID {
+		t.Errorf("Book should be used in term %d: %d", lastTerm.ID, savedBook.TermID)
+	}
+	res, err := http.Get(bookTesting.ShowBookURL(savedBook.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "Used in: Test Last Term") {
+		t.Error("Book page should show the term the book was used in")
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestCourseTextbooks(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	defer coursesDB.Where("course_id BETWEEN 39 AND 43").Delete(server.CourseTextbook{})
+
+	// courses 39 to 43 are all Anthropology 19 with different professors
+	var course server.Course
+	coursesDB.First(&course, 39)
+	adoptions := "Subject,Course,Instructor,ISBN,Req/Opt\n" +
+		"Anthropology,19,,978-0-7356-1967-8,Required\n" +
+		"Anthropology,19," + course.Professor + ",0131103628,Recommended\n" +
+		"Anthropology,19,No Such Professor,0131103628,Required\n" +
+		"Anthropology,19,,12345,Required\n"
+	adoptionFile, err := ioutil.TempFile("", "adoptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(adoptionFile.Name())
+	adoptionFile.WriteString(adoptions)
+	adoptionFile.Close()
+
+	// test that the adoption list adds the textbooks to the matching courses
+	var out bytes.Buffer
+	args := []string{"--format", "csv", "--map", "department=Subject,course_id=Course,professor=Instructor,isbn=ISBN,required=Req/Opt",
+		adoptionFile.Name()}
+	if err := ImportTextbooksCommand(coursesDB, args, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Created 6 textbooks", "Skipped 2 rows", "row 4: No course Anthropology 19 No Such Professor"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Import summary should contain %q:\n%s", line, out.String())
+		}
+	}
+
+	testBooks := []server.Book{
+		{Title: "Code Complete", ISBN: "0735619670", CourseID: 100, Price: 30.0, Condition: server.ConditionGood},
+		{Title: "Code Complete", ISBN: "0735619670", CourseID: 39, Price: 25.0, Condition: server.ConditionLikeNew},
+	}
+	for _, book := range testBooks {
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// test that the textbooks show the listings for their ISBN from any course and the cheapest listing
+	var textbooks server.CourseTextbooks
+	if err = bookTesting.GetTestJSON(bookTesting.CourseURL(39)+"/textbooks/json", &textbooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(textbooks.Textbooks) != 2 {
+		t.Fatalf("\"2\" textbooks expected: %+v", textbooks.Textbooks)
+	}
+	required, optional := textbooks.Textbooks[0], textbooks.Textbooks[1]
+	if !required.Required || required.ISBN != "9780735619678" || required.ListingCount != 2 || required.LowestPrice != 25.0 {
+		t.Errorf("Required textbook with \"2\" listings from $25 expected: %+v", required)
+	}
+	if required.Cheapest == nil || required.Cheapest.Price != 25.0 || required.Title != "Code Complete" {
+		t.Errorf("Cheapest listing for $25 expected: %+v", required.Cheapest)
+	}
+	if optional.Required || optional.ISBN != "9780131103627" || optional.ListingCount != 0 || optional.Cheapest != nil {
+		t.Errorf("Optional textbook without listings expected: %+v", optional)
+	}
+
+	res, err := http.Get(bookTesting.CourseURL(40) + "/textbooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || !strings.Contains(string(body), "$25.00") || strings.Contains(string(body), "Optional") {
+		t.Errorf("Course 40 should only show the required textbook:\n%s", body)
+	}
+
+	// test that importing the list again changes nothing
+	out.Reset()
+	if err := ImportTextbooksCommand(coursesDB, args, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Created 0 textbooks") || !strings.Contains(out.String(), "Unchanged 6 textbooks") {
+		t.Errorf("Importing again should change nothing:\n%s", out.String())
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestCourseQuery(t *testing.T) {
+	testUser := server.User{
+		Firstname: "Test",
+		Lastname:  "User",
+		Email:     "testuser@gmail.com",
+		Phone:     123456789,
+	}
+	if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+		t.Fatal(err)
+	}
+	loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var user server.User
+	bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&user)
+
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	defer coursesDB.Where("alias = ?", "compsci").Delete(server.DepartmentAlias{})
+	defer coursesDB.Where("course_id IN (?)", []string{"M51A", "M16"}).Delete(server.CrossListing{})
+
+	aliases := "alias,department,course_id,cross_listing\n" +
+		"compsci,Computer Science,,\n" +
+		",Computer Science,m51a,Logic Design\n" +
+		",Electrical Engineering,M16,logic design\n" +
+		",No Such Department,1,logic design\n"
+	aliasFile, err := ioutil.TempFile("", "aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(aliasFile.Name())
+	aliasFile.WriteString(aliases)
+	aliasFile.Close()
+
+	// test that the aliases and cross listings are added for the departments in the catalog
+	var out bytes.Buffer
+	args := []string{"--format", "csv", aliasFile.Name()}
+	if err := ImportAliasesCommand(coursesDB, args, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Created 1 aliases", "Created 2 cross listings", "row 5: No department No Such Department"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Import summary should contain %q:\n%s", line, out.String())
+		}
+	}
+	out.Reset()
+	if err := ImportAliasesCommand(coursesDB, args, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Created 0 aliases") || !strings.Contains(out.String(), "Unchanged 3 rows") {
+		t.Errorf("Importing again should change nothing:\n%s", out.String())
+	}
+
+	search := func(query string) []string {
+		var courses []server.Course
+		params := url.Values{"type": {"query"}, "query": {query}, "term": {server.AllTerms}}
+		if err := bookTesting.GetTestJSON(bookTesting.CourseSearchURL(params), &courses); err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, len(courses))
+		for i, course := range courses {
+			names[i] = course.Department + " " + course.CourseID + " " + course.Professor
+		}
+		return names
+	}
+
+	// test that free text queries resolve abbreviations, aliases and cross listings to courses
+	expected := map[string][]string{
+		"cs31 smallberg":  {"Computer Science 31 David A. Smallberg"},
+		"COM SCI 31":      {"Computer Science 31 David A. Smallberg"},
+		"compsci 31":      {"Computer Science 31 David A. Smallberg"},
+		"cs m51a yutao":   {"Computer Science M51A Yutao He", "Electrical Engineering M16 Yutao He"},
+		"ee m16 yutao he": {"Computer Science M51A Yutao He", "Electrical Engineering M16 Yutao He"},
+	}
+	for query, names := range expected {
+		if found := search(query); !reflect.DeepEqual(found, names) {
+			t.Errorf("Query %q should find %v: %v", query, names, found)
+		}
+	}
+
+	var departments []server.Course
+	params := url.Values{"type": {"department"}, "department": {"cs"}, "term": {server.AllTerms}}
+	if err := bookTesting.GetTestJSON(bookTesting.CourseSearchURL(params), &departments); err != nil {
+		t.Fatal(err)
+	}
+	// both Computer Science and Communication Studies have the initials "cs"
+	if len(departments) < 2 || departments[0].Department != "Communication Studies" || departments[1].Department != "Computer Science" {
+		t.Errorf("The departments with the initials \"cs\" should come first: %+v", departments)
+	}
+
+	// test that a listing search for a course finds the books of its cross listed courses
+	var crossListed server.Course
+	coursesDB.Where("department = ? AND course_id = ?", "Electrical Engineering", "M16").First(&crossListed)
+	book := server.Book{Title: "Digital Design", ISBN: "0131103628", CourseID: crossListed.ID, Price: 40.0,
+		Condition: server.ConditionGood}
+	if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	results, err := bookTesting.SearchTestBooksWith(url.Values{"query": {"cs m51a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results.Books) != 1 || results.Books[0].Title != "Digital Design" || len(results.Courses) != 4 {
+		t.Errorf("\"cs m51a\" should find the book of Electrical Engineering M16 with \"4\" courses: %+v %+v", results.Books, results.Courses)
+	}
+
+	// test that a query that names a course still finds the books whose titles match it
+	// course 39 is Anthropology 19
+	notes := server.Book{Title: "CS M51A Review Notes", ISBN: "0735619670", CourseID: 39, Price: 5.0,
+		Condition: server.ConditionGood}
+	if err = bookTesting.MakeTestBook(notes, loginCookie); err != nil {
+		t.Fatal(err)
+	}
+	if results, err = bookTesting.SearchTestBooksWith(url.Values{"query": {"cs m51a"}}); err != nil {
+		t.Fatal(err)
+	}
+	titles := []string{}
+	for _, book := range results.Books {
+		titles = append(titles, book.Title)
+	}
+	sort.Strings(titles)
+	if !reflect.DeepEqual(titles, []string{"CS M51A Review Notes", "Digital Design"}) {
+		t.Errorf("\"cs m51a\" should find the books of the course and the books with matching titles: %v", titles)
+	}
+
+	// test that paging through the results does not drop or repeat the unranked books of the course
+	for _, title := range []string{"Digital Logic", "Logic Design Lab Manual"} {
+		book.Title = title
+		if err = bookTesting.MakeTestBook(book, loginCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paged := map[string]int{}
+	params = url.Values{"query": {"cs m51a"}, "page_size": {"1"}}
+	for pages := 0; pages < 5; pages++ {
+		if results, err = bookTesting.SearchTestBooksWith(params); err != nil {
+			t.Fatal(err)
+		}
+		for _, book := range results.Books {
+			paged[book.Title]++
+		}
+		if results.Page.Next == "" {
+			break
+		}
+		params.Set("cursor", results.Page.Next)
+	}
+	if len(paged) != 4 {
+		t.Errorf("\"4\" books expected over all pages: %v", paged)
+	}
+	for title, count := range paged {
+		if count != 1 {
+			t.Errorf("%q should be on exactly one page: %d", title, count)
+		}
+	}
+
+	// Delete mock created user and books
+	bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+	bookTesting.DB.Delete(&user)
+}
+
+func TestProfessors(t *testing.T) {
+	// test that professor names are split into their parts
+	names := map[string]server.Professor{
+		"David A. Smallberg":    {FirstName: "David", MiddleName: "A.", LastName: "Smallberg"},
+		"Smallberg, David A.":   {FirstName: "David", MiddleName: "A.", LastName: "Smallberg"},
+		"Alicia Gaspar De Alba": {FirstName: "Alicia", MiddleName: "Gaspar", LastName: "De Alba"},
+		"Staff":                 {LastName: "Staff"},
+	}
+	for name, expected := range names {
+		if professor := server.ParseProfessorName(name); professor != expected {
+			t.Errorf("%q should be %+v: %+v", name, expected, professor)
+		}
+	}
+
+	// test that professor searches match the parts of the professor's name
+	search := func(professor string) []string {
+		var courses []server.Course
+		params := url.Values{"type": {"professor"}, "department": {"Computer Science"}, "course_id": {"31"},
+			"professor": {professor}, "term": {server.AllTerms}}
+		if err := bookTesting.GetTestJSON(bookTesting.CourseSearchURL(params), &courses); err != nil {
+			t.Fatal(err)
+		}
+		found := make([]string, len(courses))
+		for i, course := range courses {
+			found[i] = course.Professor
+		}
+		return found
+	}
+	for _, professor := range []string{"smallberg", "Smallb", "david smallberg", "D. A. Smallberg"} {
+		if found := search(professor); !reflect.DeepEqual(found, []string{"David A. Smallberg"}) {
+			t.Errorf("Searching for %q should find David A. Smallberg: %v", professor, found)
+		}
+	}
+	if found := search("avid"); len(found) != 0 {
+		t.Errorf("First names should only match from their start: %v", found)
+	}
+
+	coursesDB, err := gorm.Open("sqlite3", "./sqlite_professors_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("./sqlite_professors_test.db")
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	coursesDB.AutoMigrate(&server.Course{}, &server.Term{}, &server.Professor{}, &server.CourseProfessor{})
+	for _, course := range []server.Course{
+		{Department: "Electrical Engineering", CourseID: "180D", Professor: "Mani B. Srivastava"},
+		{Department: "Electrical Engineering", CourseID: "M16", Professor: "Mani Srivastava"},
+		{Department: "Electrical Engineering", CourseID: "209AS", Professor: "M. Srivastava"},
+		{Department: "History", CourseID: "1A", Professor: "John Smith"},
+		{Department: "History", CourseID: "1B", Professor: "Jane Smith"},
+		{Department: "History", CourseID: "1C", Professor: "J. Smith"},
+	} {
+		coursesDB.Create(&course)
+	}
+	if err := server.MigrateProfessors(coursesDB); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	coursesDB.Model(&server.Professor{}).Count(&count)
+	if count != 6 {
+		t.Errorf("\"6\" professors expected: %d", count)
+	}
+
+	// test that a dry run finds the spellings of one professor but not names that can be two people
+	var out bytes.Buffer
+	if err := DedupeProfessorsCommand(coursesDB, []string{"--dry-run"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"Mani B. Srivastava (1)", "  - Mani Srivastava (2)", "  - M. Srivastava (3)",
+		"Merged 2 professors into 1 professors", "Dry run, nothing was saved"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Dedupe summary should contain %q:\n%s", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "Smith") {
+		t.Errorf("J. Smith can be John Smith or Jane Smith and should not be merged:\n%s", out.String())
+	}
+
+	// test that deduping merges the courses into the fullest spelling
+	out.Reset()
+	if err := DedupeProfessorsCommand(coursesDB, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Moved 2 courses") {
+		t.Errorf("Dedupe should move 2 courses:\n%s", out.String())
+	}
+	var professors []string
+	coursesDB.Model(&server.Course{}).Where("department = ?", "Electrical Engineering").Order("id").Pluck("professor", &professors)
+	if !reflect.DeepEqual(professors, []string{"Mani B. Srivastava", "Mani B. Srivastava", "Mani B. Srivastava"}) {
+		t.Errorf("Merged courses should use the kept name: %v", professors)
+	}
+	coursesDB.Model(&server.CourseProfessor{}).Where("professor_id = ?", 1).Count(&count)
+	if count != 3 {
+		t.Errorf("\"3\" courses of the kept professor expected: %d", count)
+	}
+
+	// test that importing a merged spelling finds the kept professor
+	report, err := server.ImportCourses(coursesDB, strings.NewReader(`[
+		{"department": "Electrical Engineering", "course_id": "M16", "professor": "Mani Srivastava"}
+	]`), "json", server.CourseColumnMapping{}, server.Institution{}, server.Term{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Created) != 0 || report.Unchanged != 1 {
+		t.Errorf("The merged spelling should match the course of the kept professor: %+v", report)
+	}
+
+	// test that professors can be merged by hand and only once
+	out.Reset()
+	if err := MergeProfessorsCommand(coursesDB, []string{"6", "4"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var merged server.Course
+	coursesDB.Where("course_id = ?", "1C").First(&merged)
+	if merged.Professor != "John Smith" {
+		t.Errorf("J. Smith should be renamed to John Smith: %+v", merged)
+	}
+	if err := MergeProfessorsCommand(coursesDB, []string{"6", "5"}, &out); err == nil {
+		t.Error("A merged professor should not be merged again")
+	}
+}
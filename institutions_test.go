@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/DarinM223/bookcycle/server"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestInstitutions(t *testing.T) {
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+
+	// the catalog courses belong to UCLA, the default campus
+	var ucla server.Institution
+	if result := coursesDB.Where("email_domain = ?", server.DefaultInstitutionDomain).First(&ucla); result.Error != nil {
+		t.Fatal(result.Error)
+	}
+	campus := server.Institution{Name: "Test Campus", EmailDomain: "testcampus.edu"}
+	coursesDB.Create(&campus)
+	campusCourse := server.Course{Department: "Test Campus Studies", CourseID: "1", InstitutionID: campus.ID}
+	coursesDB.Create(&campusCourse)
+	defer func() {
+		coursesDB.Delete(&campusCourse)
+		coursesDB.Delete(&campus)
+	}()
+
+	// test that users are assigned to the campus of their email domain
+	users := []server.User{
+		{Firstname: "Bruin", Lastname: "User", Email: "bruin@g.ucla.edu", Phone: 123456789},
+		{Firstname: "Campus", Lastname: "User", Email: "student@testcampus.edu", Phone: 123456789},
+	}
+	cookies := make([]*http.Cookie, len(users))
+	for i, testUser := range users {
+		if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+			t.Fatal(err)
+		}
+		bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&users[i])
+		if users[i].InstitutionID != 0 || users[i].EmailVerified {
+			t.Errorf("Users should not be on a campus before verifying their email: %+v", users[i])
+		}
+		if err := bookTesting.VerifyTestUser(testUser.Email); err != nil {
+			t.Fatal(err)
+		}
+		loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies[i] = loginCookie
+		bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&users[i])
+	}
+	bruin, student := users[0], users[1]
+	bruinCookie, studentCookie := cookies[0], cookies[1]
+	if bruin.InstitutionID != ucla.ID || student.InstitutionID != campus.ID {
+		t.Errorf("Users should be at campuses %d and %d: %d and %d", ucla.ID, campus.ID, bruin.InstitutionID, student.InstitutionID)
+	}
+
+	// test that books are on the campus of their course
+	testBooks := []struct {
+		book   server.Book
+		cookie *http.Cookie
+	}{
+		// course 39 is Anthropology 19
+		{server.Book{Title: "Bruin Book", ISBN: "0735619670", CourseID: 39, Price: 20.0, Condition: server.ConditionGood}, bruinCookie},
+		{server.Book{Title: "Campus Book", ISBN: "0131103628", CourseID: campusCourse.ID, Price: 15.0, Condition: server.ConditionGood}, studentCookie},
+	}
+	for _, test := range testBooks {
+		if err := bookTesting.MakeTestBook(test.book, test.cookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var campusBook server.Book
+	bookTesting.DB.Where("title = ?", "Campus Book").First(&campusBook)
+	if campusBook.InstitutionID != campus.ID {
+		t.Errorf("Book should be at campus %d: %d", campus.ID, campusBook.InstitutionID)
+	}
+
+	// test that recent books and search are limited to the campus of the user unless every campus is asked for
+	for _, campusParam := range []string{"", server.AllCampuses} {
+		body, err := bookTesting.GetTestPageWithCookie(bookTesting.Server.URL+"/?view=recent&campus="+campusParam, studentCookie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(body, `alt="Campus Book"`) || strings.Contains(body, `alt="Bruin Book"`) != (campusParam != "") {
+			t.Errorf("Recent books with campus %q should show the Bruin book only for every campus", campusParam)
+		}
+
+		var results server.BookSearchResults
+		params := url.Values{"query": {"book"}, "campus": {campusParam}}
+		if err := bookTesting.GetTestJSONWithCookie(bookTesting.SearchBooksURL(params), bruinCookie, &results); err != nil {
+			t.Fatal(err)
+		}
+		expected := 1
+		if campusParam != "" {
+			expected = 2
+		}
+		if len(results.Books) != expected {
+			t.Errorf("\"%d\" search results expected for campus %q: %d", expected, campusParam, len(results.Books))
+		}
+
+		var courses []server.Course
+		params = url.Values{"type": {"department"}, "department": {"Test Campus"}, "campus": {campusParam}}
+		if err := bookTesting.GetTestJSONWithCookie(bookTesting.CourseSearchURL(params), bruinCookie, &courses); err != nil {
+			t.Fatal(err)
+		}
+		if (len(courses) == 1) != (campusParam != "") {
+			t.Errorf("The other campus's courses should only be found in every campus: %+v", courses)
+		}
+	}
+
+	// test that a changed email has to be verified again before the user is back on a campus
+	movedUser := student
+	movedUser.Email = "moved@testcampus.edu"
+	if err := bookTesting.EditTestUser(movedUser, studentCookie, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	bookTesting.DB.First(&movedUser, student.ID)
+	if movedUser.InstitutionID != 0 || movedUser.EmailVerified {
+		t.Errorf("Users should leave their campus until a changed email is verified: %+v", movedUser)
+	}
+	if err := bookTesting.VerifyTestUser(movedUser.Email); err != nil {
+		t.Fatal(err)
+	}
+	bookTesting.DB.First(&movedUser, student.ID)
+	if movedUser.InstitutionID != campus.ID || !movedUser.EmailVerified {
+		t.Errorf("User should be at campus %d once the changed email is verified: %+v", campus.ID, movedUser)
+	}
+	if res, err := http.Get(bookTesting.Server.URL + "/users/verify?token=" + "not-a-token"); err != nil || res.StatusCode != http.StatusNotFound {
+		t.Error("Verifying with an unknown token should be 404")
+	}
+
+	// Delete mock created users and books
+	for _, user := range users {
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+		bookTesting.DB.Delete(&user)
+	}
+}
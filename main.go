@@ -1,58 +1,387 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/DarinM223/bookcycle/server"
 	"github.com/lib/pq"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
-	"database/sql"
 	"github.com/jinzhu/gorm"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const RequestsPerMinute int = 30
 
-// SeedCourses seeds the main database with the courses inside the seed database
-func SeedCourses(mainDB gorm.DB, seedDB *sql.DB) error {
-	var (
-		id                 int
-		department         string
-		courseID           string
-		professorLastName  string
-		professorFirstName string
-		createdAt          time.Time
-		updatedAt          time.Time
-	)
-
-	rows, err := seedDB.Query("SELECT * FROM courses")
+// formatCourse formats a course for the import summary
+func formatCourse(course server.Course) string {
+	if course.Professor == "" {
+		return course.Department + " " + course.CourseID
+	}
+	return fmt.Sprintf("%s %s (%s)", course.Department, course.CourseID, course.Professor)
+}
+
+// catalogFormat returns the format of a catalog file, which defaults to the file extension
+func catalogFormat(path, format string) string {
+	if format == "" {
+		return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	}
+	return format
+}
+
+// ImportCoursesCommand runs "bookcycle import-courses [--format csv|json] [--map field=column,...] [--campus DOMAIN] [--term NAME] [--dry-run] FILE",
+// which upserts the courses in a catalog file into the course database and writes a summary of the changes to out
+// A campus that does not exist yet is created from --campus-name and a term from --term-start and --term-end
+func ImportCoursesCommand(coursesDB gorm.DB, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import-courses", flag.ContinueOnError)
+	flags.SetOutput(out)
+	format := flags.String("format", "", "catalog format, csv or json (defaults to the file extension)")
+	columns := flags.String("map", "", "comma separated field=column pairs for catalog columns that are not named after the fields "+
+		"(fields: "+strings.Join(server.CourseFields, ", ")+")")
+	dryRun := flags.Bool("dry-run", false, "report the changes without saving them")
+	campusDomain := flags.String("campus", "", "email domain of the campus the catalog is for, like ucla.edu (courses without a campus are shared)")
+	campusName := flags.String("campus-name", "", "name of the campus, to create a new campus")
+	termName := flags.String("term", "", "name of the term the catalog is for, like \"Fall 2026\" (courses without a term are offered every term)")
+	termStart := flags.String("term-start", "", "first day of the term as YYYY-MM-DD, to create a new term")
+	termEnd := flags.String("term-end", "", "last day of the term as YYYY-MM-DD, to create a new term")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: bookcycle import-courses [--format csv|json] [--map field=column,...] [--campus DOMAIN [--campus-name NAME]] " +
+			"[--term NAME [--term-start YYYY-MM-DD --term-end YYYY-MM-DD]] [--dry-run] FILE")
+	}
+
+	var institution server.Institution
+	if *campusDomain != "" {
+		var err error
+		if institution, err = server.InstitutionByDomain(coursesDB, *campusDomain, *campusName); err != nil {
+			return err
+		}
+	}
+
+	var term server.Term
+	if *termName != "" {
+		var startsOn, endsOn time.Time
+		var err error
+		if *termStart != "" {
+			if startsOn, err = time.Parse("2006-01-02", *termStart); err != nil {
+				return errors.New("--term-start has to be a date like 2026-09-24")
+			}
+		}
+		if *termEnd != "" {
+			if endsOn, err = time.Parse("2006-01-02", *termEnd); err != nil {
+				return errors.New("--term-end has to be a date like 2026-12-11")
+			}
+		}
+		if term, err = server.TermByName(coursesDB, *termName, startsOn, endsOn); err != nil {
+			return err
+		}
+	}
+
+	path := flags.Arg(0)
+	mapping, err := server.ParseCourseColumnMapping(*columns)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	for rows.Next() {
-		err := rows.Scan(&id, &department, &courseID,
-			&professorLastName, &professorFirstName, &createdAt, &updatedAt)
-		if err != nil {
+	report, err := server.ImportCourses(coursesDB, file, catalogFormat(path, *format), mapping, institution, term, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if institution.Name != "" && institution.ID == 0 {
+		fmt.Fprintf(out, "Created campus %s (%s)\n", institution.Name, institution.EmailDomain)
+	}
+	if term.Name != "" && term.ID == 0 {
+		fmt.Fprintf(out, "Created term %s\n", term.Name)
+	}
+	fmt.Fprintf(out, "Created %d courses\n", len(report.Created))
+	for _, change := range report.Created {
+		fmt.Fprintf(out, "  row %d: + %s\n", change.Row, formatCourse(change.New))
+	}
+	fmt.Fprintf(out, "Updated %d courses\n", len(report.Updated))
+	for _, change := range report.Updated {
+		fmt.Fprintf(out, "  row %d: %s -> %s\n", change.Row, formatCourse(change.Old), formatCourse(change.New))
+	}
+	fmt.Fprintf(out, "Unchanged %d courses\n", report.Unchanged)
+	fmt.Fprintf(out, "Skipped %d rows\n", len(report.Skipped))
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(out, "  row %d: %s\n", skipped.Row, skipped.Reason)
+	}
+	if report.Missing > 0 {
+		fmt.Fprintf(out, "Kept %d courses that are not in the file\n", report.Missing)
+	}
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was saved")
+	}
+	return nil
+}
+
+// ImportTextbooksCommand runs "bookcycle import-textbooks [--format csv|json] [--map field=column,...] [--campus DOMAIN] [--term NAME] [--dry-run] FILE",
+// which upserts the textbooks in a bookstore adoption list into the course database and writes a summary of the changes to out
+func ImportTextbooksCommand(coursesDB gorm.DB, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import-textbooks", flag.ContinueOnError)
+	flags.SetOutput(out)
+	format := flags.String("format", "", "adoption list format, csv or json (defaults to the file extension)")
+	columns := flags.String("map", "", "comma separated field=column pairs for columns that are not named after the fields "+
+		"(fields: "+strings.Join(server.TextbookFields, ", ")+")")
+	dryRun := flags.Bool("dry-run", false, "report the changes without saving them")
+	campusDomain := flags.String("campus", "", "email domain of the campus the adoption list is for (defaults to matching courses of every campus)")
+	termName := flags.String("term", "", "name of the term the adoption list is for (defaults to matching courses of every term)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: bookcycle import-textbooks [--format csv|json] [--map field=column,...] [--campus DOMAIN] [--term NAME] [--dry-run] FILE")
+	}
+
+	var institution server.Institution
+	if *campusDomain != "" {
+		var err error
+		if institution, err = server.InstitutionByDomain(coursesDB, *campusDomain, ""); err != nil {
 			return err
 		}
+	}
+	var term server.Term
+	if *termName != "" {
+		var err error
+		if term, err = server.TermByName(coursesDB, *termName, time.Time{}, time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	path := flags.Arg(0)
+	mapping, err := server.ParseTextbookColumnMapping(*columns)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := server.ImportTextbooks(coursesDB, file, catalogFormat(path, *format), mapping, institution, term, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	formatTextbook := func(change server.TextbookChange) string {
+		required := "required"
+		if !change.New.Required {
+			required = "optional"
+		}
+		return fmt.Sprintf("%s %s for %s", change.New.ISBN, required, formatCourse(change.Course))
+	}
+	fmt.Fprintf(out, "Created %d textbooks\n", len(report.Created))
+	for _, change := range report.Created {
+		fmt.Fprintf(out, "  row %d: + %s\n", change.Row, formatTextbook(change))
+	}
+	fmt.Fprintf(out, "Updated %d textbooks\n", len(report.Updated))
+	for _, change := range report.Updated {
+		fmt.Fprintf(out, "  row %d: %s\n", change.Row, formatTextbook(change))
+	}
+	fmt.Fprintf(out, "Unchanged %d textbooks\n", report.Unchanged)
+	fmt.Fprintf(out, "Skipped %d rows\n", len(report.Skipped))
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(out, "  row %d: %s\n", skipped.Row, skipped.Reason)
+	}
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was saved")
+	}
+	return nil
+}
+
+// ImportAliasesCommand runs "bookcycle import-aliases [--format csv|json] [--campus DOMAIN] [--dry-run] FILE",
+// which adds the department aliases and cross listings in a file to the course database and writes a summary to out
+func ImportAliasesCommand(coursesDB gorm.DB, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("import-aliases", flag.ContinueOnError)
+	flags.SetOutput(out)
+	format := flags.String("format", "", "file format, csv or json (defaults to the file extension)")
+	dryRun := flags.Bool("dry-run", false, "report the changes without saving them")
+	campusDomain := flags.String("campus", "", "email domain of the campus the aliases are for (aliases without a campus are shared)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: bookcycle import-aliases [--format csv|json] [--campus DOMAIN] [--dry-run] FILE")
+	}
+
+	var institution server.Institution
+	if *campusDomain != "" {
+		var err error
+		if institution, err = server.InstitutionByDomain(coursesDB, *campusDomain, ""); err != nil {
+			return err
+		}
+	}
+
+	path := flags.Arg(0)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	report, err := server.ImportCourseAliases(coursesDB, file, catalogFormat(path, *format), institution, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Created %d aliases\n", len(report.Aliases))
+	for _, alias := range report.Aliases {
+		fmt.Fprintf(out, "  + %s: %s\n", alias.Alias, alias.Department)
+	}
+	fmt.Fprintf(out, "Created %d cross listings\n", len(report.CrossListings))
+	for _, listing := range report.CrossListings {
+		fmt.Fprintf(out, "  + %s %s in group %d\n", listing.Department, listing.CourseID, listing.GroupID)
+	}
+	fmt.Fprintf(out, "Unchanged %d rows\n", report.Unchanged)
+	fmt.Fprintf(out, "Skipped %d rows\n", len(report.Skipped))
+	for _, skipped := range report.Skipped {
+		fmt.Fprintf(out, "  row %d: %s\n", skipped.Row, skipped.Reason)
+	}
+	if report.DryRun {
+		fmt.Fprintln(out, "Dry run, nothing was saved")
+	}
+	return nil
+}
+
+// DedupeProfessorsCommand runs "bookcycle dedupe-professors [--dry-run]", which merges the professors whose
+// names are spelled more than one way into their fullest spelling and writes the merges to out
+func DedupeProfessorsCommand(coursesDB gorm.DB, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("dedupe-professors", flag.ContinueOnError)
+	flags.SetOutput(out)
+	dryRun := flags.Bool("dry-run", false, "report the merges without saving them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errors.New("Usage: bookcycle dedupe-professors [--dry-run]")
+	}
+
+	duplicates, err := server.FindDuplicateProfessors(coursesDB)
+	if err != nil {
+		return err
+	}
+	merged, moved := 0, 0
+	for _, found := range duplicates {
+		fmt.Fprintf(out, "%s (%d)\n", found.Professor.Name(), found.Professor.ID)
+		for _, duplicate := range found.Duplicates {
+			fmt.Fprintf(out, "  - %s (%d)\n", duplicate.Name(), duplicate.ID)
+			if *dryRun {
+				continue
+			}
+			courses, err := server.MergeProfessors(coursesDB, duplicate.ID, found.Professor.ID)
+			if err != nil {
+				return err
+			}
+			moved += courses
+		}
+		merged += len(found.Duplicates)
+	}
 
-		course := server.Course{
-			ID:         id,
-			Department: department,
-			CourseID:   courseID,
-			Professor:  professorFirstName + " " + professorLastName,
-			CreatedAt:  createdAt,
-			UpdatedAt:  updatedAt,
+	fmt.Fprintf(out, "Merged %d professors into %d professors\n", merged, len(duplicates))
+	if *dryRun {
+		fmt.Fprintln(out, "Dry run, nothing was saved")
+	} else {
+		fmt.Fprintf(out, "Moved %d courses\n", moved)
+	}
+	return nil
+}
+
+// MergeProfessorsCommand runs "bookcycle merge-professors ID INTO_ID", which merges a professor into another
+// spelling of their name that dedupe-professors did not find, and writes the result to out
+func MergeProfessorsCommand(coursesDB gorm.DB, args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return errors.New("Usage: bookcycle merge-professors ID INTO_ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return err
+	}
+	intoID, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+
+	courses, err := server.MergeProfessors(coursesDB, id, intoID)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Merged professor %d into professor %d and moved %d courses\n", id, intoID, courses)
+	return nil
+}
+
+// MakeAdminCommand runs "bookcycle make-admin [--revoke] EMAIL", which lets the user with an email change
+// the course catalog, or stops them with --revoke, and writes the result to out
+func MakeAdminCommand(db gorm.DB, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("make-admin", flag.ContinueOnError)
+	flags.SetOutput(out)
+	revoke := flags.Bool("revoke", false, "stop the user from changing the course catalog")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Usage: bookcycle make-admin [--revoke] EMAIL")
+	}
+
+	// the server may not have added the admin column yet
+	if result := db.AutoMigrate(&server.User{}); result.Error != nil {
+		return result.Error
+	}
+	if err := server.MigrateAdmins(db); err != nil {
+		return err
+	}
+
+	email := flags.Arg(0)
+	var user server.User
+	if result := db.Where("email = ?", email).First(&user); result.Error != nil {
+		if result.RecordNotFound() {
+			return errors.New("There is no user with the email " + email)
 		}
-		mainDB.Create(&course)
+		return result.Error
+	}
+	if result := db.Model(&user).UpdateColumn("admin", !*revoke); result.Error != nil {
+		return result.Error
+	}
+
+	if *revoke {
+		fmt.Fprintf(out, "%s can no longer change the course catalog\n", email)
+	} else {
+		fmt.Fprintf(out, "%s can now change the course catalog at /admin/courses\n", email)
 	}
 	return nil
 }
 
+// openProductionDB opens the postgres database at DATABASE_URL
+func openProductionDB() (gorm.DB, error) {
+	connection, _ := pq.ParseURL(os.Getenv("DATABASE_URL"))
+	connection += " sslmode=require"
+	return gorm.Open("postgres", connection)
+}
+
+// setUpMailer sends emails through the SMTP server in SMTP_SERVER (host:port) from the address in
+// EMAIL_FROM, logging in with SMTP_USERNAME and SMTP_PASSWORD. Emails are only logged without SMTP_SERVER
+func setUpMailer() {
+	if addr := os.Getenv("SMTP_SERVER"); addr != "" {
+		server.SetMailer(server.NewSMTPMailer(addr, os.Getenv("EMAIL_FROM"), os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD")))
+	}
+}
+
 // IsTesting returns true if there are any command line arguments with the
 // value "loadtest" and false otherwise. It is used as a parameter to server.Routes()
 // so that rate limiting and csrf are turned off when "loadtest" is a command line argument
@@ -75,35 +404,91 @@ func main() {
 		return
 	}
 	defer coursesDB.Close()
-	coursesDB.AutoMigrate(&server.Course{})
+	coursesDB.AutoMigrate(&server.Course{}, &server.Term{}, &server.CourseTextbook{}, &server.Institution{},
+		&server.DepartmentAlias{}, &server.CrossListing{}, &server.Professor{}, &server.CourseProfessor{})
+	if err = server.MigrateCourses(coursesDB); err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err = server.MigrateProfessors(coursesDB); err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	if len(os.Args) > 1 {
 		option := os.Args[1]
 		if option == "production" { // configure postgres database
 			fmt.Println("Running in production mode")
-			url := os.Getenv("DATABASE_URL")
-			connection, _ := pq.ParseURL(url)
-			connection += " sslmode=require"
-			db, err = gorm.Open("postgres", connection)
+			db, err = openProductionDB()
 			if err != nil {
 				fmt.Println(err)
 				return
 			}
-			db.AutoMigrate(&server.User{}, &server.Book{}, &server.Message{})
-		} else if option == "seed" {
-			fmt.Println("Seeding courses from course sqlite file:")
-			db.LogMode(true)
-			seedDB, err := sql.Open("sqlite3", "./CS188")
-			if err != nil {
-				fmt.Println(err.Error())
+			server.SetSearchIndex(server.NewPostgresSearchIndex(coursesDB))
+			setUpMailer()
+			if err = server.MigrateDB(db); err != nil {
+				fmt.Println(err)
 				return
 			}
-
-			if err = SeedCourses(coursesDB, seedDB); err != nil {
+			// admin changes to the catalog are made again since courses.database is replaced with every deploy
+			if err = server.ApplyCourseAudits(db, coursesDB); err != nil {
 				fmt.Println(err)
 				return
 			}
-			fmt.Println("Finished seeding courses")
+			if err = server.MigrateInstitutions(db, coursesDB); err != nil {
+				fmt.Println(err)
+				return
+			}
+		} else if option == "import-courses" {
+			if err = ImportCoursesCommand(coursesDB, os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
+			return
+		} else if option == "import-textbooks" {
+			if err = ImportTextbooksCommand(coursesDB, os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
+			return
+		} else if option == "make-admin" {
+			// the production database is used when its url is set, like in production mode
+			if os.Getenv("DATABASE_URL") != "" {
+				db, err = openProductionDB()
+			} else {
+				db, err = gorm.Open("sqlite3", "./sqlite_file.db")
+			}
+			if err == nil {
+				err = MakeAdminCommand(db, os.Args[2:], os.Stdout)
+			}
+			if err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
+			return
+		} else if option == "import-aliases" {
+			if err = ImportAliasesCommand(coursesDB, os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
+			return
+		} else if option == "dedupe-professors" {
+			if err = DedupeProfessorsCommand(coursesDB, os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
+			return
+		} else if option == "merge-professors" {
+			if err = MergeProfessorsCommand(coursesDB, os.Args[2:], os.Stdout); err != nil {
+				fmt.Println(err)
+				coursesDB.Close()
+				os.Exit(1)
+			}
 			return
 		}
 	} else { // configure sqlite database
@@ -112,8 +497,25 @@ func main() {
 			fmt.Println(err.Error())
 			return
 		}
-		db.AutoMigrate(&server.User{}, &server.Book{}, &server.Message{})
+		server.SetSearchIndex(server.NewSQLiteSearchIndex(coursesDB))
+		if err = server.MigrateDB(db); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		// admin changes to the catalog are made again since courses.database is replaced with every deploy
+		if err = server.ApplyCourseAudits(db, coursesDB); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
+		if err = server.MigrateInstitutions(db, coursesDB); err != nil {
+			fmt.Println(err.Error())
+			return
+		}
 	}
+	// periodically hide listings that have not been renewed and empty the trash
+	stopSweeper := server.StartBookSweeper(db)
+	defer stopSweeper()
+
 	fmt.Println("Listening...")
 	PORT := os.Getenv("PORT")
 	if PORT == "" {
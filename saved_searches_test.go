@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/DarinM223/bookcycle/server"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSavedSearchNotifications(t *testing.T) {
+	users := []server.User{
+		{Firstname: "Searching", Lastname: "User", Email: "searcher@gmail.com", Phone: 123456789},
+		{Firstname: "Selling", Lastname: "User", Email: "seller@gmail.com", Phone: 123456789},
+	}
+	cookies := make([]*http.Cookie, len(users))
+	for i, testUser := range users {
+		if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+			t.Fatal(err)
+		}
+		loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies[i] = loginCookie
+		bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&users[i])
+	}
+	searcher, seller := users[0], users[1]
+	searcherCookie, sellerCookie := cookies[0], cookies[1]
+
+	savedSearch := server.SavedSearch{Query: "calculus", MaxPrice: 30.0, Condition: server.ConditionGood}
+	if err := bookTesting.SaveTestSearch(savedSearch, searcherCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err := bookTesting.SaveTestSearch(server.SavedSearch{}, searcherCookie); err == nil {
+		t.Error("Saving a search without a query, course or maximum price should fail")
+	}
+	var searches []server.SavedSearch
+	if err := bookTesting.GetTestJSONWithCookie(bookTesting.SavedSearchesURL()+"/json", searcherCookie, &searches); err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 1 || searches[0].Query != "calculus" || searches[0].Condition != server.ConditionGood {
+		t.Fatalf("\"1\" saved search expected: %+v", searches)
+	}
+
+	// test that only the matching book of another user is saved as a notification while the searcher is offline
+	testBooks := []server.Book{
+		{Title: "Calculus", ISBN: "0735619670", CourseID: 1, Price: 25.0, Condition: server.ConditionLikeNew},
+		{Title: "Expensive Calculus", ISBN: "0735619670", CourseID: 1, Price: 50.0, Condition: server.ConditionNew},
+		{Title: "Worn Calculus", ISBN: "0735619670", CourseID: 1, Price: 5.0, Condition: server.ConditionPoor},
+		{Title: "Physics", ISBN: "0735619670", CourseID: 1, Price: 5.0, Condition: server.ConditionGood},
+	}
+	for _, book := range testBooks {
+		if err := bookTesting.MakeTestBook(book, sellerCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bookTesting.MakeTestBook(testBooks[0], searcherCookie); err != nil {
+		t.Fatal(err)
+	}
+
+	server.WaitForNewBooks()
+	var notifications []server.Notification
+	bookTesting.DB.Where("user_id = ?", searcher.ID).Find(&notifications)
+	if len(notifications) != 1 || notifications[0].Delivered {
+		t.Fatalf("\"1\" undelivered notification expected: %+v", notifications)
+	}
+	var matchingBook server.Book
+	bookTesting.DB.Where("user_id = ? AND title = ?", seller.ID, "Calculus").First(&matchingBook)
+	if notifications[0].BookID != matchingBook.ID || notifications[0].SavedSearchID != searches[0].ID {
+		t.Errorf("Notification for book %d expected: %+v", matchingBook.ID, notifications[0])
+	}
+
+	// test that the saved notification is sent when the searcher connects
+	ws, err := bookTesting.DialTestWebsocket(searcherCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	readNotification := func() map[string]interface{} {
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed map[string]interface{}
+		if err := json.Unmarshal(message, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	if pending := readNotification(); pending["type"] != "notification" || int(pending["book_id"].(float64)) != matchingBook.ID {
+		t.Errorf("Pending notification for book %d expected: %v", matchingBook.ID, pending)
+	}
+
+	// test that a new match is sent right away while the searcher is online
+	if err := bookTesting.MakeTestBook(server.Book{Title: "Calculus Workbook", ISBN: "0735619670", CourseID: 2,
+		Price: 10.0, Condition: server.ConditionGood}, sellerCookie); err != nil {
+		t.Fatal(err)
+	}
+	if live := readNotification(); live["type"] != "notification" || live["message"] == "" {
+		t.Errorf("Live notification expected: %v", live)
+	}
+
+	var notificationsPage server.NotificationsPage
+	if err := bookTesting.GetTestJSONWithCookie(bookTesting.NotificationsURL(), searcherCookie, &notificationsPage); err != nil {
+		t.Fatal(err)
+	}
+	if len(notificationsPage.Notifications) != 2 {
+		t.Errorf("\"2\" notifications expected: %d", len(notificationsPage.Notifications))
+	}
+
+	// test that a new match is found when more books than a search returns outrank it
+	fillerTitle := "Calculus Calculus Calculus"
+	tx := bookTesting.DB.Begin()
+	for i := 0; i < 1001; i++ {
+		filler := server.Book{Title: fillerTitle, ISBN: "9780735619678", CourseID: 1, Price: 100.0, UserID: seller.ID}
+		if result := tx.Create(&filler); result.Error != nil {
+			tx.Rollback()
+			t.Fatal(result.Error)
+		}
+	}
+	tx.Exec("INSERT INTO book_search (docid, title, authors, isbn, course, details) "+
+		"SELECT id, title, '', '', '', title FROM books WHERE title = ?", fillerTitle)
+	tx.Commit()
+	if err := bookTesting.MakeTestBook(server.Book{Title: "Lecture Notes", ISBN: "0735619670", CourseID: 2,
+		Details: "notes for calculus", Price: 10.0, Condition: server.ConditionGood}, sellerCookie); err != nil {
+		t.Fatal(err)
+	}
+	if live := readNotification(); live["type"] != "notification" || live["message"] == "" {
+		t.Errorf("Live notification for a book outranked by other matches expected: %v", live)
+	}
+	bookTesting.DB.Exec("DELETE FROM book_search WHERE docid IN (SELECT id FROM books WHERE title = ?)", fillerTitle)
+
+	// test that a saved search id has to be a number
+	deleteURL := bookTesting.SavedSearchesURL() + "/" + url.PathEscape("0 OR 1 = 1") + "/delete"
+	if status, _ := bookTesting.PostTestJSONWithCookie(deleteURL, url.Values{}, searcherCookie, nil); status != http.StatusNotFound {
+		t.Errorf("Deleting a saved search that is not a number should be 404: %d", status)
+	}
+	var count int
+	bookTesting.DB.Model(&server.SavedSearch{}).Where("user_id = ?", searcher.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("\"1\" saved search expected after a rejected delete: %d", count)
+	}
+
+	// Delete mock created users, books, saved searches and notifications
+	for _, user := range users {
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.SavedSearch{})
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Notification{})
+		bookTesting.DB.Delete(&user)
+	}
+}
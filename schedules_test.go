@@ -0,0 +1,172 @@
+package main
+
+import (
+	"github.com/DarinM223/bookcycle/server"
+	"github.com/jinzhu/gorm"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSchedule(t *testing.T) {
+	users := []server.User{
+		{Firstname: "Scheduled", Lastname: "Student", Email: "scheduled@gmail.com", Phone: 123456789},
+		{Firstname: "Selling", Lastname: "Student", Email: "classseller@gmail.com", Phone: 123456789},
+	}
+	cookies := make([]*http.Cookie, len(users))
+	for i, testUser := range users {
+		if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+			t.Fatal(err)
+		}
+		loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies[i] = loginCookie
+		bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&users[i])
+	}
+	studentCookie, sellerCookie := cookies[0], cookies[1]
+
+	coursesDB, err := gorm.Open("sqlite3", TestCoursesDatabase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coursesDB.Close()
+	coursesDB.LogMode(false)
+	// course 860 is Computer Science 31
+	textbooks := []server.CourseTextbook{
+		{CourseID: 860, ISBN: "9780131103627", OriginalISBN: "0131103628", Title: "The C Programming Language", Required: true},
+		{CourseID: 860, ISBN: "9780262033848", OriginalISBN: "9780262033848", Title: "Introduction to Algorithms", Required: true},
+		{CourseID: 860, ISBN: "9780735619678", OriginalISBN: "0735619670", Title: "Code Complete", Required: false},
+	}
+	for i := range textbooks {
+		coursesDB.Create(&textbooks[i])
+		defer coursesDB.Delete(&textbooks[i])
+	}
+	// sections of the course at another campus and retired sections are not the student's course
+	sections := []server.Course{
+		{Department: "Computer Science", CourseID: "31", Professor: "Other Campus"},
+		{Department: "Computer Science", CourseID: "31", Professor: "Retired Section"},
+	}
+	for i := range sections {
+		coursesDB.Create(&sections[i])
+		defer coursesDB.Delete(&sections[i])
+	}
+
+	// test that the listings of other users for the course and for its textbooks are shown
+	testBooks := []struct {
+		book   server.Book
+		cookie *http.Cookie
+	}{
+		{server.Book{Title: "Course Reader", ISBN: "0735619670", CourseID: 860, Price: 12.0, Condition: server.ConditionGood}, sellerCookie},
+		// course 39 is Anthropology 19, but the book is a textbook of the course
+		{server.Book{Title: "K&R", ISBN: "0131103628", CourseID: 39, Price: 30.0, Condition: server.ConditionGood}, sellerCookie},
+		{server.Book{Title: "My Own Reader", ISBN: "0735619670", CourseID: 860, Price: 5.0, Condition: server.ConditionGood}, studentCookie},
+	}
+	for _, test := range testBooks {
+		if err := bookTesting.MakeTestBook(test.book, test.cookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, section := range sections {
+		book := server.Book{Title: "Section Reader", ISBN: "0201633612", CourseID: section.ID, Price: 1.0, Condition: server.ConditionGood}
+		if err := bookTesting.MakeTestBook(book, sellerCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	coursesDB.Model(&sections[0]).UpdateColumn("institution_id", 9999)
+	coursesDB.Model(&sections[1]).UpdateColumn("retired", true)
+
+	if err := bookTesting.AddTestScheduledCourse(860, studentCookie); err != nil {
+		t.Fatal(err)
+	}
+	if err := bookTesting.AddTestScheduledCourse(860, studentCookie); err == nil {
+		t.Error("Adding a course that is already in the schedule should fail")
+	}
+	if err := bookTesting.AddTestScheduledCourse(0, studentCookie); err == nil {
+		t.Error("Adding a course that is not in the catalog should fail")
+	}
+
+	var schedule server.Schedule
+	if err := bookTesting.GetTestJSONWithCookie(bookTesting.ScheduleURL()+"/json", studentCookie, &schedule); err != nil {
+		t.Fatal(err)
+	}
+	if len(schedule.Courses) != 1 {
+		t.Fatalf("\"1\" scheduled course expected: %+v", schedule)
+	}
+	scheduled := schedule.Courses[0]
+	if scheduled.Course.ID != 860 || scheduled.ListingCount != 2 || scheduled.LowestPrice != 12.0 {
+		t.Errorf("The course should have the 2 listings of the seller from $12.00: %+v", scheduled)
+	}
+	titles := []string{}
+	for _, book := range scheduled.Books {
+		titles = append(titles, book.Title)
+	}
+	if strings.Join(titles, ",") != "Course Reader,K&R" {
+		t.Errorf("Listings should be the seller's books, cheapest first: %v", titles)
+	}
+	if len(scheduled.Unavailable) != 1 || scheduled.Unavailable[0].Title != "Introduction to Algorithms" {
+		t.Errorf("Only the required textbook without listings should be unavailable: %+v", scheduled.Unavailable)
+	}
+
+	// test that the books for the student's classes are the default page for logged in users
+	body, err := bookTesting.GetTestPageWithCookie(bookTesting.Server.URL+"/", studentCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"Books for my classes", "Computer Science 31", `alt="Course Reader"`, "Introduction to Algorithms"} {
+		if !strings.Contains(body, text) {
+			t.Errorf("The root page should contain %q", text)
+		}
+	}
+	body, err = bookTesting.GetTestPageWithCookie(bookTesting.Server.URL+"/?view=recent", studentCookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, "Recent books") {
+		t.Error("The recent books should still be shown with view=recent")
+	}
+
+	// test that the course can only be removed by the student and only by its ID
+	deleteURL := func(id string) string {
+		return bookTesting.ScheduleURL() + "/" + id + "/delete"
+	}
+	for _, test := range []struct {
+		url    string
+		cookie *http.Cookie
+		status int
+		count  int
+	}{
+		{deleteURL(url.PathEscape("0 OR 1 = 1")), studentCookie, http.StatusNotFound, 1},
+		{deleteURL(strconv.Itoa(scheduled.ID)), sellerCookie, http.StatusNotFound, 1},
+		{deleteURL(strconv.Itoa(scheduled.ID)), studentCookie, http.StatusOK, 0},
+	} {
+		request, err := http.NewRequest("POST", test.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.AddCookie(test.cookie)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != test.status {
+			t.Errorf("Status %d expected: %d", test.status, response.StatusCode)
+		}
+		var count int
+		bookTesting.DB.Model(&server.ScheduledCourse{}).Count(&count)
+		if count != test.count {
+			t.Errorf("\"%d\" scheduled courses expected: %d", test.count, count)
+		}
+	}
+
+	// Delete mock created users, books and scheduled courses
+	for _, user := range users {
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.ScheduledCourse{})
+		bookTesting.DB.Delete(&user)
+	}
+}
@@ -0,0 +1,642 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// Admin changes to the course catalog that are recorded in the audit log
+const (
+	CourseActionCreate = "create"
+	CourseActionEdit   = "edit"
+	CourseActionMerge  = "merge"
+	CourseActionRetire = "retire"
+)
+
+// errors for admin changes to courses that cannot be made
+var (
+	errCourseRetired   = errors.New("Retired courses cannot be changed")
+	errMergeSameCourse = errors.New("A course cannot be merged into itself")
+)
+
+// CourseChangeResult is a course after an admin change and the audit log entry of the change
+type CourseChangeResult struct {
+	Course Course      `json:"course"`
+	Audit  CourseAudit `json:"audit"`
+}
+
+// AdminCourses are the courses of the catalog that match the admin course search and the latest changes to the catalog
+type AdminCourses struct {
+	Courses []Course      `json:"courses"`
+	Changes []CourseAudit `json:"changes"`
+}
+
+// AdminCoursesTemplateType is the type for the admin course list template
+type AdminCoursesTemplateType struct {
+	UserTemplateType
+	AdminCourses
+	Department string
+	CourseID   string
+}
+
+// AdminCourseTemplateType is the type for the admin course form template
+// The course has an ID of 0 when the form creates a course
+type AdminCourseTemplateType struct {
+	UserTemplateType
+	Course       Course
+	Terms        []Term
+	Institutions []Institution
+	Changes      []CourseAudit
+}
+
+// MigrateAdmins sets the admin column of users that were saved before users could be admins,
+// since AutoMigrate adds the column as NULL
+func MigrateAdmins(db gorm.DB) error {
+	return db.Model(&User{}).Where("admin IS NULL").UpdateColumn("admin", false).Error
+}
+
+// filterActive limits a course query to the courses that are not retired
+func filterActive(db *gorm.DB) *gorm.DB {
+	return db.Where("retired = ?", false)
+}
+
+// requireAdmin returns the logged in user if they are an admin
+// The session keeps the user from when they logged in, so admin rights are checked in the database
+func requireAdmin(r *http.Request, db gorm.DB) (User, int, error) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		return User{}, http.StatusUnauthorized, errors.New("You have to be logged in to change the course catalog")
+	}
+	var user User
+	if result := db.First(&user, currentUser.ID); result.Error != nil || !user.Admin {
+		return User{}, http.StatusForbidden, errors.New("Only admins can change the course catalog")
+	}
+	return user, http.StatusOK, nil
+}
+
+// parseCourseForm reads the department, course_id, professor and optional term_id and institution_id
+// values of a course form
+func parseCourseForm(courseDB gorm.DB, values url.Values) (Course, error) {
+	course := Course{
+		Department: cleanCourseField(values.Get("department")),
+		CourseID:   cleanCourseField(values.Get("course_id")),
+		Professor:  cleanCourseField(values.Get("professor")),
+	}
+	if course.Department == "" || course.CourseID == "" {
+		return course, errors.New("A course needs a department and a course number")
+	}
+
+	var err error
+	if value := values.Get("term_id"); value != "" && value != "0" {
+		if course.TermID, err = strconv.Atoi(value); err != nil {
+			return course, errors.New("Term has to be a number")
+		}
+		if result := courseDB.First(&Term{}, course.TermID); result.Error != nil {
+			return course, errors.New("Term does not exist")
+		}
+	}
+	if value := values.Get("institution_id"); value != "" && value != "0" {
+		if course.InstitutionID, err = strconv.Atoi(value); err != nil {
+			return course, errors.New("Campus has to be a number")
+		}
+		if result := courseDB.First(&Institution{}, course.InstitutionID); result.Error != nil {
+			return course, errors.New("Campus does not exist")
+		}
+	}
+	return course, nil
+}
+
+// newCourseAudit returns the audit log entry for an admin change to a course
+func newCourseAudit(admin User, action string, before Course, after Course) CourseAudit {
+	audit := CourseAudit{
+		UserID:       admin.ID,
+		UserEmail:    admin.Email,
+		Action:       action,
+		CourseID:     after.ID,
+		MergedIntoID: after.MergedIntoID,
+		CreatedAt:    time.Now(),
+	}
+	if before.ID != 0 {
+		beforeJSON, _ := json.Marshal(before)
+		audit.Before = string(beforeJSON)
+	}
+	afterJSON, _ := json.Marshal(after)
+	audit.After = string(afterJSON)
+	return audit
+}
+
+// adminCourseIDStart is the first ID of the courses that admins create. It is far above the IDs of imported
+// courses so that a courses.database with newly imported courses does not reuse the IDs of created courses
+const adminCourseIDStart = 1000000
+
+// courseChangeLock makes admin changes to the catalog one at a time so that created courses get their own IDs
+var courseChangeLock sync.Mutex
+
+// nextAdminCourseID returns the ID of the next course that an admin creates
+func nextAdminCourseID(db gorm.DB, courseDB gorm.DB) (int, error) {
+	next := adminCourseIDStart
+	var ids []int
+	if result := courseDB.Model(&Course{}).Where("id >= ?", next).Order("id desc").Limit(1).Pluck("id", &ids); result.Error != nil {
+		return 0, result.Error
+	}
+	var auditedIDs []int
+	if result := db.Model(&CourseAudit{}).Where("course_id >= ?", next).Order("course_id desc").Limit(1).
+		Pluck("course_id", &auditedIDs); result.Error != nil {
+		return 0, result.Error
+	}
+	for _, id := range append(ids, auditedIDs...) {
+		if id >= next {
+			next = id + 1
+		}
+	}
+	return next, nil
+}
+
+// moveCourseTextbooks moves the textbooks of a course to another course, dropping the ones the other course
+// already has
+func moveCourseTextbooks(tx *gorm.DB, id int, intoID int) error {
+	var textbooks []CourseTextbook
+	if result := tx.Where("course_id = ?", id).Find(&textbooks); result.Error != nil {
+		return result.Error
+	}
+	for _, textbook := range textbooks {
+		var count int
+		if result := tx.Model(&CourseTextbook{}).Where("course_id = ? AND i_s_b_n = ?", intoID, textbook.ISBN).Count(&count); result.Error != nil {
+			return result.Error
+		}
+		var result *gorm.DB
+		if count > 0 {
+			result = tx.Delete(&textbook)
+		} else {
+			result = tx.Model(&textbook).UpdateColumn("course_id", intoID)
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// applyCourseAudit makes an admin change from the audit log to the course database by saving the course as it
+// was after the change. Applying a change again changes nothing
+func applyCourseAudit(courseDB gorm.DB, audit CourseAudit) error {
+	var course Course
+	if err := json.Unmarshal([]byte(audit.After), &course); err != nil {
+		return err
+	}
+
+	tx := courseDB.Begin()
+	var before Course
+	exists := true
+	if result := tx.First(&before, course.ID); result.Error != nil {
+		if !result.RecordNotFound() {
+			tx.Rollback()
+			return result.Error
+		}
+		exists = false
+	}
+	var result *gorm.DB
+	if exists {
+		result = tx.Save(&course)
+	} else {
+		result = tx.Create(&course)
+	}
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if !exists || course.Professor != before.Professor || course.InstitutionID != before.InstitutionID {
+		directory, err := loadProfessorDirectory(tx, course.InstitutionID)
+		if err == nil {
+			err = linkCourseProfessor(tx, directory, course)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if audit.Action == CourseActionMerge {
+		if err := moveCourseTextbooks(tx, course.ID, course.MergedIntoID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// ApplyCourseAudits makes the admin changes to the catalog that are recorded in the main database to the course
+// database, in the order they were made. courses.database is replaced with every deploy, so the changes are made
+// again every time the server starts, and they win over catalog imports of the same courses
+func ApplyCourseAudits(db gorm.DB, courseDB gorm.DB) error {
+	var audits []CourseAudit
+	if result := db.Order("id").Find(&audits); result.Error != nil {
+		return result.Error
+	}
+	for _, audit := range audits {
+		if err := applyCourseAudit(courseDB, audit); err != nil {
+			return err
+		}
+	}
+	invalidateDepartmentResolvers()
+	return nil
+}
+
+// saveCourseChange records an admin change to a course in the audit log of the main database, which is what
+// keeps the change, and then makes it to the course database
+func saveCourseChange(db gorm.DB, courseDB gorm.DB, admin User, action string, before Course, course Course) (CourseChangeResult, error) {
+	course.UpdatedAt = time.Now()
+	if course.ID == 0 {
+		id, err := nextAdminCourseID(db, courseDB)
+		if err != nil {
+			return CourseChangeResult{}, err
+		}
+		course.ID, course.CreatedAt = id, course.UpdatedAt
+	}
+	audit := newCourseAudit(admin, action, before, course)
+	if result := db.Create(&audit); result.Error != nil {
+		return CourseChangeResult{}, result.Error
+	}
+	if err := applyCourseAudit(courseDB, audit); err != nil {
+		return CourseChangeResult{}, err
+	}
+	invalidateDepartmentResolvers()
+	return CourseChangeResult{Course: course, Audit: audit}, nil
+}
+
+// findChangeableCourse looks up a course that is not retired
+func findChangeableCourse(courseDB gorm.DB, id int) (Course, error) {
+	var course Course
+	if result := courseDB.First(&course, id); result.Error != nil {
+		if result.RecordNotFound() {
+			return course, errCourseNotFound
+		}
+		return course, result.Error
+	}
+	if course.Retired {
+		return course, errCourseRetired
+	}
+	return course, nil
+}
+
+// reindexCourseBooks indexes the books of a course again, since the search text of a book has its course
+func reindexCourseBooks(db gorm.DB, courseID int) error {
+	var books []Book
+	if result := db.Where("course_id = ?", courseID).Find(&books); result.Error != nil {
+		return result.Error
+	}
+	for _, book := range books {
+		indexBook(db, book)
+	}
+	return nil
+}
+
+// CreateCourse adds a course to the catalog and records who added it
+func CreateCourse(db gorm.DB, courseDB gorm.DB, admin User, course Course) (CourseChangeResult, error) {
+	courseChangeLock.Lock()
+	defer courseChangeLock.Unlock()
+	return saveCourseChange(db, courseDB, admin, CourseActionCreate, Course{}, course)
+}
+
+// EditCourse changes the department, course number, professor, term and campus of a course and records who
+// changed it. The listings of the course move to its new campus and are indexed with its new name
+func EditCourse(db gorm.DB, courseDB gorm.DB, admin User, id int, changes Course) (CourseChangeResult, error) {
+	courseChangeLock.Lock()
+	defer courseChangeLock.Unlock()
+	course, err := findChangeableCourse(courseDB, id)
+	if err != nil {
+		return CourseChangeResult{}, err
+	}
+	before := course
+	course.Department, course.CourseID, course.Professor = changes.Department, changes.CourseID, changes.Professor
+	course.TermID, course.InstitutionID = changes.TermID, changes.InstitutionID
+
+	result, err := saveCourseChange(db, courseDB, admin, CourseActionEdit, before, course)
+	if err != nil {
+		return result, err
+	}
+	if before.InstitutionID != course.InstitutionID {
+		update := db.Model(&Book{}).Unscoped().Where("course_id = ?", id).UpdateColumn("institution_id", course.InstitutionID)
+		if update.Error != nil {
+			return result, update.Error
+		}
+	}
+	return result, reindexCourseBooks(db, id)
+}
+
+// RetireCourse hides a course from course searches and records who retired it
+// Its listings keep pointing at it so that the course page still shows them
+func RetireCourse(db gorm.DB, courseDB gorm.DB, admin User, id int) (CourseChangeResult, error) {
+	courseChangeLock.Lock()
+	defer courseChangeLock.Unlock()
+	course, err := findChangeableCourse(courseDB, id)
+	if err != nil {
+		return CourseChangeResult{}, err
+	}
+	before := course
+	course.Retired = true
+	return saveCourseChange(db, courseDB, admin, CourseActionRetire, before, course)
+}
+
+// MergeCourses retires a duplicate course into another course and records who merged them
+// The listings, wanted books, saved searches and schedules of the duplicate are moved to the other course in the
+// same transaction of the main database that records the merge, then the merge is made to the course database,
+// which moves the textbooks of the duplicate
+func MergeCourses(db gorm.DB, courseDB gorm.DB, admin User, id int, intoID int) (CourseChangeResult, error) {
+	if id == intoID {
+		return CourseChangeResult{}, errMergeSameCourse
+	}
+	courseChangeLock.Lock()
+	defer courseChangeLock.Unlock()
+	course, err := findChangeableCourse(courseDB, id)
+	if err != nil {
+		return CourseChangeResult{}, err
+	}
+	into, err := findChangeableCourse(courseDB, intoID)
+	if err != nil {
+		return CourseChangeResult{}, err
+	}
+
+	tx := db.Begin()
+	moved := tx.Model(&Book{}).Unscoped().Where("course_id = ?", id).
+		UpdateColumns(map[string]interface{}{"course_id": intoID, "institution_id": into.InstitutionID})
+	if moved.Error != nil {
+		tx.Rollback()
+		return CourseChangeResult{}, moved.Error
+	}
+	// schedules and wanted books that the user already has for the other course would be duplicates after the
+	// move, so they are deleted instead
+	duplicates := []struct {
+		model interface{}
+		query string
+	}{
+		{&ScheduledCourse{}, "EXISTS (SELECT 1 FROM scheduled_courses kept WHERE kept.course_id = ? AND " +
+			"kept.user_id = scheduled_courses.user_id AND kept.term_id = scheduled_courses.term_id)"},
+		{&WantedBook{}, "EXISTS (SELECT 1 FROM wanted_books kept WHERE kept.course_id = ? AND kept.deleted_at IS NULL AND " +
+			"kept.user_id = wanted_books.user_id AND kept.i_s_b_n = wanted_books.i_s_b_n)"},
+	}
+	for _, duplicate := range duplicates {
+		if result := tx.Where("course_id = ?", id).Where(duplicate.query, intoID).Delete(duplicate.model); result.Error != nil {
+			tx.Rollback()
+			return CourseChangeResult{}, result.Error
+		}
+	}
+	for _, model := range []interface{}{&WantedBook{}, &SavedSearch{}, &ScheduledCourse{}} {
+		if result := tx.Model(model).Unscoped().Where("course_id = ?", id).UpdateColumn("course_id", intoID); result.Error != nil {
+			tx.Rollback()
+			return CourseChangeResult{}, result.Error
+		}
+	}
+	before := course
+	course.Retired, course.MergedIntoID, course.UpdatedAt = true, intoID, time.Now()
+	audit := newCourseAudit(admin, CourseActionMerge, before, course)
+	audit.BooksMoved = int(moved.RowsAffected)
+	if result := tx.Create(&audit); result.Error != nil {
+		tx.Rollback()
+		return CourseChangeResult{}, result.Error
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return CourseChangeResult{}, result.Error
+	}
+
+	if err := applyCourseAudit(courseDB, audit); err != nil {
+		return CourseChangeResult{}, err
+	}
+	invalidateDepartmentResolvers()
+	return CourseChangeResult{Course: course, Audit: audit}, reindexCourseBooks(db, intoID)
+}
+
+// courseChangeRequest makes the admin change to a course that a POST request asks for
+// POST parameters:
+// department, course_id, professor string, term_id, institution_id int (to create or edit a course)
+// into_id int (the course to merge the course into)
+func courseChangeRequest(r *http.Request, db gorm.DB, courseDB gorm.DB, action string) (CourseChangeResult, int, error) {
+	admin, status, err := requireAdmin(r, db)
+	if err != nil {
+		return CourseChangeResult{}, status, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return CourseChangeResult{}, http.StatusBadRequest, err
+	}
+
+	id := 0
+	if action != CourseActionCreate {
+		if id, err = strconv.Atoi(mux.Vars(r)["id"]); err != nil {
+			return CourseChangeResult{}, http.StatusNotFound, errCourseNotFound
+		}
+	}
+
+	var result CourseChangeResult
+	switch action {
+	case CourseActionCreate, CourseActionEdit:
+		var course Course
+		if course, err = parseCourseForm(courseDB, r.PostForm); err != nil {
+			return result, http.StatusBadRequest, err
+		}
+		if action == CourseActionCreate {
+			result, err = CreateCourse(db, courseDB, admin, course)
+		} else {
+			result, err = EditCourse(db, courseDB, admin, id, course)
+		}
+	case CourseActionMerge:
+		var intoID int
+		if intoID, err = strconv.Atoi(r.PostFormValue("into_id")); err != nil {
+			return result, http.StatusBadRequest, errors.New("The course to merge into has to be a course ID")
+		}
+		result, err = MergeCourses(db, courseDB, admin, id, intoID)
+	case CourseActionRetire:
+		result, err = RetireCourse(db, courseDB, admin, id)
+	default:
+		return result, http.StatusNotFound, errors.New("Unknown course change")
+	}
+
+	switch err {
+	case nil:
+		return result, http.StatusOK, nil
+	case errCourseNotFound:
+		return result, http.StatusNotFound, err
+	case errCourseRetired, errMergeSameCourse:
+		return result, http.StatusBadRequest, err
+	}
+	return result, http.StatusInternalServerError, err
+}
+
+// CourseChangeJSONHandler returns the route for /admin/courses/new/json, /admin/courses/{id}/edit/json,
+// /admin/courses/{id}/merge/json or /admin/courses/{id}/retire/json that makes an admin change to the
+// course catalog and returns the changed course with its audit log entry in JSON format
+// POST parameters are the same as CourseChangeHandler
+func CourseChangeJSONHandler(action string) CourseDBHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+		result, status, err := courseChangeRequest(r, db, courseDB, action)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		resultJSON, err := json.Marshal(result)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resultJSON)
+	}
+}
+
+// CourseChangeHandler returns the route for POST /admin/courses/new, /admin/courses/{id}/edit,
+// /admin/courses/{id}/merge or /admin/courses/{id}/retire that makes an admin change to the course catalog
+// and redirects to the changed course, or to the course it was merged into
+// POST parameters:
+// department, course_id, professor string, term_id, institution_id int (to create or edit a course)
+// into_id int (the course to merge the course into)
+func CourseChangeHandler(action string) CourseDBHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+		result, status, err := courseChangeRequest(r, db, courseDB, action)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		id := result.Course.ID
+		if result.Course.MergedIntoID != 0 {
+			id = result.Course.MergedIntoID
+		}
+		http.Redirect(w, r, fmt.Sprintf("/admin/courses/%d/edit", id), http.StatusFound)
+	}
+}
+
+// courseChanges returns the latest changes to the catalog from the audit log of the main database, or the
+// changes to a course and the courses merged into it if courseID is not 0
+func courseChanges(db gorm.DB, courseID int) ([]CourseAudit, error) {
+	changes := []CourseAudit{}
+	query := db.Order("created_at desc, id desc").Limit(50)
+	if courseID != 0 {
+		query = query.Where("course_id = ? OR merged_into_id = ?", courseID, courseID)
+	}
+	result := query.Find(&changes)
+	return changes, result.Error
+}
+
+// adminCoursesRequest looks up the courses for an admin course list request
+// GET parameters:
+// department string (part of the department name)
+// course_id string
+func adminCoursesRequest(r *http.Request, db gorm.DB, courseDB gorm.DB) (AdminCourses, int, error) {
+	if _, status, err := requireAdmin(r, db); err != nil {
+		return AdminCourses{}, status, err
+	}
+
+	adminCourses := AdminCourses{Courses: []Course{}}
+	query := courseDB.Order("department, course_id, professor").Limit(100)
+	if department := strings.TrimSpace(r.URL.Query().Get("department")); department != "" {
+		query = query.Where("department LIKE ?", "%"+department+"%")
+	}
+	if courseID := strings.TrimSpace(r.URL.Query().Get("course_id")); courseID != "" {
+		query = query.Where("UPPER(course_id) = ?", strings.ToUpper(courseID))
+	}
+	if result := query.Find(&adminCourses.Courses); result.Error != nil {
+		return adminCourses, http.StatusInternalServerError, result.Error
+	}
+
+	var err error
+	if adminCourses.Changes, err = courseChanges(db, 0); err != nil {
+		return adminCourses, http.StatusInternalServerError, err
+	}
+	return adminCourses, http.StatusOK, nil
+}
+
+// AdminCoursesJSONHandler is a route for /admin/courses/json that returns the courses that match
+// an admin course search, including retired courses, and the latest changes to the catalog in JSON format
+// GET parameters are the same as AdminCoursesHandler
+func AdminCoursesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	adminCourses, status, err := adminCoursesRequest(r, db, courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	coursesJSON, err := json.Marshal(adminCourses)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(coursesJSON)
+}
+
+// AdminCoursesHandler is a route for /admin/courses that lets admins search the catalog, including retired
+// courses, and shows the latest changes to the catalog
+// GET parameters:
+// department string (part of the department name)
+// course_id string
+func AdminCoursesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	adminCourses, status, err := adminCoursesRequest(r, db, courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/admin_courses.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, AdminCoursesTemplateType{
+		UserTemplateType: params,
+		AdminCourses:     adminCourses,
+		Department:       r.URL.Query().Get("department"),
+		CourseID:         r.URL.Query().Get("course_id"),
+	})
+}
+
+// AdminCourseHandler is a route for GET /admin/courses/new and /admin/courses/{id}/edit that displays the form
+// to create or edit a course, and the forms to merge and retire it with its changes when editing
+func AdminCourseHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	if _, status, err := requireAdmin(r, db); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	templateParams := AdminCourseTemplateType{Changes: []CourseAudit{}}
+	if value, ok := mux.Vars(r)["id"]; ok {
+		id, err := strconv.Atoi(value)
+		if err != nil || courseDB.First(&templateParams.Course, id).Error != nil {
+			http.Error(w, errCourseNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if templateParams.Changes, err = courseChanges(db, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var err error
+	if templateParams.Terms, err = FindTerms(courseDB); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result := courseDB.Order("name").Find(&templateParams.Institutions); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/admin_course.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	templateParams.UserTemplateType = params
+	t.Execute(w, templateParams)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BookCondition is how worn a book is. It is stored and marshalled to JSON as a stable string
+type BookCondition string
+
+// The conditions a book can be in from best to worst
+const (
+	ConditionNew        BookCondition = "new"
+	ConditionLikeNew    BookCondition = "like_new"
+	ConditionGood       BookCondition = "good"
+	ConditionAcceptable BookCondition = "acceptable"
+	ConditionPoor       BookCondition = "poor"
+)
+
+// BookConditions lists every book condition from best to worst
+var BookConditions = []BookCondition{
+	ConditionNew,
+	ConditionLikeNew,
+	ConditionGood,
+	ConditionAcceptable,
+	ConditionPoor,
+}
+
+// bookConditionDetails has the display label and description for each book condition
+var bookConditionDetails = map[BookCondition]struct {
+	label       string
+	description string
+}{
+	ConditionNew:        {"New", "Unused with no marks or wear"},
+	ConditionLikeNew:    {"Like New", "Barely used with no highlighting or writing"},
+	ConditionGood:       {"Good", "Some wear and a small amount of highlighting or notes"},
+	ConditionAcceptable: {"Acceptable", "Noticeable wear and heavy highlighting or notes, but all pages intact"},
+	ConditionPoor:       {"Poor", "Heavily worn or damaged, but still usable"},
+}
+
+// ParseBookCondition converts a condition string from a form into a BookCondition,
+// returning an error if it is not one of the known conditions
+func ParseBookCondition(condition string) (BookCondition, error) {
+	c := BookCondition(condition)
+	if !c.Valid() {
+		return "", fmt.Errorf("%q is not a valid book condition", condition)
+	}
+	return c, nil
+}
+
+// Valid returns true if the condition is one of the known conditions
+func (c BookCondition) Valid() bool {
+	_, ok := bookConditionDetails[c]
+	return ok
+}
+
+// Label returns the display name of the condition
+func (c BookCondition) Label() string {
+	return bookConditionDetails[c].label
+}
+
+// Description returns an explanation of what the condition means
+func (c BookCondition) Description() string {
+	return bookConditionDetails[c].description
+}
+
+// AtLeast returns the conditions that are as good as or better than the condition, from best to worst
+func (c BookCondition) AtLeast() []BookCondition {
+	for i, condition := range BookConditions {
+		if condition == c {
+			return BookConditions[:i+1]
+		}
+	}
+	return nil
+}
+
+// Accepts returns true if a book in a condition is in the condition or better
+// The empty condition accepts books in any condition
+func (c BookCondition) Accepts(condition BookCondition) bool {
+	if c == "" {
+		return true
+	}
+	for _, accepted := range c.AtLeast() {
+		if accepted == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyBookCondition maps a condition from the old 1 to 10 scale to a BookCondition
+func legacyBookCondition(score int) BookCondition {
+	switch {
+	case score >= 10:
+		return ConditionNew
+	case score >= 8:
+		return ConditionLikeNew
+	case score >= 6:
+		return ConditionGood
+	case score >= 4:
+		return ConditionAcceptable
+	default:
+		return ConditionPoor
+	}
+}
+
+// MigrateBookConditions converts the integer conditions of books saved before conditions were typed
+// and drops the old integer condition column. It does nothing if the old column does not exist
+func MigrateBookConditions(db gorm.DB) error {
+	scope := db.NewScope(&Book{})
+	if !scope.Dialect().HasColumn(scope, scope.TableName(), "condition") { // already migrated
+		return nil
+	}
+
+	rows, err := db.Raw("SELECT id, condition FROM books").Rows()
+	if err != nil {
+		return err
+	}
+
+	// books without a condition get the worst one, like the lowest scores
+	conditions := map[int]BookCondition{}
+	for rows.Next() {
+		var id int
+		var score sql.NullInt64
+		if err := rows.Scan(&id, &score); err != nil {
+			rows.Close()
+			return err
+		}
+		conditions[id] = legacyBookCondition(int(score.Int64))
+	}
+	rows.Close()
+
+	// books in the trash are converted too so that they have a condition once they are restored
+	tx := db.Begin()
+	for id, condition := range conditions {
+		if result := tx.Unscoped().Model(&Book{ID: id}).UpdateColumn("condition_grade", condition); result.Error != nil {
+			tx.Rollback()
+			return result.Error
+		}
+	}
+	// DropColumn does not return its error, and books cannot be saved while the old NOT NULL column is left
+	dropColumn := fmt.Sprintf("ALTER TABLE %v DROP COLUMN %v", scope.QuotedTableName(), scope.Quote("condition"))
+	if result := tx.Exec(dropColumn); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// ListingLifetime is how long a listing stays up before it expires unless it is renewed
+	ListingLifetime = 60 * 24 * time.Hour
+
+	// How often the sweeper checks for expired listings and old deleted books
+	bookSweepInterval = time.Hour
+)
+
+// ExpireBooks marks every available book whose expiration date is before now as expired
+// and returns the number of books that were expired
+func ExpireBooks(db gorm.DB, now time.Time) (int, error) {
+	var staleBooks []Book
+	if result := db.Where("status = ? AND expires_at < ?", BookAvailable, now).Find(&staleBooks); result.Error != nil {
+		return 0, result.Error
+	}
+
+	for i := range staleBooks {
+		// expirations are made by the sweeper and not a user so the user id is left as 0
+		if err := recordTransition(db, &staleBooks[i], BookExpired, 0); err != nil {
+			return i, err
+		}
+	}
+	return len(staleBooks), nil
+}
+
+// sweepBooks expires stale books and purges old books from the trash every interval until stop is closed
+func sweepBooks(db gorm.DB, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if expired, err := ExpireBooks(db, time.Now()); err != nil {
+			log.Printf("Error expiring books: %s", err.Error())
+		} else if expired > 0 {
+			log.Printf("Expired %d books", expired)
+		}
+		if purged, err := PurgeDeletedBooks(db, time.Now()); err != nil {
+			log.Printf("Error purging deleted books: %s", err.Error())
+		} else if purged > 0 {
+			log.Printf("Purged %d deleted books", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// StartBookSweeper periodically hides listings that have not been renewed and empties the trash
+// in the background. It should be started once per server and returns a function that stops it
+func StartBookSweeper(db gorm.DB) (stop func()) {
+	stopSweep := make(chan struct{})
+	go sweepBooks(db, bookSweepInterval, stopSweep)
+	return func() { close(stopSweep) }
+}
+
+// RenewBook extends the expiration date of a book by ListingLifetime from now and
+// makes the book available again if it had expired
+func RenewBook(db gorm.DB, book *Book, userID int) error {
+	if book.Status != BookAvailable && book.Status != BookExpired {
+		return errors.New("Only available or expired books can be renewed")
+	}
+
+	expiresAt := time.Now().Add(ListingLifetime)
+	if result := db.Model(book).UpdateColumn("expires_at", expiresAt); result.Error != nil {
+		return result.Error
+	}
+	book.ExpiresAt = expiresAt
+
+	if book.Status == BookExpired {
+		return recordTransition(db, book, BookAvailable, userID)
+	}
+	return nil
+}
+
+// MigrateBookExpirations gives books that were saved before listings expired a full
+// listing lifetime starting from now
+func MigrateBookExpirations(db gorm.DB) error {
+	return db.Model(&Book{}).Where("expires_at IS NULL").
+		UpdateColumn("expires_at", time.Now().Add(ListingLifetime)).Error
+}
+
+// RenewBookHandler is a route for /books/{id}/renew that extends the expiration date of a book with a certain ID
+// You have to be logged in and you can only renew your own books
+func RenewBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	book, status, err := findOwnedBook(r, db, "renew")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := RenewBook(db, &book, book.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", book.ID), http.StatusFound)
+}
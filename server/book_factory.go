@@ -1,53 +1,116 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 // BookFactory is an interface for creating books from various parameters
 type BookFactory interface {
-	NewFormBook(r *http.Request, userID int) (Book, error) // Generates new Books from a POST form request
+	NewFormBook(r *http.Request, userID int) (Book, error)      // Generates new Books from a POST form request
+	NewValuesBook(values url.Values, userID int) (Book, error)  // Generates new Books from a set of field values
+	NewEditedFormBook(r *http.Request, book Book) (Book, error) // Generates the edited version of a Book from a POST form request
 }
 
-// MuxBookFactory is an implementation of BookFactory
-type MuxBookFactory struct{}
+// MuxBookFactory is an implementation of BookFactory that looks up the courses of books in a course database
+type MuxBookFactory struct {
+	courseDB gorm.DB
+}
 
 // NewMuxBookFactory constructs a new MuxBookFactory
-func NewMuxBookFactory() MuxBookFactory {
-	return MuxBookFactory{}
+func NewMuxBookFactory(courseDB gorm.DB) MuxBookFactory {
+	return MuxBookFactory{courseDB: courseDB}
 }
 
 // NewFormBook creates a new book object from a http post form request
 func (u MuxBookFactory) NewFormBook(r *http.Request, userID int) (Book, error) {
+	if err := r.ParseMultipartForm(maxPhotoUploadSize); err != nil && err != http.ErrNotMultipart {
+		return Book{}, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return Book{}, err
+	}
+	return u.NewValuesBook(r.PostForm, userID)
+}
+
+// NewEditedFormBook creates the edited version of a book from a http post form request
+// Books saved before ISBNs were validated can keep their invalid ISBN as long as it is not changed
+func (u MuxBookFactory) NewEditedFormBook(r *http.Request, book Book) (Book, error) {
+	if err := r.ParseMultipartForm(maxPhotoUploadSize); err != nil && err != http.ErrNotMultipart {
+		return Book{}, err
+	}
 	if err := r.ParseForm(); err != nil {
 		return Book{}, err
 	}
-	isbn := r.PostFormValue("isbn")
-	title := r.PostFormValue("title")
-	courseID, err := strconv.Atoi(r.PostFormValue("course_id"))
+
+	legacyISBN := ""
+	if _, err := NormalizeISBN(book.ISBN); err != nil {
+		legacyISBN = book.ISBN
+	}
+	return u.newValuesBook(r.PostForm, book.UserID, legacyISBN)
+}
+
+// NewValuesBook creates a new book object from the isbn, title, course_id, price, condition, details and
+// optional term_id values, validating them the same way as a submitted form
+// The book is on the campus of its course
+func (u MuxBookFactory) NewValuesBook(values url.Values, userID int) (Book, error) {
+	return u.newValuesBook(values, userID, "")
+}
+
+// newValuesBook creates a new book from field values, allowing the isbn value to be legacyISBN
+// even though it is not a valid ISBN
+func (u MuxBookFactory) newValuesBook(values url.Values, userID int, legacyISBN string) (Book, error) {
+	originalISBN := strings.TrimSpace(values.Get("isbn"))
+	isbn, err := NormalizeISBN(originalISBN)
+	if err != nil {
+		if legacyISBN == "" || originalISBN != legacyISBN {
+			return Book{}, err
+		}
+		isbn = legacyISBN
+	}
+	title := values.Get("title")
+	courseID, err := strconv.Atoi(values.Get("course_id"))
 	if err != nil {
 		return Book{}, err
 	}
-	price, err := strconv.ParseFloat(r.PostFormValue("price"), 64)
+	price, err := strconv.ParseFloat(values.Get("price"), 64)
 	if err != nil {
 		return Book{}, err
 	}
-	condition, err := strconv.Atoi(r.PostFormValue("condition"))
+	condition, err := ParseBookCondition(values.Get("condition"))
 	if err != nil {
 		return Book{}, err
 	}
-	details := r.PostFormValue("details")
+	details := values.Get("details")
+	termID := 0
+	if value := values.Get("term_id"); value != "" {
+		if termID, err = strconv.Atoi(value); err != nil {
+			return Book{}, err
+		}
+		if result := u.courseDB.First(&Term{}, termID); result.Error != nil {
+			return Book{}, errors.New("Term does not exist")
+		}
+	}
 
 	return Book{
-		Title:     title,
-		ISBN:      isbn,
-		CourseID:  courseID,
-		Price:     price,
-		Condition: condition,
-		Details:   details,
-		UserID:    userID,
-		CreatedAt: time.Now(),
+		Title:         title,
+		ISBN:          isbn,
+		OriginalISBN:  originalISBN,
+		CourseID:      courseID,
+		Price:         price,
+		Condition:     condition,
+		Details:       details,
+		TermID:        termID,
+		InstitutionID: courseInstitution(u.courseDB, courseID),
+		Status:        BookAvailable,
+		UserID:        userID,
+		ExpiresAt:     time.Now().Add(ListingLifetime),
+		CreatedAt:     time.Now(),
 	}, nil
 }
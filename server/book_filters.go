@@ -0,0 +1,355 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Sort keys for book searches
+const (
+	SortRelevance = "relevance"
+	SortPriceAsc  = "price_asc"
+	SortPriceDesc = "price_desc"
+	SortNewest    = "newest"
+)
+
+// BookSorts are the sort keys that a book search accepts
+var BookSorts = []string{SortRelevance, SortPriceAsc, SortPriceDesc, SortNewest}
+
+// BookSearch is a book search query with its filters and sort order
+// Zero values mean that the filter is not applied
+type BookSearch struct {
+	Query         string          `json:"query"`
+	MinPrice      float64         `json:"min_price,omitempty"`
+	MaxPrice      float64         `json:"max_price,omitempty"`
+	Conditions    []BookCondition `json:"conditions,omitempty"`
+	CourseID      int             `json:"course_id,omitempty"`
+	Department    string          `json:"department,omitempty"`
+	SellerID      int             `json:"seller_id,omitempty"`
+	Sort          string          `json:"sort"`
+	InstitutionID int             `json:"institution_id,omitempty"` // the campus of the logged in user, not a parameter
+}
+
+// Facet is the number of matching books for one value of a filter
+type Facet struct {
+	Value    string `json:"value"`
+	Label    string `json:"label"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
+// BookFacets are the facet counts of a book search. Each facet is counted with
+// every filter applied except its own so that the other values can still be chosen
+type BookFacets struct {
+	Conditions  []Facet `json:"conditions"`
+	Departments []Facet `json:"departments"`
+	Courses     []Facet `json:"courses"`
+}
+
+// BookSearchResults are a page of the books that match a book search and the facet counts of the search
+type BookSearchResults struct {
+	Search  BookSearch `json:"search"`
+	Courses []Course   `json:"courses"` // the courses that a query like "cs 31" names, whose books are the matches
+	Books   []Book     `json:"books"`
+	Facets  BookFacets `json:"facets"`
+	Page    Page       `json:"page"`
+}
+
+// ParseBookSearch reads a book search from the GET parameters of a search request
+// GET parameters:
+// query string
+// min_price, max_price float
+// condition (can be repeated, one of the book conditions)
+// course_id int
+// department string
+// seller_id int
+// sort (relevance, price_asc, price_desc or newest)
+func ParseBookSearch(values url.Values) (BookSearch, error) {
+	var err error
+	search := BookSearch{
+		Query:      strings.TrimSpace(values.Get("query")),
+		Department: strings.TrimSpace(values.Get("department")),
+		Sort:       values.Get("sort"),
+	}
+
+	if minPrice := values.Get("min_price"); minPrice != "" {
+		if search.MinPrice, err = strconv.ParseFloat(minPrice, 64); err != nil {
+			return search, errors.New("Minimum price has to be a number")
+		}
+	}
+	if maxPrice := values.Get("max_price"); maxPrice != "" {
+		if search.MaxPrice, err = strconv.ParseFloat(maxPrice, 64); err != nil {
+			return search, errors.New("Maximum price has to be a number")
+		}
+	}
+	for _, value := range values["condition"] {
+		condition, err := ParseBookCondition(value)
+		if err != nil {
+			return search, err
+		}
+		search.Conditions = append(search.Conditions, condition)
+	}
+	if courseID := values.Get("course_id"); courseID != "" {
+		if search.CourseID, err = strconv.Atoi(courseID); err != nil {
+			return search, errors.New("Course ID has to be a number")
+		}
+	}
+	if sellerID := values.Get("seller_id"); sellerID != "" {
+		if search.SellerID, err = strconv.Atoi(sellerID); err != nil {
+			return search, errors.New("Seller ID has to be a number")
+		}
+	}
+
+	switch search.Sort {
+	case "":
+		search.Sort = SortRelevance
+	case SortRelevance, SortPriceAsc, SortPriceDesc, SortNewest:
+	default:
+		return search, fmt.Errorf("Unknown sort %q", search.Sort)
+	}
+	return search, nil
+}
+
+// Empty returns true if the search has no query and no filters
+func (s BookSearch) Empty() bool {
+	return s.Query == "" && s.MinPrice == 0 && s.MaxPrice == 0 && len(s.Conditions) == 0 &&
+		s.CourseID == 0 && s.Department == "" && s.SellerID == 0
+}
+
+// HasCondition returns true if the search is filtered to a book condition
+func (s BookSearch) HasCondition(condition BookCondition) bool {
+	for _, c := range s.Conditions {
+		if c == condition {
+			return true
+		}
+	}
+	return false
+}
+
+// filter applies every filter of the search to a book query except for the filter named by except
+// The departments of courses are looked up in courseDB
+func (s BookSearch) filter(db *gorm.DB, courseDB gorm.DB, except string) (*gorm.DB, error) {
+	if s.MinPrice != 0 {
+		db = db.Where("price >= ?", s.MinPrice)
+	}
+	if s.MaxPrice != 0 {
+		db = db.Where("price <= ?", s.MaxPrice)
+	}
+	if len(s.Conditions) > 0 && except != "condition" {
+		conditions := make([]string, len(s.Conditions))
+		for i, condition := range s.Conditions {
+			conditions[i] = string(condition)
+		}
+		db = db.Where("condition_grade IN (?)", conditions)
+	}
+	if s.CourseID != 0 && except != "course" {
+		db = db.Where("course_id = ?", s.CourseID)
+	}
+	if s.Department != "" && except != "department" {
+		var err error
+		if db, err = filterDepartment(db, courseDB, s.Department); err != nil {
+			return nil, err
+		}
+	}
+	if s.SellerID != 0 {
+		db = db.Where("user_id = ?", s.SellerID)
+	}
+	return filterInstitution(db, s.InstitutionID), nil
+}
+
+// filterDepartment restricts a query with a course_id column to the courses of a department
+func filterDepartment(db *gorm.DB, courseDB gorm.DB, department string) (*gorm.DB, error) {
+	var courseIDs []int
+	if result := courseDB.Model(&Course{}).Where("department = ?", department).Pluck("id", &courseIDs); result.Error != nil {
+		return nil, result.Error
+	}
+	if len(courseIDs) == 0 {
+		return db.Where("1 = 0"), nil
+	}
+	return db.Where("course_id IN (?)", courseIDs), nil
+}
+
+// order returns the sort order of the search
+// Relevance falls back to the newest books when the matches are not ranked
+func (s BookSearch) order(ranks map[int]int) pageOrder {
+	switch s.Sort {
+	case SortPriceAsc:
+		return cheapestFirst
+	case SortPriceDesc:
+		return mostExpensiveFirst
+	case SortRelevance:
+		if ranks != nil {
+			return rankOrder(rankExpression(ranks))
+		}
+	}
+	return newestFirst
+}
+
+// searchBooks returns a page of the books from a book query that match a book search, together with the facet counts
+// Courses are looked up in courseDB
+func searchBooks(db *gorm.DB, courseDB gorm.DB, search BookSearch, request pageRequest) (BookSearchResults, error) {
+	results := BookSearchResults{Search: search, Courses: []Course{}, Books: []Book{}}
+
+	matching := db
+	var ranks map[int]int
+	if search.Query != "" {
+		var err error
+		if matching, ranks, err = bookSearchQuery(search.Query, db); err != nil {
+			return results, err
+		}
+		// a query that names a course also matches the books of the course and the courses that it is cross listed with
+		courses, courseIDs, err := searchCourseIDs(courseDB, search)
+		if err != nil {
+			return results, err
+		}
+		if len(courseIDs) > 0 {
+			results.Courses = courses
+			var textIDs []int
+			if result := matching.Model(&Book{}).Pluck("books.id", &textIDs); result.Error != nil {
+				return results, result.Error
+			}
+			if len(textIDs) > 0 {
+				matching = db.Where("course_id IN (?) OR books.id IN (?)", courseIDs, textIDs)
+			} else {
+				matching = db.Where("course_id IN (?)", courseIDs)
+			}
+		}
+	}
+
+	filtered, err := search.filter(matching, courseDB, "")
+	if err != nil {
+		return results, err
+	}
+	results.Page, err = paginate(filtered, request, search.order(ranks), &results.Books, func(i int) pageCursor {
+		return bookCursor(results.Books[i], ranks)
+	})
+	if err != nil {
+		return results, err
+	}
+
+	results.Facets, err = search.facets(matching, courseDB)
+	return results, err
+}
+
+// facets counts the books from a book query for every condition, department and course
+func (s BookSearch) facets(db *gorm.DB, courseDB gorm.DB) (BookFacets, error) {
+	facets := BookFacets{Conditions: []Facet{}, Departments: []Facet{}, Courses: []Facet{}}
+
+	conditionCounts, err := s.countBooksBy(db, courseDB, "condition", "condition_grade")
+	if err != nil {
+		return facets, err
+	}
+	for _, condition := range BookConditions {
+		facets.Conditions = append(facets.Conditions, Facet{
+			Value:    string(condition),
+			Label:    condition.Label(),
+			Count:    conditionCounts[string(condition)],
+			Selected: s.HasCondition(condition),
+		})
+	}
+
+	courseCounts, err := s.countBooksBy(db, courseDB, "course", "course_id")
+	if err != nil {
+		return facets, err
+	}
+	courses, err := facetCourses(courseDB, courseCounts)
+	if err != nil {
+		return facets, err
+	}
+	for _, course := range courses {
+		value := strconv.Itoa(course.ID)
+		facets.Courses = append(facets.Courses, Facet{
+			Value:    value,
+			Label:    course.Department + " " + course.CourseID,
+			Count:    courseCounts[value],
+			Selected: course.ID == s.CourseID,
+		})
+	}
+
+	departmentCourseCounts, err := s.countBooksBy(db, courseDB, "department", "course_id")
+	if err != nil {
+		return facets, err
+	}
+	courses, err = facetCourses(courseDB, departmentCourseCounts)
+	if err != nil {
+		return facets, err
+	}
+	departmentCounts := map[string]int{}
+	for _, course := range courses {
+		departmentCounts[course.Department] += departmentCourseCounts[strconv.Itoa(course.ID)]
+	}
+	for department, count := range departmentCounts {
+		facets.Departments = append(facets.Departments, Facet{
+			Value:    department,
+			Label:    department,
+			Count:    count,
+			Selected: department == s.Department,
+		})
+	}
+
+	sort.Sort(facetsByCount(facets.Courses))
+	sort.Sort(facetsByCount(facets.Departments))
+	return facets, nil
+}
+
+// countBooksBy counts the books from a book query for every value of a column, leaving out the filter named by except
+func (s BookSearch) countBooksBy(db *gorm.DB, courseDB gorm.DB, except string, column string) (map[string]int, error) {
+	filtered, err := s.filter(db, courseDB, except)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := filtered.Model(&Book{}).Select(column + ", COUNT(*)").Group(column).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var value string
+		var count int
+		if err := rows.Scan(&value, &count); err != nil {
+			return nil, err
+		}
+		counts[value] = count
+	}
+	return counts, rows.Err()
+}
+
+// facetCourses looks up the courses whose IDs are the keys of a facet count
+func facetCourses(courseDB gorm.DB, counts map[string]int) ([]Course, error) {
+	courses := []Course{}
+	if len(counts) == 0 {
+		return courses, nil
+	}
+
+	ids := make([]int, 0, len(counts))
+	for value := range counts {
+		if id, err := strconv.Atoi(value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if result := courseDB.Where("id IN (?)", ids).Find(&courses); result.Error != nil {
+		return nil, result.Error
+	}
+	return courses, nil
+}
+
+// facetsByCount sorts facets from the highest to the lowest count and then by label
+type facetsByCount []Facet
+
+func (f facetsByCount) Len() int      { return len(f) }
+func (f facetsByCount) Swap(i, j int) { f[i], f[j] = f[j], f[i] }
+func (f facetsByCount) Less(i, j int) bool {
+	if f[i].Count != f[j].Count {
+		return f[i].Count > f[j].Count
+	}
+	return f[i].Label < f[j].Label
+}
@@ -1,11 +1,18 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 	"net/http"
+	"strconv"
+	"sync"
 )
 
+var errBookNotFound = errors.New("Book does not exist")
+
 // ShowBooksHandler is a route for /books that displays all books that you own
 func ShowBooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	t, params, err := GenerateFullTemplate(r, "templates/search_results.html")
@@ -14,8 +21,21 @@ func ShowBooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 		return
 	}
 
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	var myBooks []Book
-	if result := db.Model(&params.CurrentUser).Related(&myBooks); result.Error != nil {
+	page, err := paginate(db.Where("user_id = ?", params.CurrentUser.ID), pageRequest, newestFirst, &myBooks,
+		func(i int) pageCursor { return bookCursor(myBooks[i], nil) })
+	if err != nil {
+		http.Error(w, "Error retrieving books", http.StatusInternalServerError)
+		return
+	}
+	page.setLinks(r.URL)
+	if err := loadBookMetadata(db, myBooks); err != nil {
 		http.Error(w, "Error retrieving books", http.StatusInternalServerError)
 		return
 	}
@@ -24,17 +44,33 @@ func ShowBooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 		UserTemplateType: params,
 		Books:            myBooks,
 		Title:            "My books",
+		ShowTrashLink:    true,
+		Page:             page,
 	})
 }
 
 // BookHandler is a route for /books/{id} that displays a book with a certain ID
-func BookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
-	bookID := mux.Vars(r)["id"]
+func BookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	var book Book
-	if result := db.First(&book, bookID); result.Error != nil {
+	if result := db.Where("id = ?", bookID).First(&book); result.Error != nil {
 		http.Error(w, "Book does not exist", http.StatusUnauthorized)
 		return
 	}
+	if result := db.Model(&book).Related(&book.Photos); result.Error != nil {
+		http.Error(w, "Error retrieving photos", http.StatusInternalServerError)
+		return
+	}
+	attachBookMetadata(db, &book)
+	priceStats, err := FindISBNStats(db, book.ISBN)
+	if err != nil {
+		http.Error(w, "Error retrieving prices", http.StatusInternalServerError)
+		return
+	}
 
 	t, params, err := GenerateFullTemplate(r, "templates/book_detail.html")
 	if err != nil {
@@ -47,44 +83,151 @@ func BookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 		Book:             book,
 		UserID:           book.UserID,
 		CanDelete:        params.CurrentUser.ID == book.UserID,
+		PriceStats:       &priceStats,
+		TermName:         termName(courseDB, book.TermID),
 	})
 }
 
-// DeleteBookHandler is a route for /books/{id}/delete that deletes a book with a certain ID
-// You have to be logged in and you can only delete your own books
-func DeleteBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
-	bookID := mux.Vars(r)["id"]
+// findOwnedBook retrieves the book with the id route parameter and makes sure that it belongs
+// to the logged in user. action is used to describe what the user was trying to do in the error message.
+// The status is the http status code to respond with when there is an error
+func findOwnedBook(r *http.Request, db gorm.DB, action string) (Book, int, error) {
 	currentUser, err := CurrentUser(r)
 	if err != nil {
-		http.Error(w, "You have to logged in to delete books", http.StatusUnauthorized)
-		return
+		return Book{}, http.StatusUnauthorized, fmt.Errorf("You have to be logged in to %s books", action)
+	}
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return Book{}, http.StatusNotFound, errBookNotFound
+	}
+
+	var book Book
+	if result := db.Where("id = ?", bookID).First(&book); result.Error != nil {
+		return Book{}, http.StatusUnauthorized, errBookNotFound
 	}
 
+	if book.UserID != currentUser.ID {
+		return Book{}, http.StatusUnauthorized, fmt.Errorf("You cannot %s books that you do not own", action)
+	}
+	return book, http.StatusOK, nil
+}
+
+// BookJSONHandler is a route for /books/{id}/json that returns the book with the id in JSON format
+func BookJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	bookID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
 	var book Book
-	if result := db.First(&book, bookID); result.Error != nil {
+	if result := db.Where("id = ?", bookID).First(&book); result.Error != nil {
 		http.Error(w, "Book does not exist", http.StatusUnauthorized)
 		return
 	}
+	attachBookMetadata(db, &book)
 
-	if book.UserID != currentUser.ID {
-		http.Error(w, "You cannot delete books that you do not own", http.StatusUnauthorized)
+	bookJSON, err := json.Marshal(book)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bookJSON)
+}
+
+// DeleteBookHandler is a route for /books/{id}/delete that moves a book with a certain ID to the trash
+// You have to be logged in and you can only delete your own books
+func DeleteBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	book, status, err := findOwnedBook(r, db, "delete")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if result := db.Delete(&book); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/books/trash", http.StatusFound)
+}
+
+// EditBookHandler is a route for /books/{id}/edit that edits a book with a certain ID
+// You have to be logged in and you can only edit your own books
+// GET /books/{id}/edit displays the edit book page
+// POST /books/{id}/edit updates the book from the same post parameters as /books/new
+func EditBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	book, status, err := findOwnedBook(r, db, "edit")
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	db.Delete(&book)
-	http.Redirect(w, r, "/", http.StatusFound)
+	if r.Method == "GET" {
+		t, params, err := GenerateFullTemplate(r, "templates/edit_book.html")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		terms, err := FindTerms(courseDB)
+		if err != nil {
+			http.Error(w, "Error retrieving terms", http.StatusInternalServerError)
+			return
+		}
+
+		t.Execute(w, BookTemplateType{
+			UserTemplateType: params,
+			Book:             book,
+			UserID:           book.UserID,
+			CanDelete:        true,
+			Conditions:       BookConditions,
+			Terms:            terms,
+			TermID:           book.TermID,
+		})
+	} else if r.Method == "POST" {
+		editedBook, err := NewMuxBookFactory(courseDB).NewEditedFormBook(r, book)
+		if err != nil {
+			http.Error(w, "There was an error with validating some of your fields. Please check your input again",
+				http.StatusUnauthorized)
+			return
+		}
+
+		// only copy over the editable fields so that the listing keeps its id and creation date
+		book.Title = editedBook.Title
+		book.ISBN = editedBook.ISBN
+		book.OriginalISBN = editedBook.OriginalISBN
+		book.CourseID = editedBook.CourseID
+		book.Price = editedBook.Price
+		book.Condition = editedBook.Condition
+		book.Details = editedBook.Details
+		book.TermID = editedBook.TermID
+		book.InstitutionID = editedBook.InstitutionID
+		attachBookMetadata(db, &book)
+		if result := db.Save(&book); result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusUnauthorized)
+			return
+		}
+		indexBook(db, book)
+
+		http.Redirect(w, r, fmt.Sprintf("/books/%d", book.ID), http.StatusFound)
+	} else {
+		http.NotFound(w, r)
+	}
 }
 
 // NewBookHandler is a route for /books/new that creates a new book
 // GET /books/new displays the new book page
 // POST /books/new creates a new book from post parameters:
 // isbn string
-// title string
+// title string (optional, defaults to the catalog title for the isbn)
 // course_id integer
 // price float
-// condition integer
+// condition string (new, like_new, good, acceptable, or poor)
 // details string
-func NewBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+// term_id integer (optional, the term the book was used in)
+// photos files (optional, up to 5 images sent as multipart/form-data)
+func NewBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
 	if r.Method == "GET" {
 		t, params, err := GenerateFullTemplate(r, "templates/new_book.html")
 		if err != nil {
@@ -92,7 +235,17 @@ func NewBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 			return
 		}
 
-		t.Execute(w, params)
+		terms, err := FindTerms(courseDB)
+		if err != nil {
+			http.Error(w, "Error retrieving terms", http.StatusInternalServerError)
+			return
+		}
+
+		t.Execute(w, BookTemplateType{
+			UserTemplateType: params,
+			Conditions:       BookConditions,
+			Terms:            terms,
+		})
 	} else if r.Method == "POST" {
 		currentUser, err := CurrentUser(r)
 		if err != nil {
@@ -100,20 +253,82 @@ func NewBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 			return
 		}
 
-		book, err := NewMuxBookFactory().NewFormBook(r, currentUser.ID)
+		book, err := NewMuxBookFactory(courseDB).NewFormBook(r, currentUser.ID)
 		if err != nil {
 			http.Error(w, "There was an error with validating some of your fields. Please check your input again",
 				http.StatusUnauthorized)
 			return
 		}
 
-		if result := db.Create(&book); result.Error != nil {
+		photos, err := formPhotos(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		attachBookMetadata(db, &book)
+		if book.Title == "" {
+			book.Title = book.Metadata.Title
+		}
+
+		// the book is only kept if all of its photos could be stored
+		tx := db.Begin()
+		if result := tx.Create(&book); result.Error != nil {
+			tx.Rollback()
 			http.Error(w, result.Error.Error(), http.StatusUnauthorized)
 			return
 		}
+		if err := saveBookPhotos(*tx, book, photos); err != nil {
+			tx.Rollback()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if result := tx.Commit(); result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+			return
+		}
+		indexBook(db, book)
+		notifyNewBooks(book)
 
 		http.Redirect(w, r, "/", http.StatusFound)
 	} else {
 		http.NotFound(w, r)
 	}
 }
+
+// newBookQueueSize is the number of listings or imports that can wait to be matched before listing a book waits too
+const newBookQueueSize = 64
+
+var (
+	// newBooks are the new books waiting to be matched against saved searches and wanted books
+	newBooks = make(chan []Book, newBookQueueSize)
+
+	// pendingNewBooks counts the new books that have been queued but not matched yet
+	pendingNewBooks sync.WaitGroup
+)
+
+// notifyNewBooks queues new books to notify the users whose saved searches and wanted books they match
+// The matching is done by matchNewBooks so that listing a book does not wait for it
+func notifyNewBooks(books ...Book) {
+	if len(books) == 0 {
+		return
+	}
+	pendingNewBooks.Add(1)
+	newBooks <- books
+}
+
+// matchNewBooks notifies the users whose saved searches and wanted books the queued new books match
+func matchNewBooks(db gorm.DB, courseDB gorm.DB) {
+	for books := range newBooks {
+		for _, book := range books {
+			notifySavedSearches(db, courseDB, book)
+			matchWantedBooks(db, courseDB, book)
+		}
+		pendingNewBooks.Done()
+	}
+}
+
+// WaitForNewBooks waits until the books that have been listed so far are matched and their notifications saved
+func WaitForNewBooks() {
+	pendingNewBooks.Wait()
+}
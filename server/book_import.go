@@ -0,0 +1,277 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	maxImportUploadSize = 1 << 20
+	maxImportRows       = 500
+)
+
+// importColumns are the CSV columns of an import file in the order they are read when the file has no header row
+// The course column holds the ID of the course the book is for
+var importColumns = []string{"isbn", "title", "course", "price", "condition", "details"}
+
+// ImportRowStatus is the outcome of importing a single CSV row
+type ImportRowStatus string
+
+const (
+	ImportCreated  ImportRowStatus = "created"
+	ImportRejected ImportRowStatus = "rejected"
+	ImportSkipped  ImportRowStatus = "skipped" // valid rows that were not saved because the import was rolled back
+)
+
+// ImportRow is the report entry for a single CSV row
+type ImportRow struct {
+	Row    int             `json:"row"`
+	Status ImportRowStatus `json:"status"`
+	BookID int             `json:"book_id,omitempty"`
+	Title  string          `json:"title"`
+	ISBN   string          `json:"isbn"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// ImportReport is the result of a bulk import
+type ImportReport struct {
+	Rows         []ImportRow `json:"rows"`
+	Created      int         `json:"created"`
+	Rejected     int         `json:"rejected"`
+	AllOrNothing bool        `json:"all_or_nothing"`
+	Committed    bool        `json:"committed"`
+}
+
+// ImportTemplateType is the type for the import template
+type ImportTemplateType struct {
+	UserTemplateType
+	Columns []string
+	Report  *ImportReport
+}
+
+// ImportBooks reads a CSV file of books and creates a book for every valid row
+// If allOrNothing is set then no books are saved unless every row is valid
+// The courses of the books are looked up in courseDB
+func ImportBooks(db gorm.DB, courseDB gorm.DB, file io.Reader, userID int, allOrNothing bool) (ImportReport, error) {
+	report := ImportReport{AllOrNothing: allOrNothing}
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return report, err
+	}
+	if len(records) == 0 {
+		return report, errors.New("The import file is empty")
+	}
+
+	// the header row is optional but allows the columns to be in any order
+	columns := importColumns
+	firstRow := 1
+	if strings.EqualFold(strings.TrimSpace(records[0][0]), "isbn") {
+		columns = make([]string, len(records[0]))
+		for i, column := range records[0] {
+			columns[i] = strings.ToLower(strings.TrimSpace(column))
+		}
+		records = records[1:]
+		firstRow = 2
+	}
+	if len(records) > maxImportRows {
+		return report, errors.New("Import files can have at most 500 rows")
+	}
+
+	// the rows are validated and their metadata is looked up before the transaction is started so that
+	// the metadata provider is never waited on while the transaction is open
+	rowValues := make([]url.Values, len(records))
+	books := make([]Book, len(records))
+	errs := make([]error, len(records))
+	invalid := 0
+	for i, record := range records {
+		rowValues[i] = importValues(columns, record)
+		if books[i], errs[i] = NewMuxBookFactory(courseDB).NewValuesBook(rowValues[i], userID); errs[i] != nil {
+			invalid++
+		}
+	}
+	// an all-or-nothing import with an invalid row only validates the rest of the rows
+	if !allOrNothing || invalid == 0 {
+		attachImportMetadata(db, books, errs)
+	}
+
+	tx := &db
+	if allOrNothing {
+		tx = db.Begin()
+	}
+
+	var created []Book
+	for i := range records {
+		row := ImportRow{Row: firstRow + i, ISBN: rowValues[i].Get("isbn")}
+		book, err := books[i], errs[i]
+		if err == nil && !(allOrNothing && (invalid > 0 || report.Rejected > 0)) {
+			if result := tx.Create(&book); result.Error != nil {
+				err = result.Error
+			} else {
+				indexBook(*tx, book)
+				created = append(created, book)
+			}
+		}
+
+		switch {
+		case err != nil:
+			row.Status = ImportRejected
+			row.Error = err.Error()
+			report.Rejected++
+		case book.ID == 0:
+			row.Status = ImportSkipped
+			row.Title = book.Title
+		default:
+			row.Status = ImportCreated
+			row.BookID = book.ID
+			row.Title = book.Title
+			row.ISBN = book.ISBN
+			report.Created++
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	if !allOrNothing {
+		report.Committed = report.Created > 0
+		notifyNewBooks(created...)
+		return report, nil
+	}
+
+	if report.Rejected > 0 {
+		if result := tx.Rollback(); result.Error != nil {
+			return report, result.Error
+		}
+		for i := range report.Rows {
+			if report.Rows[i].Status == ImportCreated {
+				report.Rows[i].Status = ImportSkipped
+				report.Rows[i].BookID = 0
+			}
+		}
+		report.Created = 0
+		return report, nil
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return report, result.Error
+	}
+	report.Committed = true
+	notifyNewBooks(created...)
+	return report, nil
+}
+
+// attachImportMetadata looks up the metadata of the valid books of an import once for every ISBN
+// and uses the catalog title for books without a title
+func attachImportMetadata(db gorm.DB, books []Book, errs []error) {
+	metadataByISBN := map[string]BookMetadata{}
+	for i := range books {
+		if errs[i] != nil {
+			continue
+		}
+		if metadata, ok := metadataByISBN[books[i].ISBN]; ok {
+			books[i].Metadata = metadata
+		} else {
+			attachBookMetadata(db, &books[i])
+			metadataByISBN[books[i].ISBN] = books[i].Metadata
+		}
+		if books[i].Title == "" {
+			books[i].Title = books[i].Metadata.Title
+		}
+	}
+}
+
+// importValues maps a CSV record to the form values that NewValuesBook expects
+func importValues(columns []string, record []string) url.Values {
+	values := url.Values{}
+	for i, column := range columns {
+		if i >= len(record) {
+			break
+		}
+		if column == "course" {
+			column = "course_id"
+		}
+		values.Set(column, strings.TrimSpace(record[i]))
+	}
+	return values
+}
+
+// importBooksRequest imports the CSV file uploaded to the "file" field of a POST request
+// The import is all-or-nothing if the "all_or_nothing" field is set
+func importBooksRequest(r *http.Request, db gorm.DB, courseDB gorm.DB) (ImportReport, int, error) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		return ImportReport{}, http.StatusUnauthorized, errors.New("You have to be logged in to import books")
+	}
+
+	if err := r.ParseMultipartForm(maxImportUploadSize); err != nil {
+		return ImportReport{}, http.StatusBadRequest, err
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return ImportReport{}, http.StatusBadRequest, errors.New("You have to upload a CSV file to import")
+	}
+	defer file.Close()
+
+	allOrNothing := r.PostFormValue("all_or_nothing") != ""
+	report, err := ImportBooks(db, courseDB, file, currentUser.ID, allOrNothing)
+	if err != nil {
+		return report, http.StatusBadRequest, err
+	}
+	return report, http.StatusOK, nil
+}
+
+// ImportBooksHandler is a route for /books/import that creates books from an uploaded CSV file
+// and shows a report of the created and rejected rows
+// POST parameters:
+// file - the CSV file with isbn, title, course, price, condition and details columns
+// all_or_nothing - if set, no books are created unless every row is valid
+func ImportBooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	t, params, err := GenerateFullTemplate(r, "templates/import_books.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	templateParams := ImportTemplateType{
+		UserTemplateType: params,
+		Columns:          importColumns,
+	}
+	if r.Method == "POST" {
+		report, status, err := importBooksRequest(r, db, courseDB)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		templateParams.Report = &report
+	}
+
+	t.Execute(w, templateParams)
+}
+
+// ImportBooksJSONHandler is a route for /books/import/json that creates books from an uploaded CSV file
+// and returns the import report in JSON format
+// POST parameters are the same as ImportBooksHandler
+func ImportBooksJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	report, status, err := importBooksRequest(r, db, courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(reportJSON)
+}
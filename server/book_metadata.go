@@ -0,0 +1,227 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// GoogleBooksURL is the url of the Google Books volumes API
+const GoogleBooksURL = "https://www.googleapis.com/books/v1/volumes"
+
+// ErrMetadataNotFound is returned when a metadata provider does not know about an ISBN
+var ErrMetadataNotFound = errors.New("No book found for ISBN")
+
+// MetadataProvider is an interface for looking up catalog information about a book
+type MetadataProvider interface {
+	Lookup(isbn string) (BookMetadata, error) // gets the metadata for a canonical ISBN-13
+}
+
+// GoogleBooksProvider is an implementation of MetadataProvider that uses the Google Books API
+type GoogleBooksProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewGoogleBooksProvider constructs a new GoogleBooksProvider that sends requests to baseURL
+func NewGoogleBooksProvider(baseURL string) GoogleBooksProvider {
+	return GoogleBooksProvider{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// googleBooksResponse is the part of a Google Books volumes response that is used
+type googleBooksResponse struct {
+	TotalItems int `json:"totalItems"`
+	Items      []struct {
+		VolumeInfo struct {
+			Title         string   `json:"title"`
+			Subtitle      string   `json:"subtitle"`
+			Authors       []string `json:"authors"`
+			Publisher     string   `json:"publisher"`
+			PublishedDate string   `json:"publishedDate"`
+			ImageLinks    struct {
+				Thumbnail string `json:"thumbnail"`
+			} `json:"imageLinks"`
+		} `json:"volumeInfo"`
+	} `json:"items"`
+}
+
+// editionRegexp matches edition names like "8th Edition" inside of book titles
+var editionRegexp = regexp.MustCompile(`(?i)\b(\d+(st|nd|rd|th)|first|second|third|fourth|fifth|sixth|seventh|eighth|ninth|tenth) edition\b`)
+
+// Lookup gets the metadata for an ISBN from Google Books
+func (p GoogleBooksProvider) Lookup(isbn string) (BookMetadata, error) {
+	res, err := p.client.Get(p.baseURL + "?q=" + url.QueryEscape("isbn:"+isbn))
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return BookMetadata{}, fmt.Errorf("Google Books returned status %d", res.StatusCode)
+	}
+
+	var volumes googleBooksResponse
+	if err := json.NewDecoder(res.Body).Decode(&volumes); err != nil {
+		return BookMetadata{}, err
+	}
+	if volumes.TotalItems == 0 || len(volumes.Items) == 0 {
+		return BookMetadata{}, ErrMetadataNotFound
+	}
+
+	info := volumes.Items[0].VolumeInfo
+	return BookMetadata{
+		ISBN:          isbn,
+		Title:         info.Title,
+		Authors:       strings.Join(info.Authors, ", "),
+		Publisher:     info.Publisher,
+		Edition:       editionRegexp.FindString(info.Title + " " + info.Subtitle),
+		PublishedDate: info.PublishedDate,
+		CoverURL:      info.ImageLinks.Thumbnail,
+	}, nil
+}
+
+// metadataProvider is where book metadata that is not in the database is looked up
+var metadataProvider MetadataProvider = NewGoogleBooksProvider(GoogleBooksURL)
+
+// SetMetadataProvider changes where book metadata is looked up
+func SetMetadataProvider(provider MetadataProvider) {
+	metadataProvider = provider
+}
+
+// missingMetadataTTL is how long an ISBN that the metadata provider does not know about
+// is remembered before it is looked up again
+const missingMetadataTTL = 24 * time.Hour
+
+// missingMetadataCache remembers the ISBNs that the metadata provider does not know about so that
+// listings of unknown books don't look them up over and over
+type missingMetadataCache struct {
+	mutex   sync.Mutex
+	expires map[string]time.Time
+}
+
+// missing returns true if an ISBN was not found recently
+func (c *missingMetadataCache) missing(isbn string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	expires, ok := c.expires[isbn]
+	if ok && now.After(expires) {
+		delete(c.expires, isbn)
+		return false
+	}
+	return ok
+}
+
+// add remembers that an ISBN was not found
+func (c *missingMetadataCache) add(isbn string, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.expires[isbn] = now.Add(missingMetadataTTL)
+}
+
+// missingMetadata is the cache of ISBNs that the metadata provider does not know about
+var missingMetadata = &missingMetadataCache{expires: make(map[string]time.Time)}
+
+// FindBookMetadata returns the metadata for an ISBN from the database, looking it up from the
+// metadata provider and caching it in the database if it has not been looked up before
+// ISBNs that the provider does not know about are only looked up again after missingMetadataTTL
+func FindBookMetadata(db gorm.DB, isbn string) (BookMetadata, error) {
+	var metadata BookMetadata
+	if result := db.Where("i_s_b_n = ?", isbn).First(&metadata); result.Error == nil {
+		return metadata, nil
+	} else if !result.RecordNotFound() {
+		return BookMetadata{}, result.Error
+	}
+	if missingMetadata.missing(isbn, time.Now()) {
+		return BookMetadata{}, ErrMetadataNotFound
+	}
+
+	metadata, err := metadataProvider.Lookup(isbn)
+	if err == ErrMetadataNotFound {
+		missingMetadata.add(isbn, time.Now())
+	}
+	if err != nil {
+		return BookMetadata{}, err
+	}
+	metadata.ISBN = isbn
+	if result := db.Create(&metadata); result.Error != nil {
+		return BookMetadata{}, result.Error
+	}
+	return metadata, nil
+}
+
+// attachBookMetadata looks up the metadata for a book. Lookup failures are only logged since
+// books can still be displayed without their metadata
+func attachBookMetadata(db gorm.DB, book *Book) {
+	metadata, err := FindBookMetadata(db, book.ISBN)
+	if err != nil {
+		log.Printf("Error looking up metadata for ISBN %s: %s", book.ISBN, err.Error())
+		return
+	}
+	book.Metadata = metadata
+}
+
+// loadBookMetadata fills in the cached metadata for books without contacting the metadata provider
+func loadBookMetadata(db gorm.DB, books []Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+
+	isbns := make([]string, 0, len(books))
+	for _, book := range books {
+		isbns = append(isbns, book.ISBN)
+	}
+
+	var metadata []BookMetadata
+	if result := db.Where("i_s_b_n IN (?)", isbns).Find(&metadata); result.Error != nil {
+		return result.Error
+	}
+
+	metadataByISBN := make(map[string]BookMetadata, len(metadata))
+	for _, m := range metadata {
+		metadataByISBN[m.ISBN] = m
+	}
+	for i := range books {
+		books[i].Metadata = metadataByISBN[books[i].ISBN]
+	}
+	return nil
+}
+
+// MetadataJSONHandler is a route for /isbn/{isbn}/json that returns the catalog metadata for an ISBN in JSON format
+func MetadataJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	isbn, err := NormalizeISBN(mux.Vars(r)["isbn"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	metadata, err := FindBookMetadata(db, isbn)
+	if err == ErrMetadataNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(metadataJSON)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register gif decoding for uploaded photos
+	"image/jpeg"
+	_ "image/png" // register png decoding for uploaded photos
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// Maximum number of photos that can be uploaded for a book
+	maxBookPhotos = 5
+
+	// Maximum size of a multipart book form with photos
+	maxPhotoUploadSize = 10 << 20
+
+	// Length of the longest side of a generated thumbnail in pixels
+	photoThumbnailSize = 200
+
+	// Maximum number of pixels of an uploaded photo, checked before the photo is decoded
+	maxPhotoPixels = 25000000
+)
+
+// uploadedPhoto is a photo from a multipart form that has been decoded with its encoded thumbnail
+type uploadedPhoto struct {
+	data      []byte
+	format    string
+	thumbnail []byte
+}
+
+// formPhotos decodes the images uploaded in the photos field of a multipart form request and generates
+// their thumbnails, so that a book is only created once all of its photos are valid
+func formPhotos(r *http.Request) ([]uploadedPhoto, error) {
+	if r.MultipartForm == nil {
+		return []uploadedPhoto{}, nil
+	}
+
+	files := r.MultipartForm.File["photos"]
+	if len(files) > maxBookPhotos {
+		return nil, fmt.Errorf("You can only upload up to %d photos", maxBookPhotos)
+	}
+
+	photos := make([]uploadedPhoto, 0, len(files))
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		// the dimensions are read from the header first so that huge images are never decoded
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid image", fileHeader.Filename)
+		}
+		if config.Width*config.Height > maxPhotoPixels {
+			return nil, fmt.Errorf("%s is too large, photos can have at most %d pixels", fileHeader.Filename, maxPhotoPixels)
+		}
+		img, format, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid image", fileHeader.Filename)
+		}
+
+		var thumbnailData bytes.Buffer
+		if err := jpeg.Encode(&thumbnailData, thumbnail(img, photoThumbnailSize), nil); err != nil {
+			return nil, err
+		}
+		photos = append(photos, uploadedPhoto{data: data, format: format, thumbnail: thumbnailData.Bytes()})
+	}
+	return photos, nil
+}
+
+// randomPhotoName returns a random name for storing a photo
+func randomPhotoName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// saveBookPhotos stores the uploaded photos and their thumbnails for a book
+// If a photo can't be saved the photos that were already stored are removed again, so db should be
+// the transaction that created the book and has to be rolled back on error
+func saveBookPhotos(db gorm.DB, book Book, photos []uploadedPhoto) (err error) {
+	var stored []string
+	defer func() {
+		if err != nil {
+			for _, name := range stored {
+				photoStorage.Delete(name)
+			}
+		}
+	}()
+
+	for _, photo := range photos {
+		name, err := randomPhotoName()
+		if err != nil {
+			return err
+		}
+
+		bookPhoto := BookPhoto{
+			BookID:        book.ID,
+			Name:          fmt.Sprintf("books/%d/%s.%s", book.ID, name, photo.format),
+			ThumbnailName: fmt.Sprintf("books/%d/%s_thumb.jpg", book.ID, name),
+			CreatedAt:     time.Now(),
+		}
+
+		if err := photoStorage.Save(bookPhoto.Name, bytes.NewReader(photo.data)); err != nil {
+			return err
+		}
+		stored = append(stored, bookPhoto.Name)
+		if err := photoStorage.Save(bookPhoto.ThumbnailName, bytes.NewReader(photo.thumbnail)); err != nil {
+			return err
+		}
+		stored = append(stored, bookPhoto.ThumbnailName)
+		if result := db.Create(&bookPhoto); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// deleteBookPhotos removes all of the photos for a book from storage and the database
+func deleteBookPhotos(db gorm.DB, bookID int) error {
+	var photos []BookPhoto
+	if result := db.Where("book_id = ?", bookID).Find(&photos); result.Error != nil {
+		return result.Error
+	}
+
+	for _, photo := range photos {
+		if err := photoStorage.Delete(photo.Name); err != nil {
+			return err
+		}
+		if err := photoStorage.Delete(photo.ThumbnailName); err != nil {
+			return err
+		}
+		if result := db.Delete(&photo); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// thumbnail scales an image down so that its longest side is at most size pixels
+// by averaging the pixels that fall inside each thumbnail pixel
+func thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return img
+	}
+
+	newWidth, newHeight := width, height
+	if width > size || height > size {
+		if width > height {
+			newWidth, newHeight = size, height*size/width
+		} else {
+			newWidth, newHeight = width*size/height, size
+		}
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+
+	thumb := image.NewRGBA64(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := bounds.Min.Y + (y+1)*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := bounds.Min.X + (x+1)*width/newWidth
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			thumb.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return thumb
+}
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+)
+
+// maxSearchResults is the most books a search index returns for a single query
+const maxSearchResults = 1000
+
+// SearchIndex is an interface for full-text search over books
+// The index keeps its own copy of the searchable text so that it can include
+// the book metadata and the course, which live in other tables or databases
+type SearchIndex interface {
+	Migrate(db gorm.DB) error                                   // Creates the index and indexes every book
+	Index(db gorm.DB, book Book) error                          // Adds or replaces a book in the index
+	Remove(db gorm.DB, bookID int) error                        // Removes a book from the index
+	Search(db gorm.DB, query string) ([]int, error)             // Returns the IDs of the matching books, most relevant first
+	Matches(db gorm.DB, query string, bookID int) (bool, error) // Returns true if a book matches, without ranking the others
+}
+
+// searchIndex is the index used by the book searches. Searches fall back to
+// matching titles and authors with LIKE when no index is set
+var searchIndex SearchIndex
+
+// SetSearchIndex changes the index used to search books
+func SetSearchIndex(index SearchIndex) {
+	searchIndex = index
+}
+
+// searchDocument is the searchable text of a book
+type searchDocument struct {
+	Title   string
+	Authors string
+	ISBN    string
+	Course  string
+	Details string
+}
+
+// newSearchDocument collects the searchable text of a book from the book, its cached metadata and its course
+func newSearchDocument(db gorm.DB, courseDB gorm.DB, book Book) searchDocument {
+	var metadata BookMetadata
+	db.Where("i_s_b_n = ?", book.ISBN).First(&metadata)
+
+	// the course is indexed both as "CS 31" and "CS31" so that either spelling matches
+	var course Course
+	courseText := ""
+	if result := courseDB.First(&course, book.CourseID); result.Error == nil {
+		courseText = fmt.Sprintf("%s %s %s%s", course.Department, course.CourseID,
+			strings.Replace(course.Department, " ", "", -1), strings.Replace(course.CourseID, " ", "", -1))
+	}
+
+	return searchDocument{
+		Title:   book.Title,
+		Authors: metadata.Authors,
+		ISBN:    book.ISBN + " " + book.OriginalISBN,
+		Course:  courseText,
+		Details: book.Details,
+	}
+}
+
+// searchTerms splits a search query into lowercase words made of letters and digits
+// Queries that are ISBNs are searched for as a single ISBN-13
+func searchTerms(query string) []string {
+	if isbn, err := NormalizeISBN(query); err == nil {
+		return []string{isbn}
+	}
+	return strings.FieldsFunc(strings.ToLower(query), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+}
+
+// reindexBooks adds every book, including books in the trash, to a search index
+func reindexBooks(db gorm.DB, index SearchIndex) error {
+	var books []Book
+	if result := db.Unscoped().Find(&books); result.Error != nil {
+		return result.Error
+	}
+	for _, book := range books {
+		if err := index.Index(db, book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexBook updates a book in the search index. Failures are logged since
+// the book itself has already been saved
+func indexBook(db gorm.DB, book Book) {
+	if searchIndex == nil {
+		return
+	}
+	if err := searchIndex.Index(db, book); err != nil {
+		log.Printf("Error indexing book %d: %s", book.ID, err.Error())
+	}
+}
+
+// unindexBook removes a book from the search index
+func unindexBook(db gorm.DB, bookID int) error {
+	if searchIndex == nil {
+		return nil
+	}
+	return searchIndex.Remove(db, bookID)
+}
+
+// bookSearchQuery restricts a book query to books that match the search query and returns the
+// relevance rank of every match, which is nil if the matches are not ranked
+// Queries without any matches are retried with their misspelled words corrected
+func bookSearchQuery(query string, db *gorm.DB) (*gorm.DB, map[int]int, error) {
+	if searchIndex == nil {
+		return likeSearchQuery(query, db), nil, nil
+	}
+
+	ids, err := searchIndex.Search(*db.New(), query)
+	if err != nil {
+		return nil, nil, err
+	}
+	// misspelled queries are searched again with every unknown word replaced by the closest indexed word
+	if len(ids) == 0 {
+		corrected, ok, err := correctSearchQuery(*db.New(), query)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			if ids, err = searchIndex.Search(*db.New(), corrected); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return db.Where("1 = 0"), nil, nil
+	}
+
+	ranks := make(map[int]int, len(ids))
+	for i, id := range ids {
+		ranks[id] = i + 1
+	}
+	return db.Where("books.id IN (?)", ids), ranks, nil
+}
+
+// bookMatchesQuery returns true if a book is one of the results of a search query, however many other books
+// match it. Misspelled queries are corrected like in bookSearchQuery
+func bookMatchesQuery(db gorm.DB, query string, bookID int) (bool, error) {
+	if searchIndex == nil {
+		var count int
+		result := likeSearchQuery(query, db.Model(&Book{}).Where("books.id = ?", bookID)).Count(&count)
+		return count > 0, result.Error
+	}
+
+	matched, err := searchIndex.Matches(db, query, bookID)
+	if err != nil || matched {
+		return matched, err
+	}
+	corrected, ok, err := correctSearchQuery(db, query)
+	if err != nil || !ok {
+		return false, err
+	}
+	return searchIndex.Matches(db, corrected, bookID)
+}
+
+// rankExpression returns an SQL expression for the relevance rank of a book
+// Books without a rank, like the books of a course that a query names, come first with rank 0
+func rankExpression(ranks map[int]int) string {
+	ids := make([]int, 0, len(ranks))
+	for id := range ranks {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	cases := make([]string, len(ids))
+	for i, id := range ids {
+		cases[i] = fmt.Sprintf("WHEN %d THEN %d", id, ranks[id])
+	}
+	return "(CASE books.id " + strings.Join(cases, " ") + " ELSE 0 END)"
+}
+
+// likeSearchQuery restricts a book query to books whose title or catalog authors contain the search query
+// or whose ISBN matches the search query in either ISBN-10 or ISBN-13 form
+func likeSearchQuery(query string, db *gorm.DB) *gorm.DB {
+	authorsQuery := "i_s_b_n IN (SELECT i_s_b_n FROM book_metadata WHERE authors LIKE ?)"
+	if isbn, err := NormalizeISBN(query); err == nil {
+		return db.Where("title LIKE ? OR "+authorsQuery+" OR i_s_b_n = ?", "%"+query+"%", "%"+query+"%", isbn)
+	}
+	return db.Where("title LIKE ? OR "+authorsQuery, "%"+query+"%", "%"+query+"%")
+}
+
+// SQLiteSearchIndex is a SearchIndex that uses an SQLite FTS4 table
+type SQLiteSearchIndex struct {
+	courseDB gorm.DB
+}
+
+// sqliteColumnWeights are the relevance weights of the columns of the FTS4 table, in column order
+var sqliteColumnWeights = []float64{5, 3, 5, 2, 1}
+
+// NewSQLiteSearchIndex constructs a new SQLiteSearchIndex that looks up courses in courseDB
+func NewSQLiteSearchIndex(courseDB gorm.DB) SQLiteSearchIndex {
+	return SQLiteSearchIndex{courseDB: courseDB}
+}
+
+// Migrate creates the FTS4 table and indexes every book
+func (s SQLiteSearchIndex) Migrate(db gorm.DB) error {
+	result := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS book_search USING fts4(title, authors, isbn, course, details)")
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := migrateSearchTrigrams(db); err != nil {
+		return err
+	}
+	return reindexBooks(db, s)
+}
+
+// Index adds or replaces a book in the FTS4 table
+func (s SQLiteSearchIndex) Index(db gorm.DB, book Book) error {
+	doc := newSearchDocument(db, s.courseDB, book)
+	if err := s.Remove(db, book.ID); err != nil {
+		return err
+	}
+	result := db.Exec("INSERT INTO book_search (docid, title, authors, isbn, course, details) VALUES (?, ?, ?, ?, ?, ?)",
+		book.ID, doc.Title, doc.Authors, doc.ISBN, doc.Course, doc.Details)
+	if result.Error != nil {
+		return result.Error
+	}
+	return indexSearchTrigrams(db, book.ID, doc)
+}
+
+// Remove removes a book from the FTS4 table
+func (s SQLiteSearchIndex) Remove(db gorm.DB, bookID int) error {
+	if result := db.Exec("DELETE FROM book_search WHERE docid = ?", bookID); result.Error != nil {
+		return result.Error
+	}
+	return removeSearchTrigrams(db, bookID)
+}
+
+// sqliteMatchQuery returns the FTS4 query that matches every search term as a prefix
+func sqliteMatchQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = term + "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search matches every search term as a prefix and ranks the matches with BM25
+// computed from the FTS4 match info, since FTS4 has no ranking function of its own
+func (s SQLiteSearchIndex) Search(db gorm.DB, query string) ([]int, error) {
+	match := sqliteMatchQuery(query)
+	if match == "" {
+		return []int{}, nil
+	}
+
+	rows, err := db.Raw("SELECT docid, matchinfo(book_search, 'pcnx') FROM book_search WHERE book_search MATCH ?",
+		match).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits searchHits
+	for rows.Next() {
+		var id int
+		var info []byte
+		if err := rows.Scan(&id, &info); err != nil {
+			return nil, err
+		}
+		hits = append(hits, searchHit{id, bm25(info)})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Stable(hits)
+	if len(hits) > maxSearchResults {
+		hits = hits[:maxSearchResults]
+	}
+	ids := make([]int, len(hits))
+	for i, h := range hits {
+		ids[i] = h.id
+	}
+	return ids, nil
+}
+
+// Matches looks up the FTS4 row of a book with the query
+func (s SQLiteSearchIndex) Matches(db gorm.DB, query string, bookID int) (bool, error) {
+	match := sqliteMatchQuery(query)
+	if match == "" {
+		return false, nil
+	}
+	var count int
+	err := db.Raw("SELECT COUNT(*) FROM book_search WHERE docid = ? AND book_search MATCH ?", bookID, match).Row().Scan(&count)
+	return count > 0, err
+}
+
+// searchHit is a matching book and its relevance score
+type searchHit struct {
+	id    int
+	score float64
+}
+
+// searchHits sorts search hits from the most to the least relevant
+type searchHits []searchHit
+
+func (h searchHits) Len() int           { return len(h) }
+func (h searchHits) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h searchHits) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+// bm25 scores a row from its FTS4 'pcnx' match info: the phrase count, the column count,
+// the row count and then the hits in this row, the hits in all rows and the rows with hits
+// for every phrase and column
+func bm25(info []byte) float64 {
+	values := make([]float64, len(info)/4)
+	for i := range values {
+		values[i] = float64(binary.LittleEndian.Uint32(info[i*4:]))
+	}
+	if len(values) < 3 {
+		return 0
+	}
+
+	phrases, columns, rowCount := int(values[0]), int(values[1]), values[2]
+	score := 0.0
+	for p := 0; p < phrases; p++ {
+		for c := 0; c < columns && c < len(sqliteColumnWeights); c++ {
+			x := 3 + 3*(p*columns+c)
+			if x+2 >= len(values) {
+				return score
+			}
+			hits, rowsWithHits := values[x], values[x+2]
+			if hits == 0 {
+				continue
+			}
+			idf := math.Log(1 + (rowCount-rowsWithHits+0.5)/(rowsWithHits+0.5))
+			score += sqliteColumnWeights[c] * idf * hits / (hits + 1.2)
+		}
+	}
+	return score
+}
+
+// PostgresSearchIndex is a SearchIndex that uses a postgres tsvector column
+type PostgresSearchIndex struct {
+	courseDB gorm.DB
+}
+
+// NewPostgresSearchIndex constructs a new PostgresSearchIndex that looks up courses in courseDB
+func NewPostgresSearchIndex(courseDB gorm.DB) PostgresSearchIndex {
+	return PostgresSearchIndex{courseDB: courseDB}
+}
+
+// Migrate creates the tsvector table with its GIN index and indexes every book
+func (s PostgresSearchIndex) Migrate(db gorm.DB) error {
+	result := db.Exec("CREATE TABLE IF NOT EXISTS book_search (book_id integer PRIMARY KEY, document tsvector NOT NULL)")
+	if result.Error != nil {
+		return result.Error
+	}
+	result = db.Exec("CREATE INDEX IF NOT EXISTS book_search_document ON book_search USING gin(document)")
+	if result.Error != nil {
+		return result.Error
+	}
+	if err := migrateSearchTrigrams(db); err != nil {
+		return err
+	}
+	return reindexBooks(db, s)
+}
+
+// Index adds or replaces a book in the tsvector table
+// Titles and ISBNs are weighted highest, then authors, the course and the details
+func (s PostgresSearchIndex) Index(db gorm.DB, book Book) error {
+	doc := newSearchDocument(db, s.courseDB, book)
+	if err := s.Remove(db, book.ID); err != nil {
+		return err
+	}
+	result := db.Exec(`INSERT INTO book_search (book_id, document) VALUES (?,
+		setweight(to_tsvector('simple', ?), 'A') ||
+		setweight(to_tsvector('simple', ?), 'B') ||
+		setweight(to_tsvector('simple', ?), 'A') ||
+		setweight(to_tsvector('simple', ?), 'C') ||
+		setweight(to_tsvector('simple', ?), 'D'))`,
+		book.ID, doc.Title, doc.Authors, doc.ISBN, doc.Course, doc.Details)
+	if result.Error != nil {
+		return result.Error
+	}
+	return indexSearchTrigrams(db, book.ID, doc)
+}
+
+// Remove removes a book from the tsvector table
+func (s PostgresSearchIndex) Remove(db gorm.DB, bookID int) error {
+	if result := db.Exec("DELETE FROM book_search WHERE book_id = ?", bookID); result.Error != nil {
+		return result.Error
+	}
+	return removeSearchTrigrams(db, bookID)
+}
+
+// postgresTSQuery returns the tsquery that matches every search term as a prefix
+func postgresTSQuery(query string) string {
+	terms := searchTerms(query)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// Search matches every search term as a prefix and ranks the matches with ts_rank
+func (s PostgresSearchIndex) Search(db gorm.DB, query string) ([]int, error) {
+	tsquery := postgresTSQuery(query)
+	if tsquery == "" {
+		return []int{}, nil
+	}
+
+	rows, err := db.Raw(`SELECT book_id FROM book_search WHERE document @@ to_tsquery('simple', ?)
+		ORDER BY ts_rank(document, to_tsquery('simple', ?)) DESC, book_id LIMIT ?`,
+		tsquery, tsquery, maxSearchResults).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// Matches looks up the tsvector of a book with the query
+func (s PostgresSearchIndex) Matches(db gorm.DB, query string, bookID int) (bool, error) {
+	tsquery := postgresTSQuery(query)
+	if tsquery == "" {
+		return false, nil
+	}
+	var count int
+	err := db.Raw("SELECT COUNT(*) FROM book_search WHERE book_id = ? AND document @@ to_tsquery('simple', ?)",
+		bookID, tsquery).Row().Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// BookStatus is the lifecycle state of a book listing
+type BookStatus string
+
+// The lifecycle states a book listing can be in
+const (
+	BookAvailable BookStatus = "available" // listing is open to buyers
+	BookReserved  BookStatus = "reserved"  // seller is holding the book for a buyer
+	BookSold      BookStatus = "sold"      // book has been sold
+	BookWithdrawn BookStatus = "withdrawn" // seller took the listing down without selling
+	BookExpired   BookStatus = "expired"   // listing was not renewed before its expiration date
+)
+
+// bookStatusTransitions maps every status to the statuses that an owner can move it to.
+// Books are only moved in and out of expired by the expiration sweeper and renewals
+var bookStatusTransitions = map[BookStatus][]BookStatus{
+	BookAvailable: {BookReserved, BookSold, BookWithdrawn},
+	BookReserved:  {BookAvailable, BookSold, BookWithdrawn},
+	BookWithdrawn: {BookAvailable},
+	BookSold:      {},
+	BookExpired:   {},
+}
+
+// NextStatuses returns the statuses that a book with this status can be moved to
+func (s BookStatus) NextStatuses() []BookStatus {
+	return bookStatusTransitions[s]
+}
+
+// CanTransition returns true if a book with this status can be moved to the status to
+func (s BookStatus) CanTransition(to BookStatus) bool {
+	for _, next := range s.NextStatuses() {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionBook moves a book to a new status and records the change
+func TransitionBook(db gorm.DB, book *Book, to BookStatus, userID int) error {
+	if !book.Status.CanTransition(to) {
+		return fmt.Errorf("A %s book cannot be marked as %s", book.Status, to)
+	}
+	return recordTransition(db, book, to, userID)
+}
+
+// recordTransition moves a book to a new status and records the change without
+// checking if the change is allowed
+func recordTransition(db gorm.DB, book *Book, to BookStatus, userID int) error {
+	change := BookStatusChange{
+		BookID:    book.ID,
+		UserID:    userID,
+		OldStatus: book.Status,
+		NewStatus: to,
+		CreatedAt: time.Now(),
+	}
+
+	tx := db.Begin()
+	if result := tx.Model(book).UpdateColumn("status", to); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result := tx.Create(&change); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return result.Error
+	}
+
+	book.Status = to
+	return nil
+}
+
+// statusQuery restricts a book query to the status in the status query parameter.
+// Only available books are returned if the parameter is empty and books with any status
+// are returned if the parameter is "all"
+func statusQuery(r *http.Request, db gorm.DB) *gorm.DB {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		return db.Where("status = ?", BookAvailable)
+	case "all":
+		return db.Where("1 = 1")
+	default:
+		return db.Where("status = ?", status)
+	}
+}
+
+// BookStatusHandler is a route for /books/{id}/status that changes the status of a book with a certain ID
+// You have to be logged in and you can only change the status of your own books
+// POST parameters:
+// status string (available, reserved, sold, or withdrawn)
+func BookStatusHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	book, code, err := findOwnedBook(r, db, "change the status of")
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := BookStatus(r.PostFormValue("status"))
+	if err := TransitionBook(db, &book, status, book.UserID); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/books/%d", book.ID), http.StatusFound)
+}
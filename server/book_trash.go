@@ -0,0 +1,127 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TrashRetention is how long deleted books stay in the trash before they are purged
+const TrashRetention = 30 * 24 * time.Hour
+
+// PurgeAt returns when a deleted book will be permanently removed from the trash
+func (b Book) PurgeAt() time.Time {
+	if b.DeletedAt == nil {
+		return time.Time{}
+	}
+	return b.DeletedAt.Add(TrashRetention)
+}
+
+// findTrashedBook retrieves the deleted book with the id route parameter and makes sure
+// that it belongs to the logged in user and that it is still inside the retention window
+func findTrashedBook(r *http.Request, db gorm.DB, action string) (Book, int, error) {
+	book, status, err := findOwnedBook(r, *db.Unscoped(), action)
+	if err != nil {
+		return Book{}, status, err
+	}
+	if book.DeletedAt == nil || time.Now().After(book.PurgeAt()) {
+		return Book{}, http.StatusUnauthorized, errors.New("Book is not in the trash")
+	}
+	return book, http.StatusOK, nil
+}
+
+// purgeBook permanently removes a book along with its photos and status history
+func purgeBook(db gorm.DB, book Book) error {
+	if err := deleteBookPhotos(db, book.ID); err != nil {
+		return err
+	}
+	if result := db.Where("book_id = ?", book.ID).Delete(&BookStatusChange{}); result.Error != nil {
+		return result.Error
+	}
+	if err := unindexBook(db, book.ID); err != nil {
+		return err
+	}
+	return db.Unscoped().Delete(&book).Error
+}
+
+// PurgeDeletedBooks permanently removes every book that has been in the trash for longer
+// than TrashRetention before now and returns the number of books that were purged
+func PurgeDeletedBooks(db gorm.DB, now time.Time) (int, error) {
+	var oldBooks []Book
+	result := db.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", now.Add(-TrashRetention)).Find(&oldBooks)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	for i, book := range oldBooks {
+		if err := purgeBook(db, book); err != nil {
+			return i, err
+		}
+	}
+	return len(oldBooks), nil
+}
+
+// TrashHandler is a route for /books/trash that displays all of your deleted books that can still be restored
+func TrashHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your trash", http.StatusUnauthorized)
+		return
+	}
+
+	var trashedBooks []Book
+	result := db.Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at >= ?",
+		currentUser.ID, time.Now().Add(-TrashRetention)).Order("deleted_at desc").Find(&trashedBooks)
+	if result.Error != nil {
+		http.Error(w, "Error retrieving books", http.StatusInternalServerError)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/trash.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, ManyBookTemplateType{
+		UserTemplateType: params,
+		Books:            trashedBooks,
+		Title:            "Trash",
+	})
+}
+
+// RestoreBookHandler is a route for /books/{id}/restore that moves a deleted book with a certain ID out of the trash
+// You have to be logged in and you can only restore your own books
+func RestoreBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	book, status, err := findTrashedBook(r, db, "restore")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if result := db.Unscoped().Exec("UPDATE books SET deleted_at = NULL WHERE id = ?", book.ID); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/books/trash", http.StatusFound)
+}
+
+// PurgeBookHandler is a route for /books/{id}/purge that permanently removes a deleted book with a certain ID
+// You have to be logged in and you can only purge your own books
+func PurgeBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	book, status, err := findTrashedBook(r, db, "purge")
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	if err := purgeBook(db, book); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/books/trash", http.StatusFound)
+}
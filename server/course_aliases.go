@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Alias file fields along with the department and course_id fields of course catalogs
+// A row with an alias adds the alias to its department, and a row with a cross listing adds its department
+// and course number to the cross listing group with that name, like "logic design"
+const (
+	AliasFieldAlias        = "alias"
+	AliasFieldCrossListing = "cross_listing"
+)
+
+// CourseAliasImportReport is the result of importing a file of department aliases and cross listings
+type CourseAliasImportReport struct {
+	Aliases       []DepartmentAlias  `json:"aliases"`        // aliases that were created
+	CrossListings []CrossListing     `json:"cross_listings"` // cross listings that were created
+	Unchanged     int                `json:"unchanged"`
+	Skipped       []SkippedCourseRow `json:"skipped"`
+	DryRun        bool               `json:"dry_run"`
+}
+
+// pendingCrossListing is a cross listing row that is waiting for the group of its name
+type pendingCrossListing struct {
+	row     int
+	name    string
+	listing CrossListing
+}
+
+// ImportCourseAliases reads a CSV or JSON file of department aliases and cross listings for a campus, or for
+// every campus if the institution is empty, and adds the ones that are new. Departments and courses have
+// to be in the catalog of the campus. Cross listings with the same name join the group of any of them that
+// is already cross listed, and a course number can only be in one group. Nothing is saved if dryRun is set
+func ImportCourseAliases(courseDB gorm.DB, file io.Reader, format string, institution Institution,
+	dryRun bool) (CourseAliasImportReport, error) {
+	report := CourseAliasImportReport{
+		Aliases:       []DepartmentAlias{},
+		CrossListings: []CrossListing{},
+		Skipped:       []SkippedCourseRow{},
+		DryRun:        dryRun,
+	}
+
+	rows, err := readCourseRecords(file, format)
+	if err != nil {
+		return report, err
+	}
+
+	resolver, err := newDepartmentResolver(courseDB, institution.ID)
+	if err != nil {
+		return report, err
+	}
+	departments := make(map[string]string, len(resolver.departments))
+	for _, department := range resolver.departments {
+		departments[strings.ToLower(department)] = department
+	}
+
+	var existingAliases []DepartmentAlias
+	if result := courseDB.Where("institution_id = ?", institution.ID).Find(&existingAliases); result.Error != nil {
+		return report, result.Error
+	}
+	aliases := make(map[string]bool, len(existingAliases))
+	for _, alias := range existingAliases {
+		aliases[alias.Alias+"\x00"+strings.ToLower(alias.Department)] = true
+	}
+
+	var existingListings []CrossListing
+	if result := courseDB.Where("institution_id = ?", institution.ID).Find(&existingListings); result.Error != nil {
+		return report, result.Error
+	}
+	groups := make(map[string]int, len(existingListings))
+	nextGroupID := 1
+	for _, listing := range existingListings {
+		groups[sectionKey(listing.Department, listing.CourseID)] = listing.GroupID
+		if listing.GroupID >= nextGroupID {
+			nextGroupID = listing.GroupID + 1
+		}
+	}
+
+	firstRow := 1
+	if format == "csv" {
+		firstRow = 2
+	}
+	skip := func(row int, reason string) {
+		report.Skipped = append(report.Skipped, SkippedCourseRow{Row: row, Reason: reason})
+	}
+	var pending []pendingCrossListing
+	nameGroups := map[string]int{}
+	for i, row := range rows {
+		rowNumber := firstRow + i
+		name := cleanCourseField(row[CourseFieldDepartment])
+		department, ok := departments[strings.ToLower(name)]
+		if !ok {
+			skip(rowNumber, "No department "+name)
+			continue
+		}
+
+		alias := normalizeAlias(row[AliasFieldAlias])
+		crossListing := strings.ToLower(cleanCourseField(row[AliasFieldCrossListing]))
+		switch {
+		case alias != "":
+			key := alias + "\x00" + strings.ToLower(department)
+			if aliases[key] {
+				report.Unchanged++
+				continue
+			}
+			aliases[key] = true
+			report.Aliases = append(report.Aliases, DepartmentAlias{Alias: alias, Department: department, InstitutionID: institution.ID})
+		case crossListing != "":
+			courseID := strings.ToUpper(cleanCourseField(row[CourseFieldCourseID]))
+			var count int
+			result := filterInstitution(courseDB.Model(&Course{}), institution.ID).
+				Where("department = ? AND UPPER(course_id) = ?", department, courseID).Count(&count)
+			if result.Error != nil {
+				return report, result.Error
+			}
+			if count == 0 {
+				skip(rowNumber, strings.TrimSpace(fmt.Sprintf("No course %s %s", department, courseID)))
+				continue
+			}
+
+			listing := CrossListing{Department: department, CourseID: courseID, InstitutionID: institution.ID}
+			pending = append(pending, pendingCrossListing{row: rowNumber, name: crossListing, listing: listing})
+			if groupID, ok := groups[sectionKey(department, courseID)]; ok && nameGroups[crossListing] == 0 {
+				nameGroups[crossListing] = groupID
+			}
+		default:
+			skip(rowNumber, "No alias or cross listing")
+		}
+	}
+
+	for _, p := range pending {
+		groupID := nameGroups[p.name]
+		if groupID == 0 {
+			groupID = nextGroupID
+			nextGroupID++
+			nameGroups[p.name] = groupID
+		}
+		key := sectionKey(p.listing.Department, p.listing.CourseID)
+		if existing, ok := groups[key]; ok {
+			if existing == groupID {
+				report.Unchanged++
+			} else {
+				skip(p.row, fmt.Sprintf("%s %s is already cross listed with other courses", p.listing.Department, p.listing.CourseID))
+			}
+			continue
+		}
+		groups[key] = groupID
+		p.listing.GroupID = groupID
+		report.CrossListings = append(report.CrossListings, p.listing)
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	tx := courseDB.Begin()
+	now := time.Now()
+	for i := range report.Aliases {
+		alias := &report.Aliases[i]
+		alias.CreatedAt, alias.UpdatedAt = now, now
+		if result := tx.Create(alias); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	for i := range report.CrossListings {
+		listing := &report.CrossListings[i]
+		listing.CreatedAt, listing.UpdatedAt = now, now
+		if result := tx.Create(listing); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return report, result.Error
+	}
+	invalidateDepartmentResolvers()
+	return report, nil
+}
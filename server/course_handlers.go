@@ -8,16 +8,16 @@ import (
 )
 
 // CoursesJSONHandler is a route for /courses/{id}/json that returns the course for an id in JSON format
-func CoursesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
-	courseID := mux.Vars(r)["id"]
-
-	var course Course
-	if result := db.First(&course, courseID); result.Error != nil {
-		http.Error(w, "Course does not exist", http.StatusUnauthorized)
+// along with the professors teaching the course, the number and lowest price of its listings and a page of the listings
+// GET parameters are the same as CourseHandler
+func CoursesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	courseListings, status, err := courseListingsRequest(r, db, courseDB, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	courseJSON, err := json.Marshal(course)
+	courseJSON, err := json.Marshal(courseListings)
 	if err != nil {
 		http.NotFound(w, r)
 		return
@@ -26,3 +26,119 @@ func CoursesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(courseJSON)
 }
+
+// CourseHandler is a route for /courses/{id} that displays the listings for a course and the professors teaching it
+// GET parameters:
+// status string
+// cursor string
+// page_size int
+func CourseHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	courseListings, status, err := courseListingsRequest(r, db, courseDB, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := loadBookMetadata(db, courseListings.Books); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/course.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, CourseTemplateType{
+		UserTemplateType: params,
+		CourseListings:   courseListings,
+	})
+}
+
+// DepartmentHandler is a route for /departments/{dept} that displays the courses in a department with their listings
+// GET parameters are the same as CourseHandler
+func DepartmentHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	departmentListings, status, err := departmentListingsRequest(r, db, courseDB, mux.Vars(r)["dept"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if err := loadBookMetadata(db, departmentListings.Books); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/department.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, DepartmentTemplateType{
+		UserTemplateType:   params,
+		DepartmentListings: departmentListings,
+	})
+}
+
+// DepartmentJSONHandler is a route for /departments/{dept}/json that returns the courses in a department
+// with their listings in JSON format
+// GET parameters are the same as CourseHandler
+func DepartmentJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	departmentListings, status, err := departmentListingsRequest(r, db, courseDB, mux.Vars(r)["dept"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	departmentJSON, err := json.Marshal(departmentListings)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(departmentJSON)
+}
+
+// CourseTextbooksJSONHandler is a route for /courses/{id}/textbooks/json that returns the textbooks of a course
+// in JSON format with the number of listings for each of them and the cheapest listing
+// GET parameters are the same as CourseTextbooksHandler
+func CourseTextbooksJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	courseTextbooks, status, err := courseTextbooksRequest(r, db, courseDB, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	textbooksJSON, err := json.Marshal(courseTextbooks)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(textbooksJSON)
+}
+
+// CourseTextbooksHandler is a route for /courses/{id}/textbooks that displays the required and optional textbooks
+// of a course with the number of listings for each of them and the cheapest listing
+// GET parameters:
+// status string (the status of the listings to count, available by default)
+func CourseTextbooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	courseTextbooks, status, err := courseTextbooksRequest(r, db, courseDB, mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/course_textbooks.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, CourseTextbooksTemplateType{
+		UserTemplateType: params,
+		CourseTextbooks:  courseTextbooks,
+	})
+}
@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Course catalog fields that a column mapping can map a catalog column to
+// The professor can come from one column or from separate first and last name columns
+const (
+	CourseFieldDepartment     = "department"
+	CourseFieldCourseID       = "course_id"
+	CourseFieldProfessor      = "professor"
+	CourseFieldProfessorFirst = "professor_first_name"
+	CourseFieldProfessorLast  = "professor_last_name"
+)
+
+// CourseFields lists every field of a course catalog row in the order they are combined
+var CourseFields = []string{
+	CourseFieldDepartment,
+	CourseFieldCourseID,
+	CourseFieldProfessor,
+	CourseFieldProfessorFirst,
+	CourseFieldProfessorLast,
+}
+
+// CourseColumnMapping maps course fields to the column names of a catalog file
+// Fields that are not in the mapping are read from the column with the same name as the field
+type CourseColumnMapping map[string]string
+
+// ParseCourseColumnMapping reads a column mapping like "department=Subject,course_id=Catalog Number"
+func ParseCourseColumnMapping(s string) (CourseColumnMapping, error) {
+	return parseColumnMapping(s, CourseFields)
+}
+
+// parseColumnMapping reads a column mapping of a catalog file with the given fields
+func parseColumnMapping(s string, fields []string) (CourseColumnMapping, error) {
+	mapping := CourseColumnMapping{}
+	if strings.TrimSpace(s) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("%q is not a field=column pair", pair)
+		}
+		field := strings.TrimSpace(parts[0])
+		if !hasField(fields, field) {
+			return nil, fmt.Errorf("%q is not a field, expected one of %s", field, strings.Join(fields, ", "))
+		}
+		mapping[field] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// column returns the catalog column name for a field
+func (m CourseColumnMapping) column(field string) string {
+	if column, ok := m[field]; ok {
+		return column
+	}
+	return field
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// CourseChange is a catalog row that was created or updated by an import
+// Old is the course before the import and is empty for created courses
+type CourseChange struct {
+	Row int    `json:"row"`
+	Old Course `json:"old"`
+	New Course `json:"new"`
+}
+
+// SkippedCourseRow is a catalog row that was not imported and the reason why
+type SkippedCourseRow struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// CourseImportReport is the result of importing a course catalog
+// Unchanged counts the rows that are already in the catalog and Missing counts
+// the catalog courses of the same term that are not in the file, which are kept
+type CourseImportReport struct {
+	Created     []CourseChange     `json:"created"`
+	Updated     []CourseChange     `json:"updated"`
+	Unchanged   int                `json:"unchanged"`
+	Skipped     []SkippedCourseRow `json:"skipped"`
+	Missing     int                `json:"missing"`
+	DryRun      bool               `json:"dry_run"`
+	Term        Term               `json:"term"`        // has an ID of 0 if a dry run would create the term
+	Institution Institution        `json:"institution"` // has an ID of 0 if a dry run would create the campus
+}
+
+// courseKey is the key that catalog rows are upserted by
+// Keys ignore case and repeated spaces so that fixing the spelling of a name updates the course
+func courseKey(department, courseID, professor string) string {
+	return strings.ToLower(department + "\x00" + courseID + "\x00" + professor)
+}
+
+// cleanCourseField trims a catalog value and collapses repeated spaces
+func cleanCourseField(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// readCourseRecords reads the rows of a CSV or JSON catalog as maps from column name to value
+// CSV files need a header row and JSON files have to be an array of objects
+func readCourseRecords(file io.Reader, format string) ([]map[string]string, error) {
+	switch format {
+	case "csv":
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("The catalog file is empty")
+		}
+		header := records[0]
+		rows := make([]map[string]string, len(records)-1)
+		for i, record := range records[1:] {
+			rows[i] = map[string]string{}
+			for j, column := range header {
+				if j < len(record) {
+					rows[i][strings.TrimSpace(column)] = record[j]
+				}
+			}
+		}
+		return rows, nil
+	case "json":
+		var objects []map[string]interface{}
+		if err := json.NewDecoder(file).Decode(&objects); err != nil {
+			return nil, err
+		}
+		rows := make([]map[string]string, len(objects))
+		for i, object := range objects {
+			rows[i] = map[string]string{}
+			for column, value := range object {
+				if value != nil {
+					rows[i][column] = fmt.Sprint(value)
+				}
+			}
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("Unknown catalog format %q, expected csv or json", format)
+}
+
+// catalogRowProfessor reads the professor of a catalog row from one column or from the first and last name columns
+func catalogRowProfessor(row map[string]string, mapping CourseColumnMapping) string {
+	if professor := cleanCourseField(row[mapping.column(CourseFieldProfessor)]); professor != "" {
+		return professor
+	}
+	return cleanCourseField(row[mapping.column(CourseFieldProfessorFirst)] + " " + row[mapping.column(CourseFieldProfessorLast)])
+}
+
+// catalogRowCourse builds a course from a catalog row with a column mapping
+func catalogRowCourse(row map[string]string, mapping CourseColumnMapping) (Course, error) {
+	course := Course{
+		Department: cleanCourseField(row[mapping.column(CourseFieldDepartment)]),
+		CourseID:   cleanCourseField(row[mapping.column(CourseFieldCourseID)]),
+		Professor:  catalogRowProfessor(row, mapping),
+	}
+	if course.Department == "" {
+		return course, fmt.Errorf("Missing %s column %q", CourseFieldDepartment, mapping.column(CourseFieldDepartment))
+	}
+	if course.CourseID == "" {
+		return course, fmt.Errorf("Missing %s column %q", CourseFieldCourseID, mapping.column(CourseFieldCourseID))
+	}
+	return course, nil
+}
+
+// ImportCourses reads a CSV or JSON course catalog and upserts every row into the course database by
+// department, course number and professor. Rows that are missing a department or course number and
+// rows that repeat an earlier row are skipped. Nothing is saved if dryRun is set, but the report
+// still lists the changes the import would make
+// The courses belong to a campus and a term, which are created first if they have a name but no ID.
+// Courses of an empty campus are shared by every campus and courses of an empty term are offered in every term
+func ImportCourses(courseDB gorm.DB, file io.Reader, format string, mapping CourseColumnMapping, institution Institution, term Term,
+	dryRun bool) (CourseImportReport, error) {
+	report := CourseImportReport{
+		Institution: institution,
+		Term:        term,
+		Created:     []CourseChange{},
+		Updated:     []CourseChange{},
+		Skipped:     []SkippedCourseRow{},
+		DryRun:      dryRun,
+	}
+
+	rows, err := readCourseRecords(file, format)
+	if err != nil {
+		return report, err
+	}
+
+	// a campus or term that has not been created yet has no courses, and courses from before terms and
+	// campuses were added have NULL instead of 0
+	var existing []Course
+	if (term.ID != 0 || term.Name == "") && (institution.ID != 0 || institution.Name == "") {
+		result := courseDB.Where("COALESCE(term_id, 0) = ? AND COALESCE(institution_id, 0) = ?", term.ID, institution.ID).
+			Find(&existing)
+		if result.Error != nil {
+			return report, result.Error
+		}
+	}
+	catalog := make(map[string]Course, len(existing))
+	for _, course := range existing {
+		catalog[courseKey(course.Department, course.CourseID, course.Professor)] = course
+	}
+	// names of professors whose spellings were merged are imported as the name they were merged into
+	directory := professorDirectory{byKey: map[string]Professor{}, byID: map[int]Professor{}}
+	if institution.ID != 0 || institution.Name == "" {
+		if directory, err = loadProfessorDirectory(&courseDB, institution.ID); err != nil {
+			return report, err
+		}
+	}
+
+	// rows are numbered like lines in the file, so the first CSV row after the header is row 2
+	firstRow := 1
+	if format == "csv" {
+		firstRow = 2
+	}
+	seen := map[string]int{}
+	for i, row := range rows {
+		rowNumber := firstRow + i
+		course, err := catalogRowCourse(row, mapping)
+		if err != nil {
+			report.Skipped = append(report.Skipped, SkippedCourseRow{Row: rowNumber, Reason: err.Error()})
+			continue
+		}
+		course.TermID, course.InstitutionID = term.ID, institution.ID
+		course.Professor = directory.canonicalName(course.Professor)
+		key := courseKey(course.Department, course.CourseID, course.Professor)
+		if earlier, ok := seen[key]; ok {
+			report.Skipped = append(report.Skipped, SkippedCourseRow{
+				Row:    rowNumber,
+				Reason: fmt.Sprintf("Same course as row %d", earlier),
+			})
+			continue
+		}
+		seen[key] = rowNumber
+
+		old, ok := catalog[key]
+		switch {
+		case !ok:
+			report.Created = append(report.Created, CourseChange{Row: rowNumber, New: course})
+		case old.Department != course.Department || old.CourseID != course.CourseID || old.Professor != course.Professor:
+			updated := old
+			updated.Department, updated.CourseID, updated.Professor = course.Department, course.CourseID, course.Professor
+			report.Updated = append(report.Updated, CourseChange{Row: rowNumber, Old: old, New: updated})
+		default:
+			report.Unchanged++
+		}
+	}
+	for key := range catalog {
+		if _, ok := seen[key]; !ok {
+			report.Missing++
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	tx := courseDB.Begin()
+	now := time.Now()
+	if report.Institution.ID == 0 && report.Institution.Name != "" {
+		if result := tx.Create(&report.Institution); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	if report.Term.ID == 0 && report.Term.Name != "" {
+		if result := tx.Create(&report.Term); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	if directory.institutionID != report.Institution.ID {
+		if directory, err = loadProfessorDirectory(tx, report.Institution.ID); err != nil {
+			tx.Rollback()
+			return report, err
+		}
+	}
+	for i := range report.Created {
+		course := &report.Created[i].New
+		course.TermID, course.InstitutionID = report.Term.ID, report.Institution.ID
+		course.CreatedAt, course.UpdatedAt = now, now
+		if result := tx.Create(course); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+		if err := linkCourseProfessor(tx, directory, *course); err != nil {
+			tx.Rollback()
+			return report, err
+		}
+	}
+	for i := range report.Updated {
+		course := &report.Updated[i].New
+		course.UpdatedAt = now
+		if result := tx.Save(course); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+		if err := linkCourseProfessor(tx, directory, *course); err != nil {
+			tx.Rollback()
+			return report, err
+		}
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return report, result.Error
+	}
+	invalidateDepartmentResolvers()
+	return report, nil
+}
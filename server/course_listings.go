@@ -0,0 +1,254 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ListingStats are the number of listings for one or more courses and their lowest price
+type ListingStats struct {
+	ListingCount int     `json:"listing_count"`
+	LowestPrice  float64 `json:"lowest_price,omitempty"` // zero when there are no listings
+}
+
+// add adds the listings counted in other to the stats
+func (s *ListingStats) add(other ListingStats) {
+	if other.ListingCount == 0 {
+		return
+	}
+	if s.ListingCount == 0 || other.LowestPrice < s.LowestPrice {
+		s.LowestPrice = other.LowestPrice
+	}
+	s.ListingCount += other.ListingCount
+}
+
+// CourseListings is a course with the listings for every professor teaching it
+// The course catalog has a row for every professor of a course so ID is the row that was asked for
+// and SectionIDs are the rows of all of the professors
+type CourseListings struct {
+	Course
+	ListingStats
+	Professors []string `json:"professors"`
+	SectionIDs []int    `json:"section_ids"`
+	Books      []Book   `json:"books"`
+	Page       Page     `json:"page"`
+}
+
+// DepartmentListings is a department with the listings for every course in it
+type DepartmentListings struct {
+	ListingStats
+	Department string           `json:"department"`
+	Courses    []CourseListings `json:"courses"`
+	Books      []Book           `json:"books"`
+	Page       Page             `json:"page"`
+}
+
+// CourseTemplateType is the type for the course template
+type CourseTemplateType struct {
+	UserTemplateType
+	CourseListings
+}
+
+// DepartmentTemplateType is the type for the department template
+type DepartmentTemplateType struct {
+	UserTemplateType
+	DepartmentListings
+}
+
+// errCourseNotFound is returned when a course or department page is for a course that is not in the catalog
+var errCourseNotFound = errors.New("Course does not exist")
+
+// groupSections groups course catalog rows by department and course number, keeping the rows in order
+// and collecting the professors of each course
+func groupSections(sections []Course) []CourseListings {
+	var courses []CourseListings
+	index := map[string]int{}
+	for _, section := range sections {
+		key := section.Department + "\x00" + section.CourseID
+		i, ok := index[key]
+		if !ok {
+			i = len(courses)
+			index[key] = i
+			courses = append(courses, CourseListings{Course: section, Professors: []string{}})
+		}
+		courses[i].SectionIDs = append(courses[i].SectionIDs, section.ID)
+		if section.Professor != "" {
+			courses[i].Professors = append(courses[i].Professors, section.Professor)
+		}
+	}
+	return courses
+}
+
+// countListings returns the listing stats of a book query for every course ID
+func countListings(db *gorm.DB, courseIDs []int) (map[int]ListingStats, error) {
+	stats := map[int]ListingStats{}
+	if len(courseIDs) == 0 {
+		return stats, nil
+	}
+
+	rows, err := db.Model(&Book{}).Where("course_id IN (?)", courseIDs).
+		Select("course_id, COUNT(*), MIN(price)").Group("course_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var courseID, count int
+		var lowestPrice sql.NullFloat64
+		if err := rows.Scan(&courseID, &count, &lowestPrice); err != nil {
+			return nil, err
+		}
+		stats[courseID] = ListingStats{ListingCount: count, LowestPrice: lowestPrice.Float64}
+	}
+	return stats, rows.Err()
+}
+
+// addListingStats sets the listing stats of every course from the listing stats of its sections
+func addListingStats(courses []CourseListings, stats map[int]ListingStats) {
+	for i := range courses {
+		for _, id := range courses[i].SectionIDs {
+			courses[i].ListingStats.add(stats[id])
+		}
+	}
+}
+
+// findCourseListings looks up a course from the catalog with the listings from a book query and a page of the listings
+func findCourseListings(db *gorm.DB, courseDB gorm.DB, courseID int, request pageRequest) (CourseListings, error) {
+	var course Course
+	if result := courseDB.First(&course, courseID); result.Error != nil {
+		if result.RecordNotFound() {
+			return CourseListings{}, errCourseNotFound
+		}
+		return CourseListings{}, result.Error
+	}
+
+	var sections []Course
+	result := courseDB.Where("department = ? AND course_id = ?", course.Department, course.CourseID).
+		Order("id").Find(&sections)
+	if result.Error != nil {
+		return CourseListings{}, result.Error
+	}
+	courseListings := groupSections(sections)[0]
+	courseListings.Course = course
+
+	stats, err := countListings(db, courseListings.SectionIDs)
+	if err != nil {
+		return courseListings, err
+	}
+	for _, id := range courseListings.SectionIDs {
+		courseListings.ListingStats.add(stats[id])
+	}
+
+	courseListings.Books = []Book{}
+	books := db.Where("course_id IN (?)", courseListings.SectionIDs)
+	courseListings.Page, err = paginate(books, request, newestFirst, &courseListings.Books, func(i int) pageCursor {
+		return bookCursor(courseListings.Books[i], nil)
+	})
+	return courseListings, err
+}
+
+// findDepartmentListings looks up the courses of a department from the catalog with the listings
+// from a book query and a page of the listings
+func findDepartmentListings(db *gorm.DB, courseDB gorm.DB, department string, request pageRequest) (DepartmentListings, error) {
+	departmentListings := DepartmentListings{Department: department, Books: []Book{}}
+
+	var sections []Course
+	if result := courseDB.Where("department = ?", department).Order("id").Find(&sections); result.Error != nil {
+		return departmentListings, result.Error
+	}
+	if len(sections) == 0 {
+		return departmentListings, errCourseNotFound
+	}
+
+	departmentListings.Courses = groupSections(sections)
+	sectionIDs := make([]int, len(sections))
+	for i, section := range sections {
+		sectionIDs[i] = section.ID
+	}
+	stats, err := countListings(db, sectionIDs)
+	if err != nil {
+		return departmentListings, err
+	}
+	addListingStats(departmentListings.Courses, stats)
+	for _, course := range departmentListings.Courses {
+		departmentListings.ListingStats.add(course.ListingStats)
+	}
+	sort.Sort(coursesByNumber(departmentListings.Courses))
+
+	books := db.Where("course_id IN (?)", sectionIDs)
+	departmentListings.Page, err = paginate(books, request, newestFirst, &departmentListings.Books, func(i int) pageCursor {
+		return bookCursor(departmentListings.Books[i], nil)
+	})
+	return departmentListings, err
+}
+
+// coursesByNumber sorts courses by their course number, with numbers compared by value so that 31 comes before 131
+type coursesByNumber []CourseListings
+
+func (c coursesByNumber) Len() int      { return len(c) }
+func (c coursesByNumber) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c coursesByNumber) Less(i, j int) bool {
+	a, b := courseNumber(c[i].CourseID), courseNumber(c[j].CourseID)
+	if a != b {
+		return a < b
+	}
+	return c[i].CourseID < c[j].CourseID
+}
+
+// courseNumber returns the number in a course ID like "M104C", ignoring letters before and after it
+func courseNumber(courseID string) int {
+	start := 0
+	for start < len(courseID) && (courseID[start] < '0' || courseID[start] > '9') {
+		start++
+	}
+	end := start
+	for end < len(courseID) && courseID[end] >= '0' && courseID[end] <= '9' {
+		end++
+	}
+	number, _ := strconv.Atoi(courseID[start:end])
+	return number
+}
+
+// courseListingsRequest loads the course listings for a course page request
+func courseListingsRequest(r *http.Request, db gorm.DB, courseDB gorm.DB, courseID string) (CourseListings, int, error) {
+	id, err := strconv.Atoi(courseID)
+	if err != nil {
+		return CourseListings{}, http.StatusNotFound, errCourseNotFound
+	}
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		return CourseListings{}, http.StatusBadRequest, err
+	}
+
+	courseListings, err := findCourseListings(statusQuery(r, db), courseDB, id, pageRequest)
+	if err == errCourseNotFound {
+		return courseListings, http.StatusNotFound, err
+	} else if err != nil {
+		return courseListings, http.StatusInternalServerError, err
+	}
+	courseListings.Page.setLinks(r.URL)
+	return courseListings, http.StatusOK, nil
+}
+
+// departmentListingsRequest loads the department listings for a department page request
+func departmentListingsRequest(r *http.Request, db gorm.DB, courseDB gorm.DB, department string) (DepartmentListings, int, error) {
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		return DepartmentListings{}, http.StatusBadRequest, err
+	}
+
+	departmentListings, err := findDepartmentListings(statusQuery(r, db), courseDB, department, pageRequest)
+	if err == errCourseNotFound {
+		return departmentListings, http.StatusNotFound, errors.New("Department does not exist")
+	} else if err != nil {
+		return departmentListings, http.StatusInternalServerError, err
+	}
+	departmentListings.Page.setLinks(r.URL)
+	return departmentListings, http.StatusOK, nil
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/jinzhu/gorm"
+)
+
+// departmentStopWords are left out of the initials and words of a department name, so that
+// "Chemistry And Biochemistry" has the initials "cb"
+var departmentStopWords = map[string]bool{"and": true, "of": true, "in": true, "the": true, "for": true}
+
+// CourseQuery is a free text course search like "cs31 smallberg" split into the departments that it names,
+// a course number and the words of a professor name. Every part is optional
+type CourseQuery struct {
+	Departments []string `json:"departments"`
+	CourseID    string   `json:"course_id"` // uppercase, like "M51A"
+	Professor   []string `json:"professor"`
+}
+
+// Empty returns true if the query has no part to search for
+func (q CourseQuery) Empty() bool {
+	return len(q.Departments) == 0 && q.CourseID == "" && len(q.Professor) == 0
+}
+
+// normalizeAlias keeps only the lowercase letters and digits of a department name, so "COM SCI" is "comsci"
+func normalizeAlias(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// courseQueryWords splits text into lowercase words of letters and digits
+func courseQueryWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// departmentWords returns the lowercase words of a department name without stop words
+func departmentWords(department string) []string {
+	var words []string
+	for _, word := range courseQueryWords(department) {
+		if !departmentStopWords[word] {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// departmentInitials returns the initials of a department with more than one word, or an empty string
+func departmentInitials(department string) string {
+	words := departmentWords(department)
+	if len(words) < 2 {
+		return ""
+	}
+	initials := ""
+	for _, word := range words {
+		initials += word[:1]
+	}
+	return initials
+}
+
+// wordsStartDepartment returns true if every word starts the word of the department at the same position,
+// like "com sci" for "Computer Science". A single word needs at least three letters so that it is not an initial
+func wordsStartDepartment(words []string, department []string) bool {
+	if len(words) == 0 || len(words) > len(department) || (len(words) == 1 && len(words[0]) < 3) {
+		return false
+	}
+	for i, word := range words {
+		if !strings.HasPrefix(department[i], word) {
+			return false
+		}
+	}
+	return true
+}
+
+// departmentResolver resolves the department words of a course query to the departments of the catalog
+type departmentResolver struct {
+	aliases     map[string][]string // departments by normalized alias
+	departments []string
+}
+
+// departmentResolverTTL is how long the departments and aliases of a campus are kept before they are loaded
+// again, which is how long catalog changes made by another process like import-aliases take to show up
+const departmentResolverTTL = 5 * time.Minute
+
+// departmentResolverKey is a campus of one course database
+type departmentResolverKey struct {
+	courseDB      *sql.DB
+	institutionID int
+}
+
+// cachedDepartmentResolver is a department resolver and when it has to be loaded again
+type cachedDepartmentResolver struct {
+	resolver departmentResolver
+	expires  time.Time
+}
+
+// departmentResolverCache keeps the department resolvers of every campus so that every course query and
+// book search doesn't load the departments and aliases again
+type departmentResolverCache struct {
+	mutex     sync.Mutex
+	resolvers map[departmentResolverKey]cachedDepartmentResolver
+}
+
+// get returns the resolver of a campus if it was loaded recently
+func (c *departmentResolverCache) get(key departmentResolverKey, now time.Time) (departmentResolver, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	cached, ok := c.resolvers[key]
+	if ok && now.After(cached.expires) {
+		delete(c.resolvers, key)
+		return departmentResolver{}, false
+	}
+	return cached.resolver, ok
+}
+
+// add keeps the resolver of a campus
+func (c *departmentResolverCache) add(key departmentResolverKey, resolver departmentResolver, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.resolvers[key] = cachedDepartmentResolver{resolver: resolver, expires: now.Add(departmentResolverTTL)}
+}
+
+// clear forgets every resolver so that they are loaded again
+func (c *departmentResolverCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.resolvers = make(map[departmentResolverKey]cachedDepartmentResolver)
+}
+
+// departmentResolvers is the cache of the department resolvers of every campus
+var departmentResolvers = &departmentResolverCache{resolvers: make(map[departmentResolverKey]cachedDepartmentResolver)}
+
+// invalidateDepartmentResolvers has the departments and aliases loaded again after the catalog has changed
+func invalidateDepartmentResolvers() {
+	departmentResolvers.clear()
+}
+
+// newDepartmentResolver returns the departments and department aliases of a campus, or of every campus
+// if institutionID is 0, loading them from the course database unless they were loaded recently
+func newDepartmentResolver(courseDB gorm.DB, institutionID int) (departmentResolver, error) {
+	key := departmentResolverKey{courseDB: courseDB.DB(), institutionID: institutionID}
+	now := time.Now()
+	if resolver, ok := departmentResolvers.get(key, now); ok {
+		return resolver, nil
+	}
+	resolver, err := loadDepartmentResolver(courseDB, institutionID)
+	if err != nil {
+		return resolver, err
+	}
+	departmentResolvers.add(key, resolver, now)
+	return resolver, nil
+}
+
+// loadDepartmentResolver loads the departments and department aliases of a campus, or of every campus if institutionID is 0
+func loadDepartmentResolver(courseDB gorm.DB, institutionID int) (departmentResolver, error) {
+	resolver := departmentResolver{aliases: map[string][]string{}}
+	result := filterActive(filterInstitution(courseDB.Model(&Course{}), institutionID)).Order("department").
+		Pluck("DISTINCT department", &resolver.departments)
+	if result.Error != nil {
+		return resolver, result.Error
+	}
+
+	var aliases []DepartmentAlias
+	if result := filterInstitution(&courseDB, institutionID).Find(&aliases); result.Error != nil {
+		return resolver, result.Error
+	}
+	for _, alias := range aliases {
+		resolver.aliases[alias.Alias] = append(resolver.aliases[alias.Alias], alias.Department)
+	}
+	return resolver, nil
+}
+
+// resolve returns the departments that words name. Those are the departments of a matching alias,
+// otherwise the department with that name, otherwise every department whose initials are the word,
+// like "cs" for "Computer Science", or whose words start with the words, like "com sci"
+func (d departmentResolver) resolve(words []string) []string {
+	key := normalizeAlias(strings.Join(words, ""))
+	if key == "" {
+		return nil
+	}
+	if departments, ok := d.aliases[key]; ok {
+		return departments
+	}
+	for _, department := range d.departments {
+		if normalizeAlias(department) == key {
+			return []string{department}
+		}
+	}
+
+	var matches []string
+	for _, department := range d.departments {
+		if (len(words) == 1 && departmentInitials(department) == key) ||
+			wordsStartDepartment(words, departmentWords(department)) {
+			matches = append(matches, department)
+		}
+	}
+	return matches
+}
+
+// parse splits a course query into the department words before the first word with a digit, which
+// is the course number, and the professor words after it. A department and a course number can be
+// written together like "cs31". Without a course number the longest run of leading words that names a
+// department is the department and the rest of the words are the professor
+func (d departmentResolver) parse(text string) CourseQuery {
+	words := courseQueryWords(text)
+	for i, word := range words {
+		digit := strings.IndexAny(word, "0123456789")
+		if digit < 0 {
+			continue
+		}
+
+		if digit > 0 {
+			prefix := append(append([]string{}, words[:i]...), word[:digit])
+			if departments := d.resolve(prefix); len(departments) > 0 {
+				return CourseQuery{Departments: departments, CourseID: strings.ToUpper(word[digit:]), Professor: words[i+1:]}
+			}
+		}
+		query := CourseQuery{CourseID: strings.ToUpper(word), Professor: words[i+1:]}
+		if i > 0 {
+			if query.Departments = d.resolve(words[:i]); len(query.Departments) == 0 {
+				query.Professor = append(append([]string{}, words[:i]...), query.Professor...)
+			}
+		}
+		return query
+	}
+
+	for n := len(words); n > 0; n-- {
+		if departments := d.resolve(words[:n]); len(departments) > 0 {
+			return CourseQuery{Departments: departments, Professor: words[n:]}
+		}
+	}
+	return CourseQuery{Professor: words}
+}
+
+// ParseCourseQuery splits a free text course search like "cs31 smallberg" into its parts, resolving
+// department aliases and abbreviations with the departments of a campus, or of every campus if institutionID is 0
+func ParseCourseQuery(courseDB gorm.DB, text string, institutionID int) (CourseQuery, error) {
+	resolver, err := newDepartmentResolver(courseDB, institutionID)
+	if err != nil {
+		return CourseQuery{}, err
+	}
+	return resolver.parse(text), nil
+}
+
+// crossListings returns the department and course number pairs of a course query together with every
+// pair that is cross listed with one of them
+func crossListings(courseDB gorm.DB, departments []string, courseID string, institutionID int) ([]CrossListing, error) {
+	listings := make([]CrossListing, len(departments))
+	for i, department := range departments {
+		listings[i] = CrossListing{Department: department, CourseID: courseID}
+	}
+
+	var groupIDs []int
+	result := filterInstitution(courseDB.Model(&CrossListing{}), institutionID).
+		Where("department IN (?) AND UPPER(course_id) = ?", departments, courseID).Pluck("DISTINCT group_id", &groupIDs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(groupIDs) == 0 {
+		return listings, nil
+	}
+
+	var grouped []CrossListing
+	if result := filterInstitution(&courseDB, institutionID).Where("group_id IN (?)", groupIDs).Find(&grouped); result.Error != nil {
+		return nil, result.Error
+	}
+	return append(listings, grouped...), nil
+}
+
+// findQueryCourses returns the courses that are not retired and match a course query, including the courses that are cross listed
+// with its department and course number, from the courses of a term and a campus (0 for every term or campus)
+// limit is the most courses to return, or 0 to return every course
+func findQueryCourses(courseDB gorm.DB, query CourseQuery, termID int, institutionID int, limit int) ([]Course, error) {
+	courses := []Course{}
+	if query.Empty() {
+		return courses, nil
+	}
+
+	db := filterActive(filterInstitution(filterTerm(&courseDB, termID), institutionID))
+	switch {
+	case len(query.Departments) > 0 && query.CourseID != "":
+		listings, err := crossListings(courseDB, query.Departments, query.CourseID, institutionID)
+		if err != nil {
+			return courses, err
+		}
+		clauses := make([]string, len(listings))
+		var args []interface{}
+		for i, listing := range listings {
+			clauses[i] = "(department = ? AND UPPER(course_id) = ?)"
+			args = append(args, listing.Department, strings.ToUpper(listing.CourseID))
+		}
+		db = db.Where(strings.Join(clauses, " OR "), args...)
+	case len(query.Departments) > 0:
+		db = db.Where("department IN (?)", query.Departments)
+	case query.CourseID != "":
+		db = db.Where("UPPER(course_id) = ?", query.CourseID)
+	}
+	db = filterProfessorWords(db, query.Professor).Order("department, course_id, professor")
+	if limit > 0 {
+		db = db.Limit(limit)
+	}
+	result := db.Find(&courses)
+	return courses, result.Error
+}
+
+// SearchCourseQuery returns up to 10 courses that match a free text course search like "cs31 smallberg"
+// Only courses of the term with termID and courses without a term match, unless termID is 0, and the same goes for institutionID
+func SearchCourseQuery(text string, termID int, institutionID int, db gorm.DB) ([]Course, error) {
+	query, err := ParseCourseQuery(db, text, institutionID)
+	if err != nil {
+		return []Course{}, err
+	}
+	return findQueryCourses(db, query, termID, institutionID, 10)
+}
+
+// searchCourseIDs returns the courses that a book search query names when it is a course search with a
+// department and a course number like "cs 31", or no courses when it is not a course search
+func searchCourseIDs(courseDB gorm.DB, search BookSearch) ([]Course, []int, error) {
+	query, err := ParseCourseQuery(courseDB, search.Query, search.InstitutionID)
+	if err != nil || len(query.Departments) == 0 || query.CourseID == "" {
+		return nil, nil, err
+	}
+	courses, err := findQueryCourses(courseDB, query, 0, search.InstitutionID, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]int, len(courses))
+	for i, course := range courses {
+		ids[i] = course.ID
+	}
+	return courses, ids, nil
+}
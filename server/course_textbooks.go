@@ -0,0 +1,137 @@
+package server
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TextbookListings is a textbook of a course with the number of listings for its ISBN and the cheapest one
+type TextbookListings struct {
+	CourseTextbook
+	ListingStats
+	Cheapest *Book `json:"cheapest"` // nil when there are no listings
+}
+
+// CourseTextbooks is a course with its textbooks, required textbooks first
+type CourseTextbooks struct {
+	Course    Course             `json:"course"`
+	Textbooks []TextbookListings `json:"textbooks"`
+}
+
+// CourseTextbooksTemplateType is the type for the course textbooks template
+type CourseTextbooksTemplateType struct {
+	UserTemplateType
+	CourseTextbooks
+}
+
+// textbooksByRequired sorts textbooks with the required textbooks first and then by title
+type textbooksByRequired []TextbookListings
+
+func (t textbooksByRequired) Len() int      { return len(t) }
+func (t textbooksByRequired) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t textbooksByRequired) Less(i, j int) bool {
+	if t[i].Required != t[j].Required {
+		return t[i].Required
+	}
+	return t[i].Title < t[j].Title
+}
+
+// countISBNListings returns the listing stats of a book query for every ISBN
+func countISBNListings(db *gorm.DB, isbns []string) (map[string]ListingStats, error) {
+	stats := map[string]ListingStats{}
+	if len(isbns) == 0 {
+		return stats, nil
+	}
+
+	rows, err := db.Model(&Book{}).Where("i_s_b_n IN (?)", isbns).
+		Select("i_s_b_n, COUNT(*), MIN(price)").Group("i_s_b_n").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var isbn string
+		var count int
+		var lowestPrice sql.NullFloat64
+		if err := rows.Scan(&isbn, &count, &lowestPrice); err != nil {
+			return nil, err
+		}
+		stats[isbn] = ListingStats{ListingCount: count, LowestPrice: lowestPrice.Float64}
+	}
+	return stats, rows.Err()
+}
+
+// findCourseTextbooks looks up the textbooks of a course from the catalog with the listings for
+// each of them from a book query. Listings count for a textbook whatever course they were listed for
+func findCourseTextbooks(db *gorm.DB, courseDB gorm.DB, courseID int) (CourseTextbooks, error) {
+	courseTextbooks := CourseTextbooks{Textbooks: []TextbookListings{}}
+	if result := courseDB.First(&courseTextbooks.Course, courseID); result.Error != nil {
+		if result.RecordNotFound() {
+			return courseTextbooks, errCourseNotFound
+		}
+		return courseTextbooks, result.Error
+	}
+
+	var textbooks []CourseTextbook
+	if result := courseDB.Where("course_id = ?", courseID).Find(&textbooks); result.Error != nil {
+		return courseTextbooks, result.Error
+	}
+	isbns := make([]string, len(textbooks))
+	for i, textbook := range textbooks {
+		isbns[i] = textbook.ISBN
+	}
+	stats, err := countISBNListings(db, isbns)
+	if err != nil {
+		return courseTextbooks, err
+	}
+	var metadata []BookMetadata
+	if len(isbns) > 0 {
+		if result := db.New().Where("i_s_b_n IN (?)", isbns).Find(&metadata); result.Error != nil {
+			return courseTextbooks, result.Error
+		}
+	}
+	metadataByISBN := make(map[string]BookMetadata, len(metadata))
+	for _, m := range metadata {
+		metadataByISBN[m.ISBN] = m
+	}
+
+	for _, textbook := range textbooks {
+		listings := TextbookListings{CourseTextbook: textbook, ListingStats: stats[textbook.ISBN]}
+		if listings.ListingCount > 0 {
+			var cheapest Book
+			if result := db.Where("i_s_b_n = ?", textbook.ISBN).Order("price asc, created_at asc").
+				First(&cheapest); result.Error != nil {
+				return courseTextbooks, result.Error
+			}
+			cheapest.Metadata = metadataByISBN[textbook.ISBN]
+			listings.Cheapest = &cheapest
+		}
+		if listings.Title == "" {
+			listings.Title = metadataByISBN[textbook.ISBN].Title
+		}
+		courseTextbooks.Textbooks = append(courseTextbooks.Textbooks, listings)
+	}
+	sort.Sort(textbooksByRequired(courseTextbooks.Textbooks))
+	return courseTextbooks, nil
+}
+
+// courseTextbooksRequest loads the textbooks of the course for a course textbooks page request
+func courseTextbooksRequest(r *http.Request, db gorm.DB, courseDB gorm.DB, courseID string) (CourseTextbooks, int, error) {
+	id, err := strconv.Atoi(courseID)
+	if err != nil {
+		return CourseTextbooks{}, http.StatusNotFound, errCourseNotFound
+	}
+
+	courseTextbooks, err := findCourseTextbooks(statusQuery(r, db), courseDB, id)
+	if err == errCourseNotFound {
+		return courseTextbooks, http.StatusNotFound, err
+	} else if err != nil {
+		return courseTextbooks, http.StatusInternalServerError, err
+	}
+	return courseTextbooks, http.StatusOK, nil
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Mailer is an interface for sending emails to users
+type Mailer interface {
+	Send(to string, subject string, body string) error // sends a plain text email to an address
+}
+
+// LogMailer is a Mailer that writes emails to a log instead of sending them, which is enough for development
+type LogMailer struct {
+	logger *log.Logger
+}
+
+// NewLogMailer constructs a new LogMailer that writes emails to w
+func NewLogMailer(w io.Writer) LogMailer {
+	return LogMailer{logger: log.New(w, "", log.LstdFlags)}
+}
+
+// Send writes an email to the log
+func (m LogMailer) Send(to string, subject string, body string) error {
+	m.logger.Printf("Email to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// SMTPMailer is a Mailer that sends emails through an SMTP server
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer constructs a new SMTPMailer that sends emails from an address through the SMTP server at addr
+// (host:port), logging in with a username and password unless the username is empty
+func NewSMTPMailer(addr string, from string, username string, password string) SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, strings.Split(addr, ":")[0])
+	}
+	return SMTPMailer{addr: addr, from: from, auth: auth}
+}
+
+// Send sends an email through the SMTP server
+func (m SMTPMailer) Send(to string, subject string, body string) error {
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n", m.from, to, subject, body)
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(message))
+}
+
+var mailer Mailer = NewLogMailer(os.Stderr)
+
+// SetMailer changes how emails are sent
+func SetMailer(m Mailer) {
+	mailer = m
+}
+
+// newVerificationToken returns a random token for the link in an email verification
+func newVerificationToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// verificationURL returns the link that verifies an email with a token on the host of a request
+func verificationURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/users/verify?token=%s", scheme, r.Host, token)
+}
+
+// sendVerificationEmail gives a user a new verification token and emails them the link to verify their email
+// Only the latest link that was sent to a user works
+func sendVerificationEmail(r *http.Request, db gorm.DB, user User) error {
+	token, err := newVerificationToken()
+	if err != nil {
+		return err
+	}
+	if result := db.Model(&user).UpdateColumn("verification_token", token); result.Error != nil {
+		return result.Error
+	}
+	return mailer.Send(user.Email, "Verify your Bookcycle email",
+		"Open this link to verify your email and see the listings of your campus:\n"+verificationURL(r, token))
+}
+
+// VerifyEmailHandler is a route for /users/verify that verifies the email of the user who was sent a token
+// and puts them on the campus of their email domain
+// GET parameters:
+// token string
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	var user User
+	if result := db.Where("verification_token = ?", token).First(&user); result.Error != nil {
+		http.Error(w, "That verification link is not valid anymore", http.StatusNotFound)
+		return
+	}
+
+	institution, err := InstitutionForEmail(courseDB, user.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := db.Model(&user).UpdateColumns(map[string]interface{}{
+		"email_verified":     true,
+		"verification_token": "",
+		"institution_id":     institution.ID,
+	})
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// a user who is logged in sees their campus right away
+	if currentUser, err := CurrentUser(r); err == nil && currentUser.ID == user.ID {
+		if result := db.First(&user, user.ID); result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := SetUserInSession(r, w, user); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// ResendVerificationHandler is a route for /users/verify that sends the logged in user a new link to verify their email
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to verify your email", http.StatusUnauthorized)
+		return
+	}
+	var user User
+	if result := db.First(&user, currentUser.ID); result.Error != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, "Your email is already verified", http.StatusBadRequest)
+		return
+	}
+
+	if err := sendVerificationEmail(r, db, user); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/users/edit", http.StatusFound)
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// fuzzyThreshold is the lowest trigram similarity for a word to be used in place of a misspelled search term
+	fuzzyThreshold = 0.4
+	// minFuzzyTermLength is the length of the shortest search term that is corrected, since shorter
+	// terms have too few trigrams to compare
+	minFuzzyTermLength = 3
+	// maxTrigramRows is the number of rows inserted into the trigram table at once,
+	// which keeps the number of query parameters under the SQLite limit
+	maxTrigramRows = 300
+)
+
+// The trigram table is plain SQL with the similarity computed in Go instead of with pg_trgm so that
+// fuzzy matching finds the same books on SQLite and on postgres. Every word of an indexed book is
+// stored once for each of its trigrams, so the words that share trigrams with a misspelled term
+// can be found with an index lookup
+const createSearchTrigrams = `CREATE TABLE IF NOT EXISTS search_trigrams (
+	book_id integer NOT NULL,
+	word varchar(255) NOT NULL,
+	trigram varchar(32) NOT NULL
+)`
+
+// migrateSearchTrigrams creates the trigram table used to correct misspelled search terms
+func migrateSearchTrigrams(db gorm.DB) error {
+	statements := []string{
+		createSearchTrigrams,
+		"CREATE INDEX IF NOT EXISTS search_trigrams_trigram ON search_trigrams (trigram)",
+		"CREATE INDEX IF NOT EXISTS search_trigrams_book_id ON search_trigrams (book_id)",
+	}
+	for _, statement := range statements {
+		if result := db.Exec(statement); result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
+
+// trigrams returns the set of trigrams of a word, padded with two spaces in front and one behind
+// like pg_trgm so that the start of a word counts for more than its end
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	set := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		set[string(runes[i:i+3])] = true
+	}
+	return set
+}
+
+// trigramSimilarity returns the number of trigrams two words share divided by the number of
+// distinct trigrams in both, from 0 for words with nothing in common to 1 for the same word
+func trigramSimilarity(a, b string) float64 {
+	aTrigrams, bTrigrams := trigrams(a), trigrams(b)
+	shared := 0
+	for trigram := range aTrigrams {
+		if bTrigrams[trigram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(aTrigrams)+len(bTrigrams)-shared)
+}
+
+// indexSearchTrigrams adds the trigrams of every word in a search document except the ISBNs to the trigram table
+func indexSearchTrigrams(db gorm.DB, bookID int, doc searchDocument) error {
+	text := strings.Join([]string{doc.Title, doc.Authors, doc.Course, doc.Details}, " ")
+	words := map[string]bool{}
+	for _, word := range searchTerms(text) {
+		if utf8.RuneCountInString(word) >= minFuzzyTermLength && len(word) <= 255 {
+			words[word] = true
+		}
+	}
+
+	var values []interface{}
+	flush := func() error {
+		if len(values) == 0 {
+			return nil
+		}
+		rows := strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(values)/3), ", ")
+		result := db.Exec("INSERT INTO search_trigrams (book_id, word, trigram) VALUES "+rows, values...)
+		values = nil
+		return result.Error
+	}
+	for word := range words {
+		for trigram := range trigrams(word) {
+			values = append(values, bookID, word, trigram)
+			if len(values) == 3*maxTrigramRows {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+// removeSearchTrigrams removes the trigrams of a book from the trigram table
+func removeSearchTrigrams(db gorm.DB, bookID int) error {
+	return db.Exec("DELETE FROM search_trigrams WHERE book_id = ?", bookID).Error
+}
+
+// hasWordWithPrefix returns true if an indexed word starts with a search term, which the search index already matches
+func hasWordWithPrefix(db gorm.DB, term string) (bool, error) {
+	var count int
+	row := db.Raw("SELECT COUNT(*) FROM search_trigrams WHERE word LIKE ?", term+"%").Row()
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// similarWord returns the indexed word that is most similar to a search term, or an empty string
+// if no word is at least as similar as fuzzyThreshold. Ties go to the word that sorts first
+func similarWord(db gorm.DB, term string) (string, error) {
+	termTrigrams := trigrams(term)
+	list := make([]string, 0, len(termTrigrams))
+	for trigram := range termTrigrams {
+		list = append(list, trigram)
+	}
+
+	// a word can only reach the threshold if it shares at least that fraction of the term's trigrams
+	minShared := int(math.Ceil(fuzzyThreshold * float64(len(list))))
+	rows, err := db.Raw(`SELECT word FROM (SELECT DISTINCT word, trigram FROM search_trigrams WHERE trigram IN (?)) AS shared
+		GROUP BY word HAVING COUNT(*) >= ?`, list, minShared).Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var candidates []string
+	for rows.Next() {
+		var word string
+		if err := rows.Scan(&word); err != nil {
+			return "", err
+		}
+		candidates = append(candidates, word)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	sort.Strings(candidates)
+
+	best, bestSimilarity := "", fuzzyThreshold
+	for _, word := range candidates {
+		if similarity := trigramSimilarity(term, word); similarity > bestSimilarity ||
+			(best == "" && similarity == bestSimilarity) {
+			best, bestSimilarity = word, similarity
+		}
+	}
+	return best, nil
+}
+
+// correctSearchQuery replaces every term of a search query that does not start any indexed word with
+// the most similar indexed word. It returns false if nothing was corrected or a term has no similar word
+func correctSearchQuery(db gorm.DB, query string) (string, bool, error) {
+	if _, err := NormalizeISBN(query); err == nil {
+		return "", false, nil
+	}
+
+	terms := searchTerms(query)
+	corrected := false
+	for i, term := range terms {
+		if utf8.RuneCountInString(term) < minFuzzyTermLength {
+			continue
+		}
+		found, err := hasWordWithPrefix(db, term)
+		if err != nil {
+			return "", false, err
+		}
+		if found {
+			continue
+		}
+
+		word, err := similarWord(db, term)
+		if err != nil || word == "" {
+			return "", false, err
+		}
+		terms[i] = word
+		corrected = true
+	}
+	return strings.Join(terms, " "), corrected, nil
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// The course database started out as the UCLA catalog, so its courses belong to UCLA
+// when the first campus is created
+const (
+	DefaultInstitutionName   = "UCLA"
+	DefaultInstitutionDomain = "ucla.edu"
+)
+
+// AllCampuses is the campus parameter that shows the books and courses of every campus
+const AllCampuses = "all"
+
+// CampusScope is the campus that books and courses are limited to for a request
+// It is an empty institution if the user is not logged in or their email is not from a campus
+type CampusScope struct {
+	Institution Institution
+	AllCampuses bool   // set when the user asked for every campus
+	SwitchURL   string // the same page with the other scope
+}
+
+// InstitutionID returns the ID of the campus to filter by, or 0 to not filter by campus
+func (c CampusScope) InstitutionID() int {
+	if c.AllCampuses {
+		return 0
+	}
+	return c.Institution.ID
+}
+
+// newCampusScope returns the campus scope of a request from the logged in user and the campus parameter
+func newCampusScope(r *http.Request, courseDB gorm.DB) CampusScope {
+	scope := CampusScope{AllCampuses: r.URL.Query().Get("campus") == AllCampuses}
+	currentUser, err := CurrentUser(r)
+	if err != nil || currentUser.InstitutionID == 0 {
+		return scope
+	}
+	courseDB.First(&scope.Institution, currentUser.InstitutionID)
+
+	values := r.URL.Query()
+	values.Del("cursor")
+	values.Del("csrf_token")
+	if scope.AllCampuses {
+		values.Del("campus")
+	} else {
+		values.Set("campus", AllCampuses)
+	}
+	scope.SwitchURL = r.URL.Path + "?" + values.Encode()
+	return scope
+}
+
+// filterInstitution limits a query to the rows of a campus and the rows that are shared by every campus
+func filterInstitution(db *gorm.DB, institutionID int) *gorm.DB {
+	if institutionID == 0 {
+		return db
+	}
+	return db.Where("institution_id = ? OR institution_id = 0", institutionID)
+}
+
+// emailDomain returns the lowercase domain of an email address
+func emailDomain(email string) string {
+	return strings.ToLower(strings.TrimSpace(email[strings.LastIndex(email, "@")+1:]))
+}
+
+// InstitutionForEmail returns the campus of an email address, which is the campus with the longest
+// email domain that is the domain of the address or one of its parents. It is an empty institution
+// if the address is not from a campus
+func InstitutionForEmail(courseDB gorm.DB, email string) (Institution, error) {
+	var institutions []Institution
+	if result := courseDB.Find(&institutions); result.Error != nil {
+		return Institution{}, result.Error
+	}
+	return matchInstitution(institutions, email), nil
+}
+
+// matchInstitution returns the campus of an email address from a list of campuses
+func matchInstitution(institutions []Institution, email string) Institution {
+	domain := emailDomain(email)
+	var match Institution
+	for _, institution := range institutions {
+		institutionDomain := strings.ToLower(institution.EmailDomain)
+		if (domain == institutionDomain || strings.HasSuffix(domain, "."+institutionDomain)) &&
+			len(institutionDomain) > len(match.EmailDomain) {
+			match = institution
+		}
+	}
+	return match
+}
+
+// InstitutionByDomain looks up a campus by email domain, or returns a new campus with the name that
+// still has to be saved if it does not exist
+func InstitutionByDomain(courseDB gorm.DB, domain, name string) (Institution, error) {
+	domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+	var institution Institution
+	result := courseDB.Where("email_domain = ?", domain).First(&institution)
+	if result.Error == nil {
+		return institution, nil
+	}
+	if !result.RecordNotFound() {
+		return institution, result.Error
+	}
+
+	if strings.TrimSpace(name) == "" {
+		return institution, errors.New("There is no campus for " + domain + ", give its name to create it")
+	}
+	return Institution{Name: strings.TrimSpace(name), EmailDomain: domain}, nil
+}
+
+// courseInstitution returns the campus of a course from the catalog, or 0 if the course is shared or does not exist
+func courseInstitution(courseDB gorm.DB, courseID int) int {
+	var course Course
+	if result := courseDB.First(&course, courseID); result.Error != nil {
+		return 0
+	}
+	return course.InstitutionID
+}
+
+// MigrateCourses sets the term, campus and retired columns of courses that were saved before courses had them,
+// since AutoMigrate adds the columns as NULL, and creates the default campus for a course database without campuses
+func MigrateCourses(courseDB gorm.DB) error {
+	for _, column := range []string{"term_id", "institution_id", "retired", "merged_into_id"} {
+		if result := courseDB.Exec("UPDATE courses SET " + column + " = 0 WHERE " + column + " IS NULL"); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	var count int
+	if result := courseDB.Model(&Institution{}).Count(&count); result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return nil
+	}
+
+	institution := Institution{Name: DefaultInstitutionName, EmailDomain: DefaultInstitutionDomain}
+	if result := courseDB.Create(&institution); result.Error != nil {
+		return result.Error
+	}
+	return courseDB.Model(&Course{}).Where("institution_id = 0").UpdateColumn("institution_id", institution.ID).Error
+}
+
+// MigrateInstitutions assigns the books and users of the main database that do not have a campus yet
+// to one, so that books and users are moved to campuses that were added since the last start
+// Users are only put on a campus once their email is verified
+func MigrateInstitutions(db gorm.DB, courseDB gorm.DB) error {
+	for _, table := range []string{"books", "users"} {
+		if result := db.Exec("UPDATE " + table + " SET institution_id = 0 WHERE institution_id IS NULL"); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	var courseIDs []int
+	if result := db.Model(&Book{}).Unscoped().Where("institution_id = 0").Pluck("DISTINCT course_id", &courseIDs); result.Error != nil {
+		return result.Error
+	}
+	if len(courseIDs) > 0 {
+		var courses []Course
+		if result := courseDB.Where("id IN (?) AND institution_id <> 0", courseIDs).Find(&courses); result.Error != nil {
+			return result.Error
+		}
+		for _, course := range courses {
+			result := db.Model(&Book{}).Unscoped().Where("course_id = ? AND institution_id = 0", course.ID).
+				UpdateColumn("institution_id", course.InstitutionID)
+			if result.Error != nil {
+				return result.Error
+			}
+		}
+	}
+
+	var institutions []Institution
+	if result := courseDB.Find(&institutions); result.Error != nil {
+		return result.Error
+	}
+	// users from before emails were verified have to verify them before they are put on a campus
+	if result := db.Exec("UPDATE users SET email_verified = ?, institution_id = 0 WHERE email_verified IS NULL", false); result.Error != nil {
+		return result.Error
+	}
+	var users []User
+	if result := db.Where("institution_id = 0 AND email_verified = ?", true).Find(&users); result.Error != nil {
+		return result.Error
+	}
+	for _, user := range users {
+		if institution := matchInstitution(institutions, user.Email); institution.ID != 0 {
+			if result := db.Model(&user).UpdateColumn("institution_id", institution.ID); result.Error != nil {
+				return result.Error
+			}
+		}
+	}
+	return nil
+}
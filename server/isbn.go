@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrInvalidISBN is returned when an ISBN has the wrong length or checksum
+var ErrInvalidISBN = errors.New("ISBN is invalid")
+
+// stripISBN removes the hyphens and spaces from an ISBN
+func stripISBN(isbn string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(isbn))
+}
+
+// isbn10Checksum returns the check character for the first 9 digits of an ISBN-10
+func isbn10Checksum(digits string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(digits[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// isbn13Checksum returns the check digit for the first 12 digits of an ISBN-13
+func isbn13Checksum(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// isDigits returns true if every character in s is a decimal digit
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13 and returns it in canonical ISBN-13 form
+// Hyphens and spaces are ignored
+func NormalizeISBN(isbn string) (string, error) {
+	isbn = strings.ToUpper(stripISBN(isbn))
+
+	switch len(isbn) {
+	case 10:
+		if !isDigits(isbn[:9]) || isbn10Checksum(isbn) != isbn[9] {
+			return "", ErrInvalidISBN
+		}
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + string(isbn13Checksum(isbn13)), nil
+	case 13:
+		if !isDigits(isbn) || isbn13Checksum(isbn) != isbn[12] {
+			return "", ErrInvalidISBN
+		}
+		if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+			return "", ErrInvalidISBN
+		}
+		return isbn, nil
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// MigrateBookISBNs converts the ISBNs of books that were saved before ISBNs were normalized
+// into canonical ISBN-13 form, keeping the original ISBN for display
+func MigrateBookISBNs(db gorm.DB) error {
+	// books in the trash are converted too so that they are found by ISBN once they are restored
+	db = *db.Unscoped()
+	var books []Book
+	if result := db.Where("original_i_s_b_n IS NULL OR original_i_s_b_n = ?", "").Find(&books); result.Error != nil {
+		return result.Error
+	}
+
+	for _, book := range books {
+		isbn, err := NormalizeISBN(book.ISBN)
+		if err != nil { // leave invalid ISBNs as they are so that they can still be displayed
+			isbn = book.ISBN
+		}
+		result := db.Model(&book).UpdateColumns(Book{ISBN: isbn, OriginalISBN: book.ISBN})
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
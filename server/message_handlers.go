@@ -9,7 +9,35 @@ import (
 	"strconv"
 )
 
-// MessagesHandler is a route for /unread_messages that returns all messages sent to the logged in user in JSON format
+// messagesPageSize is the default number of messages in a page of conversation history
+const messagesPageSize = 20
+
+// MessagesPage is a page of messages in JSON format
+type MessagesPage struct {
+	Messages []Message `json:"messages"`
+	Page     Page      `json:"page"`
+}
+
+// pageMessages finds a page of messages from a message query, newest first
+func pageMessages(r *http.Request, db *gorm.DB, defaultSize int) (MessagesPage, error) {
+	messagesPage := MessagesPage{Messages: []Message{}}
+	pageRequest, err := parsePageRequest(r.URL.Query(), defaultSize)
+	if err != nil {
+		return messagesPage, err
+	}
+
+	messagesPage.Page, err = paginate(db, pageRequest, newestMessages, &messagesPage.Messages, func(i int) pageCursor {
+		message := messagesPage.Messages[i]
+		return pageCursor{CreatedAt: message.CreatedAt, Sender: message.SenderID, Receiver: message.ReceiverID}
+	})
+	messagesPage.Page.setLinks(r.URL)
+	return messagesPage, err
+}
+
+// MessagesHandler is a route for /messages that returns a page of the messages sent to the logged in user in JSON format
+// GET parameters:
+// cursor string
+// page_size int
 func MessagesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	currentUser, err := CurrentUser(r)
 	if err != nil {
@@ -17,11 +45,9 @@ func MessagesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 		return
 	}
 
-	var recentMessages []Message
-	db.Where("receiver_id = ?", currentUser.ID).Order("created_at desc").Limit(10).Find(&recentMessages)
-	if len(recentMessages) == 0 {
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(`[]`))
+	recentMessages, err := pageMessages(r, db.Where("receiver_id = ?", currentUser.ID), DefaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -34,7 +60,11 @@ func MessagesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	w.Write(messagesJSON)
 }
 
-// PastMessagesHandler is a route for /past_messages/{id} that returns all messages sent by either the logged in user or the user with the id in JSON format
+// PastMessagesHandler is a route for /past_messages/{id} that returns a page of the messages sent by either the logged in user
+// or the user with the id in JSON format
+// GET parameters:
+// cursor string
+// page_size int
 func PastMessagesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	receiverID, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
@@ -48,11 +78,11 @@ func PastMessagesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 		return
 	}
 
-	var results []Message
 	messagesQuery := "(receiver_id = ? and sender_id = ?) or (receiver_id = ? and sender_id = ?)"
 	chatMessages := db.Where(messagesQuery, currentUser.ID, receiverID, receiverID, currentUser.ID)
-	if res := chatMessages.Limit(20).Order("created_at desc").Find(&results); res.Error != nil {
-		http.Error(w, res.Error.Error(), http.StatusInternalServerError)
+	results, err := pageMessages(r, chatMessages, messagesPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
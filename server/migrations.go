@@ -0,0 +1,35 @@
+package server
+
+import (
+	"github.com/jinzhu/gorm"
+)
+
+// MigrateDB creates or updates the tables in the main database and converts
+// rows that were saved by older versions of the models
+// The search index should be set before migrating so that it can be rebuilt
+func MigrateDB(db gorm.DB) error {
+	result := db.AutoMigrate(&User{}, &Book{}, &BookStatusChange{}, &BookPhoto{}, &BookMetadata{}, &Message{},
+		&SavedSearch{}, &Notification{}, &WantedBook{}, &ScheduledCourse{}, &CourseAudit{})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// expirations are migrated first since books with null expiration dates cannot be loaded
+	migrations := []func(gorm.DB) error{
+		MigrateBookExpirations,
+		MigrateBookISBNs,
+		MigrateBookConditions,
+		MigrateAdmins,
+	}
+	for _, migrate := range migrations {
+		if err := migrate(db); err != nil {
+			return err
+		}
+	}
+
+	// the search index is built last so that it indexes the migrated books
+	if searchIndex != nil {
+		return searchIndex.Migrate(db)
+	}
+	return nil
+}
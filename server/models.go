@@ -10,17 +10,21 @@ import (
 
 // User has the fields of a user
 type User struct {
-	ID        int       `sql:"AUTO_INCREMENT" json:"id"`
-	Firstname string    `sql:"not null" json:"first_name"`
-	Lastname  string    `sql:"not null" json:"last_name"`
-	Rating    float64   `sql:"not null; default:0" json:"rating"`
-	Email     string    `sql:"not null; unique" json:"email"`
-	Phone     int       `json:"phone"`
-	Password  string    `sql:"not null" json:"-"`
-	Messages  []Message `json:"-"`
-	Books     []Book    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID                int       `sql:"AUTO_INCREMENT" json:"id"`
+	Firstname         string    `sql:"not null" json:"first_name"`
+	Lastname          string    `sql:"not null" json:"last_name"`
+	Rating            float64   `sql:"not null; default:0" json:"rating"`
+	Email             string    `sql:"not null; unique" json:"email"`
+	Phone             int       `json:"phone"`
+	Password          string    `sql:"not null" json:"-"`
+	InstitutionID     int       `sql:"index" json:"institution_id"` // the campus of the verified email domain or 0 if it is not a campus
+	EmailVerified     bool      `json:"email_verified"`             // users are only put on a campus once their email is verified
+	VerificationToken string    `sql:"index" json:"-"`              // token of the latest verification email
+	Admin             bool      `json:"-"`                          // admins can change the course catalog
+	Messages          []Message `json:"-"`
+	Books             []Book    `json:"-"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 // NewUser constructs a new User
@@ -63,25 +67,192 @@ func (u User) Validate(password string) bool {
 
 // Book represents a book
 type Book struct {
+	ID            int           `sql:"AUTO_INCREMENT" json:"id"`
+	Title         string        `sql:"not null" json:"title"`
+	ISBN          string        `sql:"not null; index" json:"isbn"` // canonical ISBN-13
+	OriginalISBN  string        `json:"original_isbn"`              // ISBN as it was entered for display
+	Price         float64       `sql:"not null" json:"price"`
+	Condition     BookCondition `sql:"not null; default:'good'" gorm:"column:condition_grade" json:"condition"`
+	Details       string        `json:"details"`
+	Status        BookStatus    `sql:"not null; default:'available'; index" json:"status"`
+	ExpiresAt     time.Time     `sql:"index" json:"expires_at"`
+	UserID        int           `sql:"index" json:"user_id"`
+	CourseID      int           `sql:"not null"`
+	TermID        int           `json:"term_id"`                    // the term the book was used in or 0 if the seller did not say
+	InstitutionID int           `sql:"index" json:"institution_id"` // the campus of the course, since courses are in another database
+	Photos        []BookPhoto   `json:"-"`
+	Metadata      BookMetadata  `sql:"-" json:"metadata"`
+	CreatedAt     time.Time     `json:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at"`
+	DeletedAt     *time.Time    `json:"deleted_at,omitempty"`
+}
+
+// BookPhoto is a photo of a book uploaded by its seller
+type BookPhoto struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	BookID        int       `sql:"index" json:"book_id"`
+	Name          string    `sql:"not null" json:"-"`
+	ThumbnailName string    `sql:"not null" json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// URL returns the url of the full size photo
+func (p BookPhoto) URL() string {
+	return photoStorage.URL(p.Name)
+}
+
+// ThumbnailURL returns the url of the photo's thumbnail
+func (p BookPhoto) ThumbnailURL() string {
+	return photoStorage.URL(p.ThumbnailName)
+}
+
+// CoverURL returns the url of the catalog cover for the book or a placeholder if there is none
+func (b Book) CoverURL() string {
+	if b.Metadata.CoverURL == "" {
+		return "/images/no_image.png"
+	}
+	return b.Metadata.CoverURL
+}
+
+// BookMetadata is catalog information for the books with an ISBN
+type BookMetadata struct {
+	ISBN          string    `gorm:"primary_key" json:"isbn"`
+	Title         string    `json:"title"`
+	Authors       string    `json:"authors"`
+	Publisher     string    `json:"publisher"`
+	Edition       string    `json:"edition"`
+	PublishedDate string    `json:"published_date"`
+	CoverURL      string    `json:"cover_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TableName keeps gorm from pluralizing the metadata table name
+func (m BookMetadata) TableName() string {
+	return "book_metadata"
+}
+
+// BookStatusChange records a book moving from one status to another
+type BookStatusChange struct {
+	ID        int        `sql:"AUTO_INCREMENT" json:"id"`
+	BookID    int        `sql:"index" json:"book_id"`
+	UserID    int        `json:"user_id"`
+	OldStatus BookStatus `json:"old_status"`
+	NewStatus BookStatus `json:"new_status"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Course represents a class in the catalog of a campus
+// Courses without a term are from catalogs that were imported before terms and are offered in every term
+type Course struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	Department    string    `json:"department"`
+	CourseID      string    `json:"course_id"`
+	Professor     string    `json:"professor"`
+	TermID        int       `sql:"index" json:"term_id"`
+	InstitutionID int       `sql:"index" json:"institution_id"` // 0 for courses that are shared by every campus
+	Retired       bool      `json:"retired"`                    // retired courses are kept for their listings but are not searchable
+	MergedIntoID  int       `json:"merged_into_id"`             // the course that a merged course was retired into
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Professor is a person who teaches courses of a campus, with the parts of their name kept apart so
+// that they can be searched by last name. The Professor of a course is the name of its professor for display
+type Professor struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	FirstName     string    `json:"first_name"`
+	MiddleName    string    `json:"middle_name"` // middle names or initials, like "A."
+	LastName      string    `sql:"not null; index" json:"last_name"`
+	InstitutionID int       `sql:"index" json:"institution_id"` // 0 for professors of courses that are shared by every campus
+	MergedIntoID  int       `json:"merged_into_id"`             // the professor that a duplicate spelling was merged into
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// Name returns the full name of a professor, like "David A. Smallberg"
+func (p Professor) Name() string {
+	return strings.Join(strings.Fields(p.FirstName+" "+p.MiddleName+" "+p.LastName), " ")
+}
+
+// CourseProfessor links a course to the professor who teaches it
+type CourseProfessor struct {
+	ID          int       `sql:"AUTO_INCREMENT" json:"id"`
+	CourseID    int       `sql:"index" json:"course_id"`
+	ProfessorID int       `sql:"index" json:"professor_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// CourseAudit is a change that an admin made to the course catalog
+// The audit log is kept in the main database with the whole course after the change, since courses.database
+// is replaced with every deploy, and the changes are made to courses.database again when the server starts
+// Before and After are the course as JSON before and after the change, and Before is empty for created courses
+type CourseAudit struct {
+	ID           int       `sql:"AUTO_INCREMENT" json:"id"`
+	UserID       int       `sql:"index" json:"user_id"`
+	UserEmail    string    `json:"user_email"` // kept with the change so that it is shown even if the user is deleted
+	Action       string    `sql:"not null" json:"action"`
+	CourseID     int       `sql:"index" json:"course_id"`
+	MergedIntoID int       `json:"merged_into_id"`
+	BooksMoved   int       `json:"books_moved"`
+	Before       string    `sql:"type:text" json:"before"`
+	After        string    `sql:"type:text" json:"after"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Institution is a campus with its own course catalog
+// Users are assigned to the campus of their email domain, including its subdomains
+type Institution struct {
+	ID          int       `sql:"AUTO_INCREMENT" json:"id"`
+	Name        string    `sql:"not null" json:"name"`
+	EmailDomain string    `sql:"not null; unique" json:"email_domain"` // like "ucla.edu"
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Term is an academic quarter or semester that courses are taught in
+type Term struct {
 	ID        int       `sql:"AUTO_INCREMENT" json:"id"`
-	Title     string    `sql:"not null" json:"title"`
-	ISBN      string    `sql:"not null" json:"isbn"`
-	Price     float64   `sql:"not null" json:"price"`
-	Condition int       `sql:"not null" json:"condition"`
-	Details   string    `json:"details"`
-	UserID    int       `sql:"index" json:"user_id"`
-	CourseID  int       `sql:"not null"`
+	Name      string    `sql:"not null; unique" json:"name"` // like "Fall 2026"
+	StartsOn  time.Time `json:"starts_on"`
+	EndsOn    time.Time `json:"ends_on"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
-// Course represents a UCLA class
-type Course struct {
-	ID         int       `sql:"AUTO_INCREMENT" json:"id"`
-	Department string    `json:"department"`
-	CourseID   string    `json:"course_id"`
-	Professor  string    `json:"professor"`
-	CreatedAt  time.Time `json:"created_at"`
-	UpdatedAt  time.Time `json:"updated_at"`
+// CourseTextbook is a book that the bookstore adoption list of a course asks students to get
+// Textbooks belong to one catalog row, so each professor of a course can use different books
+type CourseTextbook struct {
+	ID           int       `sql:"AUTO_INCREMENT" json:"id"`
+	CourseID     int       `sql:"index" json:"course_id"`
+	ISBN         string    `sql:"index" json:"isbn"` // normalized ISBN-13
+	OriginalISBN string    `json:"original_isbn"`
+	Title        string    `json:"title"`
+	Required     bool      `json:"required"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// DepartmentAlias is another name that students use for a department of the catalog, like "CS" for "Computer Science"
+type DepartmentAlias struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	Alias         string    `sql:"not null; index" json:"alias"` // lowercase letters and digits only, like "comsci"
+	Department    string    `sql:"not null" json:"department"`
+	InstitutionID int       `sql:"index" json:"institution_id"` // 0 for aliases of every campus
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// CrossListing is a course number of a department that is the same class as the other course numbers
+// of its group, like "Computer Science M51A" and "Electrical Engineering M16"
+type CrossListing struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	GroupID       int       `sql:"index" json:"group_id"`
+	Department    string    `sql:"not null" json:"department"`
+	CourseID      string    `sql:"not null" json:"course_id"`
+	InstitutionID int       `sql:"index" json:"institution_id"` // 0 for cross listings of every campus
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // Message represents a message
@@ -94,3 +265,60 @@ type Message struct {
 	Longitude  float64   `json:"longitude"`
 	CreatedAt  time.Time `json:"created_at"`
 }
+
+// SavedSearch is a book search that a user wants to be notified about when a matching book is listed
+// Zero values mean that the filter is not applied and Condition is the worst condition the user accepts
+type SavedSearch struct {
+	ID        int           `sql:"AUTO_INCREMENT" json:"id"`
+	UserID    int           `sql:"index" json:"user_id"`
+	Query     string        `json:"query"`
+	CourseID  int           `json:"course_id"`
+	MaxPrice  float64       `json:"max_price"`
+	Condition BookCondition `gorm:"column:condition_grade" json:"condition"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+
+	CourseName string `sql:"-" json:"course_name,omitempty"` // department and course number from the course database
+}
+
+// WantedBook is a request from a buyer for a book with an ISBN or for a course
+// Sellers are notified when they list a book that matches it, and so is the buyer
+// Condition is the worst condition the buyer accepts, or empty for any condition
+type WantedBook struct {
+	ID           int           `sql:"AUTO_INCREMENT" json:"id"`
+	UserID       int           `sql:"index" json:"user_id"`
+	Title        string        `json:"title"`
+	ISBN         string        `sql:"index" json:"isbn"` // canonical ISBN-13 or empty for any book for the course
+	OriginalISBN string        `json:"original_isbn"`
+	CourseID     int           `sql:"index" json:"course_id"`
+	MaxPrice     float64       `sql:"not null" json:"max_price"`
+	Condition    BookCondition `gorm:"column:condition_grade" json:"condition"`
+	Details      string        `json:"details"`
+	CreatedAt    time.Time     `json:"created_at"`
+	UpdatedAt    time.Time     `json:"updated_at"`
+	DeletedAt    *time.Time    `json:"deleted_at,omitempty"`
+
+	CourseName string `sql:"-" json:"course_name,omitempty"` // department and course number from the course database
+}
+
+// ScheduledCourse is a course that a user is taking in a term, which is used to find the books for their classes
+type ScheduledCourse struct {
+	ID        int       `sql:"AUTO_INCREMENT" json:"id"`
+	UserID    int       `sql:"index" json:"user_id"`
+	CourseID  int       `sql:"index" json:"course_id"`
+	TermID    int       `sql:"index" json:"term_id"` // 0 if there were no terms when the course was added
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Notification tells a user that a book matching one of their saved searches or wanted books was listed
+// Delivered is set once the notification has been sent to one of the user's websocket connections
+type Notification struct {
+	ID            int       `sql:"AUTO_INCREMENT" json:"id"`
+	UserID        int       `sql:"index" json:"user_id"`
+	SavedSearchID int       `json:"saved_search_id,omitempty"`
+	WantedBookID  int       `json:"wanted_book_id,omitempty"`
+	BookID        int       `json:"book_id"`
+	Message       string    `json:"message"`
+	Delivered     bool      `sql:"index" json:"delivered"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// DefaultPageSize is the number of items on a page when a request does not ask for a page size
+	DefaultPageSize = 10
+	maxPageSize     = 100
+)
+
+// pageCursor is the position of the first or last item of a page in a sorted list
+// Clients only see cursors as opaque strings
+type pageCursor struct {
+	CreatedAt time.Time `json:"t,omitempty"`
+	Price     float64   `json:"p,omitempty"`
+	Rank      int       `json:"r,omitempty"`
+	ID        int       `json:"i,omitempty"`
+	Sender    int       `json:"s,omitempty"` // sender and receiver of a message, which has no ID
+	Receiver  int       `json:"v,omitempty"`
+	Before    bool      `json:"b,omitempty"` // the page is the items before the position instead of after it
+}
+
+// encode turns a cursor into an opaque string
+func (c pageCursor) encode() string {
+	cursorJSON, _ := json.Marshal(c)
+	return base64.URLEncoding.EncodeToString(cursorJSON)
+}
+
+// decodePageCursor reads a cursor from an opaque string
+func decodePageCursor(s string) (pageCursor, error) {
+	var cursor pageCursor
+	cursorJSON, err := base64.URLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, errors.New("Invalid page cursor")
+	}
+	if err := json.Unmarshal(cursorJSON, &cursor); err != nil {
+		return cursor, errors.New("Invalid page cursor")
+	}
+	return cursor, nil
+}
+
+// pageRequest is the page that a list request asks for
+type pageRequest struct {
+	Cursor *pageCursor
+	Size   int
+}
+
+// parsePageRequest reads the requested page from GET parameters
+// GET parameters:
+// cursor string (the next or prev cursor of another page, or empty for the first page)
+// page_size int (defaults to defaultSize)
+func parsePageRequest(values url.Values, defaultSize int) (pageRequest, error) {
+	request := pageRequest{Size: defaultSize}
+	if size := values.Get("page_size"); size != "" {
+		var err error
+		if request.Size, err = strconv.Atoi(size); err != nil || request.Size < 1 {
+			return request, errors.New("Page size has to be a positive number")
+		}
+		if request.Size > maxPageSize {
+			request.Size = maxPageSize
+		}
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		pageCursor, err := decodePageCursor(cursor)
+		if err != nil {
+			return request, err
+		}
+		request.Cursor = &pageCursor
+	}
+	return request, nil
+}
+
+// Page describes a page of a list with cursors and links for the neighbouring pages
+// The cursors and links are empty when there is no page in that direction
+type Page struct {
+	Size    int    `json:"size"`
+	Next    string `json:"next,omitempty"`
+	Prev    string `json:"prev,omitempty"`
+	NextURL string `json:"next_url,omitempty"`
+	PrevURL string `json:"prev_url,omitempty"`
+}
+
+// setLinks sets the links to the neighbouring pages from the url of the current page
+func (p *Page) setLinks(u *url.URL) {
+	link := func(cursor string) string {
+		if cursor == "" {
+			return ""
+		}
+		values := u.Query()
+		values.Set("cursor", cursor)
+		values.Del("csrf_token")
+		return u.Path + "?" + values.Encode()
+	}
+	p.NextURL = link(p.Next)
+	p.PrevURL = link(p.Prev)
+}
+
+// pageOrder is a sort order of a list that pages are cut from by seeking past a cursor
+// The order sorts by column and then by the idColumns, which together have to be unique for every item
+// Lists without ID columns are ordered by column alone
+type pageOrder struct {
+	column    string
+	idColumns []string
+	desc      bool
+	key       func(pageCursor) interface{}   // the value of column at a cursor
+	ids       func(pageCursor) []interface{} // the values of idColumns at a cursor
+}
+
+var (
+	newestFirst        = pageOrder{column: "created_at", idColumns: idColumn, desc: true, key: cursorCreatedAt, ids: cursorID}
+	cheapestFirst      = pageOrder{column: "price", idColumns: idColumn, key: cursorPrice, ids: cursorID}
+	mostExpensiveFirst = pageOrder{column: "price", idColumns: idColumn, desc: true, key: cursorPrice, ids: cursorID}
+	// messages have no ID, so messages sent at the same time are told apart by who sent and received them
+	newestMessages = pageOrder{column: "created_at", idColumns: []string{"sender_id", "receiver_id"}, desc: true,
+		key: cursorCreatedAt, ids: cursorMessage}
+)
+
+var idColumn = []string{"id"}
+
+func cursorCreatedAt(c pageCursor) interface{} { return c.CreatedAt }
+func cursorPrice(c pageCursor) interface{}     { return c.Price }
+func cursorRank(c pageCursor) interface{}      { return c.Rank }
+func cursorID(c pageCursor) []interface{}      { return []interface{}{c.ID} }
+func cursorMessage(c pageCursor) []interface{} { return []interface{}{c.Sender, c.Receiver} }
+
+// rankOrder orders a list by an SQL expression for the rank of every item
+// Items can share a rank, like the unranked course matches of a search, so ties are broken by ID
+func rankOrder(expression string) pageOrder {
+	return pageOrder{column: expression, idColumns: []string{"books.id"}, key: cursorRank, ids: cursorID}
+}
+
+// orderBy returns the ORDER BY clause, which is reversed when paging backwards
+func (o pageOrder) orderBy(backwards bool) string {
+	direction := "asc"
+	if o.desc != backwards {
+		direction = "desc"
+	}
+	columns := []string{o.column + " " + direction}
+	for _, column := range o.idColumns {
+		columns = append(columns, column+" "+direction)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// seek restricts a query to the items after a cursor, or before it when paging backwards
+func (o pageOrder) seek(db *gorm.DB, cursor pageCursor, backwards bool) *gorm.DB {
+	op := ">"
+	if o.desc != backwards {
+		op = "<"
+	}
+	if len(o.idColumns) == 0 {
+		return db.Where(o.column+" "+op+" ?", o.key(cursor))
+	}
+
+	// the items after (a, b, c) are the items with a > ?, or a = ? and b > ?, or a = ? and b = ? and c > ?
+	columns := append([]string{o.column}, o.idColumns...)
+	values := append([]interface{}{o.key(cursor)}, o.ids(cursor)...)
+	var clauses []string
+	var args []interface{}
+	for i := range columns {
+		var clause []string
+		for j := 0; j < i; j++ {
+			clause = append(clause, columns[j]+" = ?")
+			args = append(args, values[j])
+		}
+		clause = append(clause, fmt.Sprintf("%s %s ?", columns[i], op))
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(clause, " AND ")+")")
+	}
+	return db.Where(strings.Join(clauses, " OR "), args...)
+}
+
+// paginate finds a page of items from a query into out, which has to be a pointer to a slice
+// cursorAt returns the cursor of the item at an index of out after it has been filled
+func paginate(db *gorm.DB, request pageRequest, order pageOrder, out interface{}, cursorAt func(i int) pageCursor) (Page, error) {
+	page := Page{Size: request.Size}
+
+	backwards := false
+	if request.Cursor != nil {
+		backwards = request.Cursor.Before
+		db = order.seek(db, *request.Cursor, backwards)
+	}
+
+	// one extra item is loaded to find out if there is another page
+	if result := db.Order(order.orderBy(backwards)).Limit(request.Size + 1).Find(out); result.Error != nil {
+		return page, result.Error
+	}
+
+	items := reflect.ValueOf(out).Elem()
+	more := items.Len() > request.Size
+	if more {
+		items.Set(items.Slice(0, request.Size))
+	}
+	if backwards {
+		for i, j := 0, items.Len()-1; i < j; i, j = i+1, j-1 {
+			item := reflect.New(items.Type().Elem()).Elem()
+			item.Set(items.Index(i))
+			items.Index(i).Set(items.Index(j))
+			items.Index(j).Set(item)
+		}
+	}
+
+	count := items.Len()
+	if count == 0 {
+		return page, nil
+	}
+	// a page reached by going backwards always has a page after it and
+	// a page reached by going forwards always has a page before it
+	if more || backwards {
+		next := cursorAt(count - 1)
+		next.Before = false
+		page.Next = next.encode()
+	}
+	if (more && backwards) || (request.Cursor != nil && !backwards) {
+		prev := cursorAt(0)
+		prev.Before = true
+		page.Prev = prev.encode()
+	}
+	return page, nil
+}
+
+// bookCursor returns the cursor of a book, using ranks for the relevance rank of the book if it is a search result
+func bookCursor(book Book, ranks map[int]int) pageCursor {
+	return pageCursor{CreatedAt: book.CreatedAt, Price: book.Price, Rank: ranks[book.ID], ID: book.ID}
+}
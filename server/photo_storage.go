@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// PhotoStorage is an interface for storing uploaded photos
+type PhotoStorage interface {
+	Save(name string, r io.Reader) error // stores the photo data under a name
+	Delete(name string) error            // removes a stored photo
+	URL(name string) string              // returns the url the photo can be viewed at
+}
+
+// LocalPhotoStorage is an implementation of PhotoStorage that saves photos to a local directory
+type LocalPhotoStorage struct {
+	dir       string
+	urlPrefix string
+}
+
+// NewLocalPhotoStorage constructs a new LocalPhotoStorage that saves photos in dir
+// and serves them under urlPrefix
+func NewLocalPhotoStorage(dir string, urlPrefix string) LocalPhotoStorage {
+	return LocalPhotoStorage{dir: dir, urlPrefix: urlPrefix}
+}
+
+// Save writes the photo to a file inside the storage directory
+func (s LocalPhotoStorage) Save(name string, r io.Reader) error {
+	filePath := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+// Delete removes the photo file from the storage directory
+func (s LocalPhotoStorage) Delete(name string) error {
+	err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// URL returns the url of the photo under the url prefix
+func (s LocalPhotoStorage) URL(name string) string {
+	return path.Join(s.urlPrefix, name)
+}
+
+// photoStorage is where uploaded book photos are stored
+var photoStorage PhotoStorage = NewLocalPhotoStorage("./static/uploads", "/uploads")
+
+// SetPhotoStorage changes where uploaded book photos are stored
+func SetPhotoStorage(storage PhotoStorage) {
+	photoStorage = storage
+}
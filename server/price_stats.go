@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// maxRecentSales is the number of sales listed in the price stats of an ISBN
+const maxRecentSales = 10
+
+// PriceStats summarizes the prices of a set of listings
+// The prices are zero when there are no listings
+type PriceStats struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	Max    float64 `json:"max"`
+}
+
+// ConditionPriceStats are the price stats of the listings in one condition
+type ConditionPriceStats struct {
+	Condition BookCondition `json:"condition"`
+	Label     string        `json:"label"`
+	PriceStats
+}
+
+// Sale is a sold listing with the price it was listed for
+type Sale struct {
+	BookID    int           `json:"book_id"`
+	Price     float64       `json:"price"`
+	Condition BookCondition `json:"condition"`
+	SoldAt    time.Time     `json:"sold_at"`
+}
+
+// ISBNStats are the asking prices of the available listings of an ISBN and the prices
+// of its sold listings, overall and for every condition that has listings
+type ISBNStats struct {
+	ISBN              string                `json:"isbn"`
+	Active            PriceStats            `json:"active"`
+	ActiveByCondition []ConditionPriceStats `json:"active_by_condition"`
+	Sold              PriceStats            `json:"sold"`
+	SoldByCondition   []ConditionPriceStats `json:"sold_by_condition"`
+	RecentSales       []Sale                `json:"recent_sales"`
+}
+
+// ISBNStatsTemplateType is the type for the ISBN stats template
+type ISBNStatsTemplateType struct {
+	UserTemplateType
+	Metadata BookMetadata
+	Stats    ISBNStats
+}
+
+// newPriceStats computes the price stats of a list of prices
+// The median of an even number of prices is the average of the two middle prices
+func newPriceStats(prices []float64) PriceStats {
+	if len(prices) == 0 {
+		return PriceStats{}
+	}
+	sorted := append([]float64(nil), prices...)
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	median := sorted[middle]
+	if len(sorted)%2 == 0 {
+		median = (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return PriceStats{Count: len(sorted), Min: sorted[0], Median: median, Max: sorted[len(sorted)-1]}
+}
+
+// listingPrices returns the prices of a book query grouped by condition
+func listingPrices(db *gorm.DB) (map[BookCondition][]float64, error) {
+	rows, err := db.Model(&Book{}).Select("price, condition_grade").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	prices := map[BookCondition][]float64{}
+	for rows.Next() {
+		var price float64
+		var condition BookCondition
+		if err := rows.Scan(&price, &condition); err != nil {
+			return nil, err
+		}
+		prices[condition] = append(prices[condition], price)
+	}
+	return prices, rows.Err()
+}
+
+// summarizePrices computes the overall price stats and the price stats for every condition that has prices
+func summarizePrices(prices map[BookCondition][]float64) (PriceStats, []ConditionPriceStats) {
+	var all []float64
+	byCondition := []ConditionPriceStats{}
+	for _, condition := range BookConditions {
+		if len(prices[condition]) == 0 {
+			continue
+		}
+		all = append(all, prices[condition]...)
+		byCondition = append(byCondition, ConditionPriceStats{
+			Condition:  condition,
+			Label:      condition.Label(),
+			PriceStats: newPriceStats(prices[condition]),
+		})
+	}
+	return newPriceStats(all), byCondition
+}
+
+// FindISBNStats computes the price stats of the listings of an ISBN-13
+// Sold listings are counted even if their seller deleted them afterwards
+func FindISBNStats(db gorm.DB, isbn string) (ISBNStats, error) {
+	stats := ISBNStats{ISBN: isbn, RecentSales: []Sale{}}
+
+	activePrices, err := listingPrices(db.Where("i_s_b_n = ? AND status = ?", isbn, BookAvailable))
+	if err != nil {
+		return stats, err
+	}
+	stats.Active, stats.ActiveByCondition = summarizePrices(activePrices)
+
+	soldPrices, err := listingPrices(db.Unscoped().Where("i_s_b_n = ? AND status = ?", isbn, BookSold))
+	if err != nil {
+		return stats, err
+	}
+	stats.Sold, stats.SoldByCondition = summarizePrices(soldPrices)
+	if stats.Sold.Count == 0 {
+		return stats, nil
+	}
+
+	// sold is a final status so every sold listing was marked as sold exactly once
+	rows, err := db.Table("book_status_changes").
+		Select("books.id, books.price, books.condition_grade, book_status_changes.created_at").
+		Joins("JOIN books ON books.id = book_status_changes.book_id").
+		Where("books.i_s_b_n = ? AND book_status_changes.new_status = ?", isbn, BookSold).
+		Order("book_status_changes.created_at desc, books.id desc").Limit(maxRecentSales).Rows()
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sale Sale
+		if err := rows.Scan(&sale.BookID, &sale.Price, &sale.Condition, &sale.SoldAt); err != nil {
+			return stats, err
+		}
+		stats.RecentSales = append(stats.RecentSales, sale)
+	}
+	return stats, rows.Err()
+}
+
+// isbnStatsRequest computes the price stats for the ISBN in the url of a request
+func isbnStatsRequest(r *http.Request, db gorm.DB) (ISBNStats, int, error) {
+	isbn, err := NormalizeISBN(mux.Vars(r)["isbn"])
+	if err != nil {
+		return ISBNStats{}, http.StatusBadRequest, err
+	}
+	stats, err := FindISBNStats(db, isbn)
+	if err != nil {
+		return stats, http.StatusInternalServerError, err
+	}
+	return stats, http.StatusOK, nil
+}
+
+// ISBNStatsHandler is a route for /isbn/{isbn}/stats that shows the asking prices of the listings
+// of an ISBN by condition and the prices it has sold for
+func ISBNStatsHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	stats, status, err := isbnStatsRequest(r, db)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/isbn_stats.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var metadata BookMetadata
+	db.Where("i_s_b_n = ?", stats.ISBN).First(&metadata)
+
+	t.Execute(w, ISBNStatsTemplateType{
+		UserTemplateType: params,
+		Metadata:         metadata,
+		Stats:            stats,
+	})
+}
+
+// ISBNStatsJSONHandler is a route for /isbn/{isbn}/stats/json that returns the price stats of an ISBN in JSON format
+func ISBNStatsJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	stats, status, err := isbnStatsRequest(r, db)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(statsJSON)
+}
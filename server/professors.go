@@ -0,0 +1,428 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	errProfessorNotFound  = errors.New("Professor not found")
+	errProfessorMerged    = errors.New("Professor was already merged into another professor")
+	errMergeSameProfessor = errors.New("Cannot merge a professor into itself")
+	errProfessorCampus    = errors.New("Cannot merge professors of different campuses")
+)
+
+// lastNameParticles are words that start a last name, so "Alicia Gaspar De Alba" has the last name "De Alba"
+var lastNameParticles = map[string]bool{
+	"de": true, "del": true, "della": true, "da": true, "di": true, "du": true,
+	"van": true, "von": true, "la": true, "le": true,
+}
+
+// ParseProfessorName splits the name of a professor in the catalog into its parts. Names are written
+// "First Middle Last" or "Last, First Middle", and a name with one word only has a last name
+func ParseProfessorName(name string) Professor {
+	name = cleanCourseField(name)
+	if comma := strings.Index(name, ","); comma >= 0 {
+		professor := Professor{LastName: strings.TrimSpace(name[:comma])}
+		if words := strings.Fields(name[comma+1:]); len(words) > 0 {
+			professor.FirstName, professor.MiddleName = words[0], strings.Join(words[1:], " ")
+		}
+		return professor
+	}
+
+	words := strings.Fields(name)
+	switch len(words) {
+	case 0:
+		return Professor{}
+	case 1:
+		return Professor{LastName: words[0]}
+	}
+	last := len(words) - 1
+	for last > 1 && lastNameParticles[strings.ToLower(words[last-1])] {
+		last--
+	}
+	return Professor{
+		FirstName:  words[0],
+		MiddleName: strings.Join(words[1:last], " "),
+		LastName:   strings.Join(words[last:], " "),
+	}
+}
+
+// professorKey is the name of a professor without case or punctuation, so "David A. Smallberg"
+// and "david a smallberg" are the same professor
+func professorKey(p Professor) string {
+	return normalizeAlias(p.FirstName) + "\x00" + normalizeAlias(p.MiddleName) + "\x00" + normalizeAlias(p.LastName)
+}
+
+// professorDirectory finds the professors of a campus by name without a query for every course
+type professorDirectory struct {
+	institutionID int
+	byKey         map[string]Professor
+	byID          map[int]Professor
+}
+
+// loadProfessorDirectory loads the professors of a campus, or the shared professors if institutionID is 0
+func loadProfessorDirectory(courseDB *gorm.DB, institutionID int) (professorDirectory, error) {
+	directory := professorDirectory{institutionID: institutionID, byKey: map[string]Professor{}, byID: map[int]Professor{}}
+	var professors []Professor
+	if result := courseDB.Where("institution_id = ?", institutionID).Order("id").Find(&professors); result.Error != nil {
+		return directory, result.Error
+	}
+	for _, professor := range professors {
+		directory.add(professor)
+	}
+	return directory, nil
+}
+
+// add adds a professor to the directory, keeping the first professor with a name
+func (d professorDirectory) add(professor Professor) {
+	d.byID[professor.ID] = professor
+	if _, ok := d.byKey[professorKey(professor)]; !ok {
+		d.byKey[professorKey(professor)] = professor
+	}
+}
+
+// find returns the professor with a name, or the professor that they were merged into
+func (d professorDirectory) find(name string) (Professor, bool) {
+	professor, ok := d.byKey[professorKey(ParseProfessorName(name))]
+	if !ok {
+		return professor, false
+	}
+	// merges always point at a professor that was not merged, but a bad chain should not loop forever
+	for i := 0; professor.MergedIntoID != 0 && i < len(d.byID); i++ {
+		into, ok := d.byID[professor.MergedIntoID]
+		if !ok {
+			break
+		}
+		professor = into
+	}
+	return professor, true
+}
+
+// canonicalName returns the name of the professor that a name belongs to, which is another spelling
+// if their duplicates were merged, or the name itself for professors that are not in the directory
+func (d professorDirectory) canonicalName(name string) string {
+	if professor, ok := d.find(name); ok {
+		return professor.Name()
+	}
+	return name
+}
+
+// findOrCreate returns the professor with a name, creating them if they are not in the directory
+func (d professorDirectory) findOrCreate(tx *gorm.DB, name string) (Professor, error) {
+	if professor, ok := d.find(name); ok {
+		return professor, nil
+	}
+	professor := ParseProfessorName(name)
+	professor.InstitutionID = d.institutionID
+	professor.CreatedAt = time.Now()
+	professor.UpdatedAt = professor.CreatedAt
+	if result := tx.Create(&professor); result.Error != nil {
+		return professor, result.Error
+	}
+	d.add(professor)
+	return professor, nil
+}
+
+// linkCourseProfessor links a course to the professor of its Professor name in place of any professor it had
+func linkCourseProfessor(tx *gorm.DB, directory professorDirectory, course Course) error {
+	if result := tx.Where("course_id = ?", course.ID).Delete(&CourseProfessor{}); result.Error != nil {
+		return result.Error
+	}
+	if ParseProfessorName(course.Professor).LastName == "" {
+		return nil
+	}
+	professor, err := directory.findOrCreate(tx, course.Professor)
+	if err != nil {
+		return err
+	}
+	link := CourseProfessor{CourseID: course.ID, ProfessorID: professor.ID, CreatedAt: time.Now()}
+	return tx.Create(&link).Error
+}
+
+// MigrateProfessors links the courses that have a professor name but no professor to the professor
+// with that name, creating the professors of the catalog the first time it runs
+func MigrateProfessors(courseDB gorm.DB) error {
+	var courses []Course
+	result := courseDB.Where("professor <> '' AND id NOT IN (SELECT course_id FROM course_professors)").
+		Order("id").Find(&courses)
+	if result.Error != nil || len(courses) == 0 {
+		return result.Error
+	}
+
+	tx := courseDB.Begin()
+	directories := map[int]professorDirectory{}
+	for _, course := range courses {
+		directory, ok := directories[course.InstitutionID]
+		if !ok {
+			var err error
+			if directory, err = loadProfessorDirectory(tx, course.InstitutionID); err != nil {
+				tx.Rollback()
+				return err
+			}
+			directories[course.InstitutionID] = directory
+		}
+		if err := linkCourseProfessor(tx, directory, course); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// ProfessorDuplicates is a professor with the other spellings of their name, which can be merged into them
+type ProfessorDuplicates struct {
+	Professor  Professor   `json:"professor"`
+	Duplicates []Professor `json:"duplicates"`
+}
+
+// namePartsMatch returns true if two parts of a name can be the same name, because they are equal or one is
+// the initial of the other like "D." and "David". An empty part only matches another part if allowEmpty is set
+func namePartsMatch(a, b string, allowEmpty bool) bool {
+	a, b = normalizeAlias(a), normalizeAlias(b)
+	switch {
+	case a == b:
+		return true
+	case a == "" || b == "":
+		return allowEmpty
+	case len(a) == 1:
+		return strings.HasPrefix(b, a)
+	case len(b) == 1:
+		return strings.HasPrefix(a, b)
+	}
+	return false
+}
+
+// sameProfessor returns true if two professors of a campus can be the same person, because they have the
+// same last name, their first names match and their middle names match or one of them has no middle name
+func sameProfessor(a, b Professor) bool {
+	return a.InstitutionID == b.InstitutionID && normalizeAlias(a.LastName) == normalizeAlias(b.LastName) &&
+		namePartsMatch(a.FirstName, b.FirstName, false) && namePartsMatch(a.MiddleName, b.MiddleName, true)
+}
+
+// fullerProfessor returns true if a has a fuller name than b, like "Mani B. Srivastava" over "Mani Srivastava",
+// or else teaches more courses, or else was created first
+func fullerProfessor(a, b Professor, courses map[int]int) bool {
+	aName, bName := normalizeAlias(a.FirstName+a.MiddleName), normalizeAlias(b.FirstName+b.MiddleName)
+	switch {
+	case len(aName) != len(bName):
+		return len(aName) > len(bName)
+	case courses[a.ID] != courses[b.ID]:
+		return courses[a.ID] > courses[b.ID]
+	}
+	return a.ID < b.ID
+}
+
+// professorCourseCounts counts the courses of every professor by professor ID
+func professorCourseCounts(courseDB gorm.DB) (map[int]int, error) {
+	rows, err := courseDB.Model(&CourseProfessor{}).Select("professor_id, COUNT(*)").Group("professor_id").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[int]int{}
+	for rows.Next() {
+		var professorID, count int
+		if err := rows.Scan(&professorID, &count); err != nil {
+			return nil, err
+		}
+		counts[professorID] = count
+	}
+	return counts, rows.Err()
+}
+
+// FindDuplicateProfessors finds the professors that are spelled more than one way, like "Revaz Dzhanidze" and
+// "Revaz P. Dzhanidze", and picks the fullest spelling of each to merge the others into. Spellings only go
+// together if every one of them can be the same person as the others, so "J. Smith" is left alone when
+// there are both a "John Smith" and a "Jane Smith"
+func FindDuplicateProfessors(courseDB gorm.DB) ([]ProfessorDuplicates, error) {
+	duplicates := []ProfessorDuplicates{}
+	var professors []Professor
+	if result := courseDB.Where("merged_into_id = 0").Order("id").Find(&professors); result.Error != nil {
+		return duplicates, result.Error
+	}
+	courses, err := professorCourseCounts(courseDB)
+	if err != nil {
+		return duplicates, err
+	}
+
+	lastNames := map[string][]Professor{}
+	for _, professor := range professors {
+		key := strconv.Itoa(professor.InstitutionID) + "\x00" + normalizeAlias(professor.LastName)
+		lastNames[key] = append(lastNames[key], professor)
+	}
+
+	for _, group := range lastNames {
+		if len(group) < 2 {
+			continue
+		}
+		// professors that can be the same person are joined into clusters
+		clusters := make([]int, len(group))
+		for i := range clusters {
+			clusters[i] = i
+		}
+		var root func(i int) int
+		root = func(i int) int {
+			for clusters[i] != i {
+				i = clusters[i]
+			}
+			return i
+		}
+		for i := range group {
+			for j := i + 1; j < len(group); j++ {
+				if sameProfessor(group[i], group[j]) {
+					clusters[root(j)] = root(i)
+				}
+			}
+		}
+
+		members := map[int][]Professor{}
+		for i, professor := range group {
+			members[root(i)] = append(members[root(i)], professor)
+		}
+		for _, cluster := range members {
+			if len(cluster) < 2 || !allSameProfessor(cluster) {
+				continue
+			}
+			kept := 0
+			for i := range cluster {
+				if fullerProfessor(cluster[i], cluster[kept], courses) {
+					kept = i
+				}
+			}
+			found := ProfessorDuplicates{Professor: cluster[kept], Duplicates: []Professor{}}
+			for i, professor := range cluster {
+				if i != kept {
+					found.Duplicates = append(found.Duplicates, professor)
+				}
+			}
+			duplicates = append(duplicates, found)
+		}
+	}
+
+	sort.Sort(duplicatesByName(duplicates))
+	return duplicates, nil
+}
+
+// allSameProfessor returns true if every professor can be the same person as every other one
+func allSameProfessor(professors []Professor) bool {
+	for i := range professors {
+		for j := i + 1; j < len(professors); j++ {
+			if !sameProfessor(professors[i], professors[j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// duplicatesByName sorts duplicate professors by last name and then by first name
+type duplicatesByName []ProfessorDuplicates
+
+func (d duplicatesByName) Len() int      { return len(d) }
+func (d duplicatesByName) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d duplicatesByName) Less(i, j int) bool {
+	a, b := d[i].Professor, d[j].Professor
+	if !strings.EqualFold(a.LastName, b.LastName) {
+		return strings.ToLower(a.LastName) < strings.ToLower(b.LastName)
+	}
+	if !strings.EqualFold(a.FirstName, b.FirstName) {
+		return strings.ToLower(a.FirstName) < strings.ToLower(b.FirstName)
+	}
+	return a.ID < b.ID
+}
+
+// findProfessor looks up a professor that was not merged
+func findProfessor(courseDB gorm.DB, id int) (Professor, error) {
+	var professor Professor
+	if result := courseDB.First(&professor, id); result.Error != nil {
+		if result.RecordNotFound() {
+			return professor, errProfessorNotFound
+		}
+		return professor, result.Error
+	}
+	if professor.MergedIntoID != 0 {
+		return professor, errProfessorMerged
+	}
+	return professor, nil
+}
+
+// MergeProfessors merges the professor with id into the professor with intoID, which is another spelling
+// of their name. The courses of the professor are linked to the other professor and renamed to their name,
+// and later catalog imports with the merged spelling find the other professor. It returns the number of
+// courses that were moved
+func MergeProfessors(courseDB gorm.DB, id int, intoID int) (int, error) {
+	if id == intoID {
+		return 0, errMergeSameProfessor
+	}
+	professor, err := findProfessor(courseDB, id)
+	if err != nil {
+		return 0, err
+	}
+	into, err := findProfessor(courseDB, intoID)
+	if err != nil {
+		return 0, err
+	}
+	if professor.InstitutionID != into.InstitutionID {
+		return 0, errProfessorCampus
+	}
+
+	var links []CourseProfessor
+	if result := courseDB.Where("professor_id = ?", professor.ID).Find(&links); result.Error != nil {
+		return 0, result.Error
+	}
+
+	tx := courseDB.Begin()
+	now := time.Now()
+	for _, link := range links {
+		var count int
+		result := tx.Model(&CourseProfessor{}).Where("course_id = ? AND professor_id = ?", link.CourseID, into.ID).Count(&count)
+		if result.Error == nil {
+			if count > 0 {
+				result = tx.Delete(&link)
+			} else {
+				result = tx.Model(&link).UpdateColumn("professor_id", into.ID)
+			}
+		}
+		if result.Error == nil {
+			result = tx.Model(&Course{}).Where("id = ?", link.CourseID).
+				UpdateColumns(map[string]interface{}{"professor": into.Name(), "updated_at": now})
+		}
+		if result.Error != nil {
+			tx.Rollback()
+			return 0, result.Error
+		}
+	}
+
+	// professors that were merged into this professor before are merged into the other professor now
+	result := tx.Model(&Professor{}).Where("merged_into_id = ? OR id = ?", professor.ID, professor.ID).
+		UpdateColumns(map[string]interface{}{"merged_into_id": into.ID, "updated_at": now})
+	if result.Error != nil {
+		tx.Rollback()
+		return 0, result.Error
+	}
+	return len(links), tx.Commit().Error
+}
+
+// filterProfessorWords limits a course query to the courses with a professor whose first or middle name
+// starts with, or whose last name contains, each of the words, so "smallberg" and "david smallb" both
+// find "David A. Smallberg"
+func filterProfessorWords(db *gorm.DB, words []string) *gorm.DB {
+	if len(words) == 0 {
+		return db
+	}
+	conditions := make([]string, len(words))
+	var args []interface{}
+	for i, word := range words {
+		conditions[i] = "(LOWER(professors.first_name) LIKE ? OR LOWER(professors.middle_name) LIKE ? OR LOWER(professors.last_name) LIKE ?)"
+		args = append(args, word+"%", word+"%", "%"+word+"%")
+	}
+	return db.Where(`id IN (SELECT course_professors.course_id FROM course_professors
+		JOIN professors ON professors.id = course_professors.professor_id WHERE `+strings.Join(conditions, " AND ")+")", args...)
+}
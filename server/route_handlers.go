@@ -34,9 +34,14 @@ type MessageTemplateType struct {
 type BookTemplateType struct {
 	UserTemplateType
 
-	Book      Book
-	UserID    int
-	CanDelete bool
+	Book       Book
+	UserID     int
+	CanDelete  bool
+	Conditions []BookCondition
+	PriceStats *ISBNStats
+	Terms      []Term // terms that the book can be listed as used in
+	TermID     int    // selected term, or 0 when the seller did not say
+	TermName   string // name of the term the book was used in
 }
 
 // ManyBookTemplateType is for displaying many books (reused for many different things like
@@ -44,8 +49,13 @@ type BookTemplateType struct {
 type ManyBookTemplateType struct {
 	UserTemplateType
 
-	Books []Book
-	Title string
+	Books         []Book
+	Title         string
+	ShowTrashLink bool
+	SearchResults *BookSearchResults // filters and facet counts when the books are search results
+	Sorts         []string
+	Page          Page
+	Campus        CampusScope // the campus of the books when they are limited to one
 }
 
 // GenerateFullTemplate returns complete template with navigation bar added and your user login template
@@ -70,9 +80,15 @@ func GenerateFullTemplate(r *http.Request, bodyTemplatePath string) (*template.T
  * Route Handlers
  */
 
-// RootHandler is a route for / that either displays the index page if you are not logged in or the main page with the recent books if logged in
-func RootHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+// RootHandler is a route for / that either displays the index page if you are not logged in or the books for your classes if logged in
+// GET parameters:
+// view string ("recent" for the recent books instead of the books for your classes)
+func RootHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
 	_, err := CurrentUser(r)
+	if err == nil && r.URL.Query().Get("view") != "recent" {
+		ScheduleHandler(w, r, db, courseDB)
+		return
+	}
 	if err != nil { // show login page if not logged in
 		t, err := template.ParseFiles("templates/boilerplate/normal_boilerplate.html", "templates/index.html")
 		if err != nil {
@@ -82,9 +98,25 @@ func RootHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 
 		t.Execute(w, struct{ Token string }{nosurf.Token(r)})
 	} else { // show recent book listings if logged in
+		pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		campus := newCampusScope(r, courseDB)
 		var recentBooks []Book
-		if result := db.Order("created_at desc").Limit(10).Find(&recentBooks); result.Error != nil {
-			http.Error(w, result.Error.Error(), http.StatusUnauthorized)
+		books := filterInstitution(statusQuery(r, db), campus.InstitutionID())
+		page, err := paginate(books, pageRequest, newestFirst, &recentBooks, func(i int) pageCursor {
+			return bookCursor(recentBooks[i], nil)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		page.setLinks(r.URL)
+		if err := loadBookMetadata(db, recentBooks); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
@@ -98,6 +130,8 @@ func RootHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 			UserTemplateType: params,
 			Books:            recentBooks,
 			Title:            "Recent books",
+			Page:             page,
+			Campus:           campus,
 		})
 	}
 }
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// maxSavedSearches is the number of saved searches a user can have at once
+const maxSavedSearches = 20
+
+// SavedSearchesTemplateType is the type for the saved searches template
+type SavedSearchesTemplateType struct {
+	UserTemplateType
+	Searches      []SavedSearch
+	Notifications []Notification
+	Conditions    []BookCondition
+}
+
+// NotificationsPage is a page of notifications in JSON format
+type NotificationsPage struct {
+	Notifications []Notification `json:"notifications"`
+	Page          Page           `json:"page"`
+}
+
+// NewSavedSearch reads a saved search from POST parameters
+// A saved search has to have at least a query, a course or a maximum price
+func NewSavedSearch(values url.Values, userID int) (SavedSearch, error) {
+	var err error
+	search := SavedSearch{UserID: userID, Query: strings.TrimSpace(values.Get("query"))}
+
+	if courseID := values.Get("course_id"); courseID != "" {
+		if search.CourseID, err = strconv.Atoi(courseID); err != nil {
+			return search, errors.New("Course ID has to be a number")
+		}
+	}
+	if maxPrice := values.Get("max_price"); maxPrice != "" {
+		if search.MaxPrice, err = strconv.ParseFloat(maxPrice, 64); err != nil || search.MaxPrice < 0 {
+			return search, errors.New("Maximum price has to be a positive number")
+		}
+	}
+	if condition := values.Get("condition"); condition != "" {
+		if search.Condition, err = ParseBookCondition(condition); err != nil {
+			return search, err
+		}
+	}
+
+	if search.Query == "" && search.CourseID == 0 && search.MaxPrice == 0 {
+		return search, errors.New("A saved search needs a query, a course or a maximum price")
+	}
+	return search, nil
+}
+
+// BookSearch returns the book search with the same query and filters as the saved search
+func (s SavedSearch) BookSearch() BookSearch {
+	return BookSearch{
+		Query:      s.Query,
+		MaxPrice:   s.MaxPrice,
+		Conditions: s.Condition.AtLeast(),
+		CourseID:   s.CourseID,
+		Sort:       SortNewest,
+	}
+}
+
+// URL returns the url of the search results for the saved search
+func (s SavedSearch) URL() string {
+	values := url.Values{}
+	values.Set("query", s.Query)
+	if s.CourseID != 0 {
+		values.Set("course_id", strconv.Itoa(s.CourseID))
+	}
+	if s.MaxPrice != 0 {
+		values.Set("max_price", strconv.FormatFloat(s.MaxPrice, 'f', -1, 64))
+	}
+	for _, condition := range s.Condition.AtLeast() {
+		values.Add("condition", string(condition))
+	}
+	values.Set("sort", SortNewest)
+	return "/search_results?" + values.Encode()
+}
+
+// courseName returns the department and course number of a course like "Math 31A",
+// or an empty string if the course does not exist
+func courseName(courseDB gorm.DB, courseID int) string {
+	if courseID == 0 {
+		return ""
+	}
+	var course Course
+	if result := courseDB.First(&course, courseID); result.Error != nil {
+		return ""
+	}
+	return course.Department + " " + course.CourseID
+}
+
+// Description returns a readable summary of the saved search like `"calculus" for Math 31A up to $20.00`
+// The course is only named if CourseName has been loaded
+func (s SavedSearch) Description() string {
+	var parts []string
+	if s.Query != "" {
+		parts = append(parts, strconv.Quote(s.Query))
+	}
+	if s.CourseName != "" {
+		parts = append(parts, "for "+s.CourseName)
+	}
+	if s.MaxPrice != 0 {
+		parts = append(parts, fmt.Sprintf("up to $%.2f", s.MaxPrice))
+	}
+	if s.Condition != "" {
+		parts = append(parts, "in "+s.Condition.Label()+" condition or better")
+	}
+	return strings.Join(parts, " ")
+}
+
+// matchingSavedSearches returns the saved searches of other users that a new book matches
+// The course, price and condition are checked without searching, and each search query is only searched once
+// however many saved searches have it
+func matchingSavedSearches(db gorm.DB, book Book) ([]SavedSearch, error) {
+	var candidates []SavedSearch
+	result := db.Where("user_id <> ? AND (course_id = 0 OR course_id = ?) AND (max_price = 0 OR max_price >= ?)",
+		book.UserID, book.CourseID, book.Price).Find(&candidates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	queries := make(map[string]bool)
+	var matches []SavedSearch
+	for _, search := range candidates {
+		if !search.Condition.Accepts(book.Condition) {
+			continue
+		}
+		if search.Query != "" {
+			matched, ok := queries[search.Query]
+			if !ok {
+				var err error
+				if matched, err = bookMatchesQuery(db, search.Query, book.ID); err != nil {
+					return nil, err
+				}
+				queries[search.Query] = matched
+			}
+			if !matched {
+				continue
+			}
+		}
+		matches = append(matches, search)
+	}
+	return matches, nil
+}
+
+// notifySavedSearches notifies the owner of every saved search that a new book matches
+// The book has to be indexed first so that it can be found by the search query
+func notifySavedSearches(db gorm.DB, courseDB gorm.DB, book Book) {
+	searches, err := matchingSavedSearches(db, book)
+	if err != nil {
+		log.Println("Error matching saved searches for book", book.ID, err)
+		return
+	}
+
+	for _, search := range searches {
+		search.CourseName = courseName(courseDB, search.CourseID)
+		notification := Notification{
+			UserID:        search.UserID,
+			SavedSearchID: search.ID,
+			BookID:        book.ID,
+			Message: fmt.Sprintf("%s was listed for $%.2f and matches your saved search %s",
+				book.Title, book.Price, search.Description()),
+		}
+		notify(db, notification)
+	}
+}
+
+// findSavedSearches returns the saved searches of a user with the names of their courses, newest first
+func findSavedSearches(db gorm.DB, courseDB gorm.DB, userID int) ([]SavedSearch, error) {
+	searches := []SavedSearch{}
+	if result := db.Where("user_id = ?", userID).Order("created_at desc, id desc").Find(&searches); result.Error != nil {
+		return searches, result.Error
+	}
+	for i := range searches {
+		searches[i].CourseName = courseName(courseDB, searches[i].CourseID)
+	}
+	return searches, nil
+}
+
+// pageNotifications finds a page of the notifications of a user, newest first
+func pageNotifications(r *http.Request, db gorm.DB, userID int) (NotificationsPage, error) {
+	notificationsPage := NotificationsPage{Notifications: []Notification{}}
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		return notificationsPage, err
+	}
+
+	notificationsPage.Page, err = paginate(db.Where("user_id = ?", userID), pageRequest, newestFirst,
+		&notificationsPage.Notifications, func(i int) pageCursor {
+			notification := notificationsPage.Notifications[i]
+			return pageCursor{CreatedAt: notification.CreatedAt, ID: notification.ID}
+		})
+	notificationsPage.Page.setLinks(r.URL)
+	return notificationsPage, err
+}
+
+// SavedSearchesHandler is a route for /searches that shows the saved searches of the logged in user
+// and their most recent notifications
+func SavedSearchesHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your saved searches", http.StatusUnauthorized)
+		return
+	}
+
+	searches, err := findSavedSearches(db, courseDB, currentUser.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving saved searches", http.StatusInternalServerError)
+		return
+	}
+	notifications, err := pageNotifications(r, db, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/saved_searches.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, SavedSearchesTemplateType{
+		UserTemplateType: params,
+		Searches:         searches,
+		Notifications:    notifications.Notifications,
+		Conditions:       BookConditions,
+	})
+}
+
+// SavedSearchesJSONHandler is a route for /searches/json that returns the saved searches of the logged in user in JSON format
+func SavedSearchesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your saved searches", http.StatusUnauthorized)
+		return
+	}
+
+	searches, err := findSavedSearches(db, courseDB, currentUser.ID)
+	if err != nil {
+		http.Error(w, "Error retrieving saved searches", http.StatusInternalServerError)
+		return
+	}
+
+	searchesJSON, err := json.Marshal(searches)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(searchesJSON)
+}
+
+// NewSavedSearchHandler is a route for /searches that saves a search for the logged in user
+// POST parameters:
+// query string
+// course_id int
+// max_price float
+// condition string (the worst condition to be notified about, or empty for any condition)
+func NewSavedSearchHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to save a search", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	search, err := NewSavedSearch(r.PostForm, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var count int
+	if result := db.Model(&SavedSearch{}).Where("user_id = ?", currentUser.ID).Count(&count); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if count >= maxSavedSearches {
+		http.Error(w, fmt.Sprintf("You can only have %d saved searches", maxSavedSearches), http.StatusBadRequest)
+		return
+	}
+
+	if result := db.Create(&search); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/searches", http.StatusFound)
+}
+
+// DeleteSavedSearchHandler is a route for /searches/{id}/delete that deletes a saved search of the logged in user
+func DeleteSavedSearchHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to delete a saved search", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var search SavedSearch
+	if result := db.Where("id = ? AND user_id = ?", id, currentUser.ID).First(&search); result.Error != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if result := db.Delete(&search); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/searches", http.StatusFound)
+}
+
+// NotificationsJSONHandler is a route for /notifications/json that returns a page of the notifications
+// of the logged in user in JSON format, newest first
+// GET parameters:
+// cursor string
+// page_size int
+func NotificationsJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your notifications", http.StatusUnauthorized)
+		return
+	}
+
+	notifications, err := pageNotifications(r, db, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notificationsJSON, err := json.Marshal(notifications)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(notificationsJSON)
+}
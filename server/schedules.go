@@ -0,0 +1,333 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// maxScheduledCourses is the number of courses a user can have in their schedule for one term
+const maxScheduledCourses = 12
+
+// scheduleListingsLimit is the number of listings shown for each course of a schedule, cheapest first
+const scheduleListingsLimit = 8
+
+var (
+	errCourseScheduled = errors.New("That course is already in your schedule")
+	errCourseTerm      = errors.New("That course is not offered in that term")
+)
+
+// ScheduledCourseBooks is a course of a schedule with the listings of other users for it and the required
+// textbooks of the course that nobody is selling
+type ScheduledCourseBooks struct {
+	ScheduledCourse
+	ListingStats
+	Course      Course           `json:"course"`
+	Books       []Book           `json:"books"`       // listings for the course or for one of its textbooks
+	Unavailable []CourseTextbook `json:"unavailable"` // required textbooks without listings
+}
+
+// Schedule is the courses that a user is taking in a term with the books for them
+type Schedule struct {
+	Term    Term                   `json:"term"`
+	Courses []ScheduledCourseBooks `json:"courses"`
+}
+
+// ScheduleTemplateType is the type for the schedule template
+type ScheduleTemplateType struct {
+	UserTemplateType
+	Schedule
+	Terms   []Term
+	Query   string   // a course search for classes to add
+	Matches []Course // the courses that match Query
+}
+
+// NewScheduledCourse reads a course of a user's schedule from POST parameters
+// The course has to be in the catalog and not retired, and the term defaults to the term of the course
+// or else to the current term
+func NewScheduledCourse(courseDB gorm.DB, values url.Values, userID int) (ScheduledCourse, error) {
+	scheduled := ScheduledCourse{UserID: userID}
+	courseID, err := strconv.Atoi(values.Get("course_id"))
+	if err != nil {
+		return scheduled, errors.New("Course ID has to be a number")
+	}
+	var course Course
+	if result := courseDB.First(&course, courseID); result.Error != nil {
+		return scheduled, errCourseNotFound
+	}
+	if course.Retired {
+		return scheduled, errCourseRetired
+	}
+	scheduled.CourseID = course.ID
+
+	termID, err := ParseTerm(courseDB, values.Get("term"))
+	if err != nil {
+		return scheduled, err
+	}
+	if course.TermID != 0 {
+		if values.Get("term") != "" && termID != 0 && termID != course.TermID {
+			return scheduled, errCourseTerm
+		}
+		termID = course.TermID
+	}
+	scheduled.TermID = termID
+	return scheduled, nil
+}
+
+// addScheduledCourse saves a course to a user's schedule unless it is already in the schedule for that
+// term or the schedule is full
+func addScheduledCourse(db gorm.DB, scheduled ScheduledCourse) (ScheduledCourse, int, error) {
+	var count int
+	result := db.Model(&ScheduledCourse{}).Where("user_id = ? AND term_id = ? AND course_id = ?",
+		scheduled.UserID, scheduled.TermID, scheduled.CourseID).Count(&count)
+	if result.Error != nil {
+		return scheduled, http.StatusInternalServerError, result.Error
+	}
+	if count > 0 {
+		return scheduled, http.StatusBadRequest, errCourseScheduled
+	}
+	result = db.Model(&ScheduledCourse{}).Where("user_id = ? AND term_id = ?", scheduled.UserID, scheduled.TermID).Count(&count)
+	if result.Error != nil {
+		return scheduled, http.StatusInternalServerError, result.Error
+	}
+	if count >= maxScheduledCourses {
+		return scheduled, http.StatusBadRequest, fmt.Errorf("You can only have %d courses in your schedule for a term", maxScheduledCourses)
+	}
+
+	scheduled.CreatedAt = time.Now()
+	if result := db.Create(&scheduled); result.Error != nil {
+		return scheduled, http.StatusInternalServerError, result.Error
+	}
+	return scheduled, http.StatusOK, nil
+}
+
+// findScheduledCourseBooks looks up a scheduled course from the catalog with a page of the cheapest listings from
+// a book query for its department and course number at its campus or for one of its textbooks, leaving out the books of userID
+func findScheduledCourseBooks(db gorm.DB, courseDB gorm.DB, books *gorm.DB, scheduled ScheduledCourse, userID int) (ScheduledCourseBooks, error) {
+	courseBooks := ScheduledCourseBooks{ScheduledCourse: scheduled, Books: []Book{}, Unavailable: []CourseTextbook{}}
+	if result := courseDB.First(&courseBooks.Course, scheduled.CourseID); result.Error != nil {
+		return courseBooks, result.Error
+	}
+
+	// listings for the course under any of its professors at its campus count, like on the course page
+	// Sections of every term count since books are sold on after the term they were listed for
+	var sectionIDs []int
+	sections := filterActive(filterInstitution(courseDB.Model(&Course{}), courseBooks.Course.InstitutionID))
+	result := sections.Where("department = ? AND course_id = ? AND id <> ?", courseBooks.Course.Department,
+		courseBooks.Course.CourseID, scheduled.CourseID).Pluck("id", &sectionIDs)
+	if result.Error != nil {
+		return courseBooks, result.Error
+	}
+	sectionIDs = append(sectionIDs, scheduled.CourseID)
+	var textbooks []CourseTextbook
+	if result := courseDB.Where("course_id = ?", scheduled.CourseID).Find(&textbooks); result.Error != nil {
+		return courseBooks, result.Error
+	}
+	isbns := make([]string, len(textbooks))
+	for i, textbook := range textbooks {
+		isbns[i] = textbook.ISBN
+	}
+
+	others := books.Where("user_id <> ?", userID)
+	stats, err := countISBNListings(others, isbns)
+	if err != nil {
+		return courseBooks, err
+	}
+	for _, textbook := range textbooks {
+		if textbook.Required && stats[textbook.ISBN].ListingCount == 0 {
+			courseBooks.Unavailable = append(courseBooks.Unavailable, textbook)
+		}
+	}
+
+	listings := others.Where("course_id IN (?)", sectionIDs)
+	if len(isbns) > 0 {
+		listings = others.Where("course_id IN (?) OR i_s_b_n IN (?)", sectionIDs, isbns)
+	}
+	if result := listings.Model(&Book{}).Count(&courseBooks.ListingCount); result.Error != nil {
+		return courseBooks, result.Error
+	}
+	result = listings.Order("price asc, created_at asc").Limit(scheduleListingsLimit).Find(&courseBooks.Books)
+	if result.Error != nil {
+		return courseBooks, result.Error
+	}
+	if len(courseBooks.Books) > 0 {
+		courseBooks.LowestPrice = courseBooks.Books[0].Price
+	}
+	return courseBooks, loadBookMetadata(db, courseBooks.Books)
+}
+
+// findSchedule looks up the schedule of a user for a term, or for every term if termID is 0, with the
+// books for each course from a book query. Courses without a term are in the schedule of every term
+func findSchedule(db gorm.DB, courseDB gorm.DB, books *gorm.DB, userID int, termID int) (Schedule, error) {
+	schedule := Schedule{Courses: []ScheduledCourseBooks{}}
+	if termID != 0 {
+		if result := courseDB.First(&schedule.Term, termID); result.Error != nil {
+			return schedule, result.Error
+		}
+	}
+
+	var scheduled []ScheduledCourse
+	if result := filterTerm(db.Where("user_id = ?", userID), termID).Order("id").Find(&scheduled); result.Error != nil {
+		return schedule, result.Error
+	}
+	for _, course := range scheduled {
+		courseBooks, err := findScheduledCourseBooks(db, courseDB, books, course, userID)
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Courses = append(schedule.Courses, courseBooks)
+	}
+	return schedule, nil
+}
+
+// scheduleRequest loads the schedule of the logged in user for the term parameter of a request
+func scheduleRequest(r *http.Request, db gorm.DB, courseDB gorm.DB, userID int) (Schedule, int, error) {
+	termID, err := ParseTerm(courseDB, r.URL.Query().Get("term"))
+	if err != nil {
+		return Schedule{}, http.StatusBadRequest, err
+	}
+	schedule, err := findSchedule(db, courseDB, statusQuery(r, db), userID, termID)
+	if err != nil {
+		return schedule, http.StatusInternalServerError, err
+	}
+	return schedule, http.StatusOK, nil
+}
+
+// ScheduleHandler is a route for /schedule that shows "Books for my classes": the courses of the logged in
+// user's schedule with the listings for each of them and their required textbooks that nobody is selling
+// It is also the page that logged in users see at /
+// GET parameters:
+// term string (term ID, "all" for every term, or empty for the current term)
+// query string (a course search like "cs31 smallberg" for courses to add)
+// status string (the status of the listings, available by default)
+func ScheduleHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your schedule", http.StatusUnauthorized)
+		return
+	}
+
+	schedule, status, err := scheduleRequest(r, db, courseDB, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	terms, err := FindTerms(courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query := r.URL.Query().Get("query")
+	matches := []Course{}
+	if query != "" {
+		if matches, err = SearchCourseQuery(query, schedule.Term.ID, newCampusScope(r, courseDB).InstitutionID(), courseDB); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/schedule.html")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.Execute(w, ScheduleTemplateType{
+		UserTemplateType: params,
+		Schedule:         schedule,
+		Terms:            terms,
+		Query:            query,
+		Matches:          matches,
+	})
+}
+
+// ScheduleJSONHandler is a route for /schedule/json that returns the schedule of the logged in user
+// with the books for each course in JSON format
+// GET parameters:
+// term string (term ID, "all" for every term, or empty for the current term)
+// status string (the status of the listings, available by default)
+func ScheduleJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to view your schedule", http.StatusUnauthorized)
+		return
+	}
+
+	schedule, status, err := scheduleRequest(r, db, courseDB, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	scheduleJSON, err := json.Marshal(schedule)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(scheduleJSON)
+}
+
+// AddScheduledCourseHandler is a route for /schedule that adds a course to the schedule of the logged in user
+// POST parameters:
+// course_id int
+// term string (term ID, or empty for the term of the course or else the current term)
+func AddScheduledCourseHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to change your schedule", http.StatusUnauthorized)
+		return
+	}
+
+	r.ParseForm()
+	scheduled, err := NewScheduledCourse(courseDB, r.PostForm, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scheduled, status, err := addScheduledCourse(db, scheduled)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	// the schedule of the course's term is shown, which is not the current term for courses of the next term
+	redirect := "/schedule"
+	if scheduled.TermID != 0 {
+		redirect += "?term=" + strconv.Itoa(scheduled.TermID)
+	}
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// DeleteScheduledCourseHandler is a route for /schedule/{id}/delete that removes a course from the schedule of the logged in user
+func DeleteScheduledCourseHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to change your schedule", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var scheduled ScheduledCourse
+	if result := db.Where("id = ? AND user_id = ?", id, currentUser.ID).First(&scheduled); result.Error != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if result := db.Delete(&scheduled); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/schedule", http.StatusFound)
+}
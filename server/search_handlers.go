@@ -5,28 +5,47 @@ import (
 	"errors"
 	"github.com/jinzhu/gorm"
 	"net/http"
+	"strings"
 )
 
 // SearchCourse is a helper function that takes in a search type, department, course id, and professor and returns all courses that match
+// Only courses of the term with termID and courses without a term match, unless termID is 0, and the same goes for institutionID
+// Retired courses never match
 // search type can be:
-// department (when searching for department)
+// department (when searching for department, including departments that the department is an alias of)
 // course (when searching for course)
-// professor (when searching for professor)
-func SearchCourse(searchType string, department string, courseID string, professor string, db gorm.DB) ([]Course, error) {
+// professor (when searching for professor, by words that start their first or middle name or are in their last name)
+func SearchCourse(searchType string, department string, courseID string, professor string, termID int, institutionID int,
+	db gorm.DB) ([]Course, error) {
 	var coursesQuery *gorm.DB
 	var searchCourses []Course
+	courseDB := db
+	db = *filterActive(filterInstitution(filterTerm(&db, termID), institutionID))
 
 	switch searchType {
 	case "department":
-		coursesQuery = db.Select("DISTINCT department").Where("department LIKE ?", "%"+department+"%").Limit(10).Find(&searchCourses)
+		// departments that the text is an alias or abbreviation of come first, like "Computer Science" for "cs"
+		resolver, err := newDepartmentResolver(courseDB, institutionID)
+		if err != nil {
+			return []Course{}, err
+		}
+		for _, name := range resolver.resolve(courseQueryWords(department)) {
+			searchCourses = append(searchCourses, Course{Department: name})
+		}
+		var likeCourses []Course
+		coursesQuery = db.Select("DISTINCT department").Where("department LIKE ?", "%"+department+"%").Limit(10).Find(&likeCourses)
+		for _, course := range likeCourses {
+			if len(searchCourses) < 10 && !hasDepartment(searchCourses, course.Department) {
+				searchCourses = append(searchCourses, course)
+			}
+		}
 	case "course":
 		coursesQuery = db.Select("DISTINCT course_id").Where("department LIKE ? AND course_id LIKE ?", department, "%"+courseID+"%").
 			Limit(10).Find(&searchCourses)
 	case "professor":
-		coursesQuery = db.Where(`department LIKE ? 
-						   AND course_id LIKE ? 
-						   AND professor LIKE ?`,
-			department, courseID, "%"+professor+"%").
+		coursesQuery = filterProfessorWords(db.Where(`department LIKE ?
+						   AND course_id LIKE ?`,
+			department, courseID), courseQueryWords(professor)).
 			Limit(10).Find(&searchCourses)
 	default:
 		return []Course{}, errors.New("No search type")
@@ -38,41 +57,153 @@ func SearchCourse(searchType string, department string, courseID string, profess
 	return searchCourses, nil
 }
 
-// SearchResultsJSONHandler is a route for /search_results.json?query= that returns an array of Books that match the search query in JSON format
-func SearchResultsJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
-	query := r.URL.Query().Get("query")
+// hasDepartment returns true if one of the courses is in a department
+func hasDepartment(courses []Course, department string) bool {
+	for _, course := range courses {
+		if course.Department == department {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTitlesLimit is the number of titles that the search typeahead suggests
+const searchTitlesLimit = 10
+
+// searchTitles returns up to searchTitlesLimit Books with only the distinct titles of the books from a book query
+// that match a search query, most relevant first. Misspelled queries are corrected like in the book search
+func searchTitles(db *gorm.DB, query string) ([]Book, error) {
+	titles := []Book{}
+	matching, ranks, err := bookSearchQuery(query, db)
+	if err != nil {
+		return titles, err
+	}
+	order := "books.title"
+	if ranks != nil {
+		order = rankExpression(ranks)
+	}
+	rows, err := matching.Model(&Book{}).Select("books.title").Order(order).Rows()
+	if err != nil {
+		return titles, err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() && len(titles) < searchTitlesLimit {
+		var title string
+		if err := rows.Scan(&title); err != nil {
+			return titles, err
+		}
+		if !seen[title] {
+			seen[title] = true
+			titles = append(titles, Book{Title: title})
+		}
+	}
+	return titles, rows.Err()
+}
+
+// SearchTitlesJSONHandler is a route for /search_titles.json?query= that returns up to 10 Books with only the
+// distinct titles of the books that match the search query in JSON format, which is light enough for the
+// search typeahead
+// GET parameters:
+// query string
+// status string (the status of the listings, available by default)
+// campus string ("all" for every campus, or empty for the campus of the logged in user)
+func SearchTitlesJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	query := strings.TrimSpace(r.URL.Query().Get("query"))
 	if len(query) == 0 {
 		http.NotFound(w, r)
 		return
 	}
 
-	var searchBooks []Book
-	if result := db.Select("DISTINCT title").Where("title LIKE ?", "%"+query+"%").Limit(10).Find(&searchBooks); result.Error != nil {
+	books := filterInstitution(statusQuery(r, db), newCampusScope(r, courseDB).InstitutionID())
+	titles, err := searchTitles(books, query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	titlesJSON, err := json.Marshal(titles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(titlesJSON)
+}
+
+// SearchResultsJSONHandler is a route for /search_results.json?query= that returns the Books that match the search query
+// and the facet counts of the search in JSON format
+// GET parameters are the same as ParseBookSearch, plus status, cursor, page_size and campus ("all" for every campus)
+func SearchResultsJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	search, err := ParseBookSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.Empty() {
 		http.NotFound(w, r)
 		return
 	}
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	campus := newCampusScope(r, courseDB)
+	search.InstitutionID = campus.InstitutionID()
 
-	searchBooksJSON, err := json.Marshal(searchBooks)
+	searchResults, err := searchBooks(statusQuery(r, db), courseDB, search, pageRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	searchResults.Page.setLinks(r.URL)
+	if err := loadBookMetadata(db, searchResults.Books); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	searchResultsJSON, err := json.Marshal(searchResults)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.Write(searchBooksJSON)
+	w.Write(searchResultsJSON)
 }
 
 // SearchResultsHandler is a route for /search_results?query= that displays a search page with Books that match the search query
-func SearchResultsHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
-	query := r.URL.Query().Get("query")
-	if len(query) == 0 {
+// and filters with the facet counts of the search
+// GET parameters are the same as ParseBookSearch, plus status, cursor, page_size and campus ("all" for every campus)
+func SearchResultsHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	search, err := ParseBookSearch(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if search.Empty() {
 		http.NotFound(w, r)
 		return
 	}
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	campus := newCampusScope(r, courseDB)
+	search.InstitutionID = campus.InstitutionID()
 
-	var searchBooks []Book
-	if result := db.Where("title LIKE ?", "%"+query+"%").Limit(10).Find(&searchBooks); result.Error != nil {
-		http.NotFound(w, r)
+	searchResults, err := searchBooks(statusQuery(r, db), courseDB, search, pageRequest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	searchResults.Page.setLinks(r.URL)
+	if err := loadBookMetadata(db, searchResults.Books); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -84,24 +215,41 @@ func SearchResultsHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 
 	t.Execute(w, ManyBookTemplateType{
 		UserTemplateType: params,
-		Books:            searchBooks,
+		Books:            searchResults.Books,
 		Title:            "Search Results",
+		SearchResults:    &searchResults,
+		Sorts:            BookSorts,
+		Page:             searchResults.Page,
+		Campus:           campus,
 	})
 }
 
 // CourseSearchHandler is a route for /course_search.json?department=&course_id=&professor= that returns an array of Courses that match the queries
 // GET parameters:
-// type string (type of search (department, course, professor))
+// type string (type of search (department, course, professor, or query for a free text search like "cs31 smallberg"))
+// query string (for the query type)
 // department string
 // course_id string
 // professor string
+// term string (term ID, "all" for every term, or empty for the current term)
+// campus string ("all" for every campus, or empty for the campus of the logged in user)
 func CourseSearchHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
 	typeSearch := r.URL.Query().Get("type")
 	department := r.URL.Query().Get("department")
 	courseID := r.URL.Query().Get("course_id")
 	professor := r.URL.Query().Get("professor")
+	termID, err := ParseTerm(db, r.URL.Query().Get("term"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	searchCourses, err := SearchCourse(typeSearch, department, courseID, professor, db)
+	var searchCourses []Course
+	if typeSearch == "query" {
+		searchCourses, err = SearchCourseQuery(r.URL.Query().Get("query"), termID, newCampusScope(r, db).InstitutionID(), db)
+	} else {
+		searchCourses, err = SearchCourse(typeSearch, department, courseID, professor, termID, newCampusScope(r, db).InstitutionID(), db)
+	}
 	if err != nil {
 		http.NotFound(w, r)
 		return
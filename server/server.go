@@ -8,11 +8,31 @@ import (
 	"github.com/justinas/nosurf"
 	_ "github.com/mattn/go-sqlite3"
 	"net/http"
+	"sync"
 	"time"
 )
 
+var (
+	// startHub starts the websocket hub once even if the routes are set up more than once
+	startHub sync.Once
+
+	// startNewBookMatcher starts matching new books against saved searches and wanted books once
+	startNewBookMatcher sync.Once
+)
+
 type DBInjectFunc func(func(http.ResponseWriter, *http.Request, gorm.DB), gorm.DB) http.Handler
 
+// CourseDBHandlerFunc is a http handler on the main database that also needs to look up courses
+// in the course database
+type CourseDBHandlerFunc func(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB)
+
+// withCourseDB injects the course database into a handler so that it can be injected with DBInject
+func withCourseDB(fn CourseDBHandlerFunc, courseDB gorm.DB) func(http.ResponseWriter, *http.Request, gorm.DB) {
+	return func(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+		fn(w, r, db, courseDB)
+	}
+}
+
 // DBInject injects a database object into a http handler with the database object parameter and
 // turns it into a standard http handler
 func DBInject(requestsPerMinute int, testing bool) DBInjectFunc {
@@ -41,28 +61,78 @@ func Routes(db gorm.DB, courseDB gorm.DB, requestsPerMinute int, testing bool) *
 	InitSessions("bookcycle")
 
 	// run websocket hub and set websocket handler to /ws route
-	go h.run(db)
+	startHub.Do(func() { go h.run(db) })
+	startNewBookMatcher.Do(func() { go matchNewBooks(db, courseDB) })
 
 	DBInject := DBInject(requestsPerMinute, testing)
+	CourseDBInject := func(fn CourseDBHandlerFunc) http.Handler {
+		return DBInject(withCourseDB(fn, courseDB), db)
+	}
 
 	// Define routes (route handlers are in route_handlers.go)
 	r := mux.NewRouter()
 	r.HandleFunc("/ws", ServeWs)
-	r.Handle("/", DBInject(RootHandler, db))
+	r.Handle("/", CourseDBInject(RootHandler))
 	r.Methods("POST").Path("/login").Handler(DBInject(LoginHandler, db))
 	r.Methods("GET").Path("/logout").HandlerFunc(LogoutHandler)
 	r.Methods("GET", "POST").Path("/users/new").Handler(DBInject(NewUserNewTemplate().Handler, db))
-	r.Methods("GET", "POST").Path("/users/edit").Handler(DBInject(NewUserEditTemplate().Handler, db))
+	r.Methods("GET").Path("/users/verify").Handler(CourseDBInject(VerifyEmailHandler))
+	r.Methods("POST").Path("/users/verify").Handler(DBInject(ResendVerificationHandler, db))
+	r.Methods("GET", "POST").Path("/users/edit").Handler(DBInject(NewUserEditTemplate(courseDB).Handler, db))
 	r.Methods("GET").Path("/users/{id}").Handler(DBInject(NewUserViewTemplate().Handler, db))
 	r.Methods("GET").Path("/users/{id}/json").Handler(DBInject(UserJSONHandler, db))
-	r.Methods("GET", "POST").Path("/books/new").Handler(DBInject(NewBookHandler, db))
+	r.Methods("GET", "POST").Path("/books/new").Handler(CourseDBInject(NewBookHandler))
 	r.Methods("GET").Path("/books").Handler(DBInject(ShowBooksHandler, db))
-	r.Methods("GET").Path("/books/{id}/delete").Handler(DBInject(DeleteBookHandler, db))
-	r.Methods("GET").Path("/books/{id}").Handler(DBInject(BookHandler, db))
-	r.Methods("GET").Path("/search_results.json").Handler(DBInject(SearchResultsJSONHandler, db))
-	r.Methods("GET").Path("/courses/{id}/json").Handler(DBInject(CoursesJSONHandler, courseDB))
+	r.Methods("GET", "POST").Path("/books/import").Handler(CourseDBInject(ImportBooksHandler))
+	r.Methods("POST").Path("/books/import/json").Handler(CourseDBInject(ImportBooksJSONHandler))
+	r.Methods("GET").Path("/books/trash").Handler(DBInject(TrashHandler, db))
+	r.Methods("POST").Path("/books/{id}/delete").Handler(DBInject(DeleteBookHandler, db))
+	r.Methods("POST").Path("/books/{id}/restore").Handler(DBInject(RestoreBookHandler, db))
+	r.Methods("POST").Path("/books/{id}/purge").Handler(DBInject(PurgeBookHandler, db))
+	r.Methods("GET", "POST").Path("/books/{id}/edit").Handler(CourseDBInject(EditBookHandler))
+	r.Methods("POST").Path("/books/{id}/status").Handler(DBInject(BookStatusHandler, db))
+	r.Methods("POST").Path("/books/{id}/renew").Handler(DBInject(RenewBookHandler, db))
+	r.Methods("GET").Path("/books/{id}/json").Handler(DBInject(BookJSONHandler, db))
+	r.Methods("GET").Path("/books/{id}").Handler(CourseDBInject(BookHandler))
+	r.Methods("GET").Path("/isbn/{isbn}/json").Handler(DBInject(MetadataJSONHandler, db))
+	r.Methods("GET").Path("/isbn/{isbn}/stats/json").Handler(DBInject(ISBNStatsJSONHandler, db))
+	r.Methods("GET").Path("/isbn/{isbn}/stats").Handler(DBInject(ISBNStatsHandler, db))
+	r.Methods("GET").Path("/search_results.json").Handler(CourseDBInject(SearchResultsJSONHandler))
+	r.Methods("GET").Path("/search_titles.json").Handler(CourseDBInject(SearchTitlesJSONHandler))
+	r.Methods("GET").Path("/admin/courses").Handler(CourseDBInject(AdminCoursesHandler))
+	r.Methods("GET").Path("/admin/courses/json").Handler(CourseDBInject(AdminCoursesJSONHandler))
+	r.Methods("GET").Path("/admin/courses/new").Handler(CourseDBInject(AdminCourseHandler))
+	r.Methods("POST").Path("/admin/courses/new").Handler(CourseDBInject(CourseChangeHandler(CourseActionCreate)))
+	r.Methods("POST").Path("/admin/courses/new/json").Handler(CourseDBInject(CourseChangeJSONHandler(CourseActionCreate)))
+	r.Methods("GET").Path("/admin/courses/{id}/edit").Handler(CourseDBInject(AdminCourseHandler))
+	r.Methods("POST").Path("/admin/courses/{id}/edit").Handler(CourseDBInject(CourseChangeHandler(CourseActionEdit)))
+	r.Methods("POST").Path("/admin/courses/{id}/edit/json").Handler(CourseDBInject(CourseChangeJSONHandler(CourseActionEdit)))
+	r.Methods("POST").Path("/admin/courses/{id}/merge").Handler(CourseDBInject(CourseChangeHandler(CourseActionMerge)))
+	r.Methods("POST").Path("/admin/courses/{id}/merge/json").Handler(CourseDBInject(CourseChangeJSONHandler(CourseActionMerge)))
+	r.Methods("POST").Path("/admin/courses/{id}/retire").Handler(CourseDBInject(CourseChangeHandler(CourseActionRetire)))
+	r.Methods("POST").Path("/admin/courses/{id}/retire/json").Handler(CourseDBInject(CourseChangeJSONHandler(CourseActionRetire)))
+	r.Methods("GET").Path("/courses/{id}/textbooks/json").Handler(CourseDBInject(CourseTextbooksJSONHandler))
+	r.Methods("GET").Path("/courses/{id}/textbooks").Handler(CourseDBInject(CourseTextbooksHandler))
+	r.Methods("GET").Path("/courses/{id}/json").Handler(CourseDBInject(CoursesJSONHandler))
+	r.Methods("GET").Path("/courses/{id}").Handler(CourseDBInject(CourseHandler))
+	r.Methods("GET").Path("/departments/{dept}/json").Handler(CourseDBInject(DepartmentJSONHandler))
+	r.Methods("GET").Path("/departments/{dept}").Handler(CourseDBInject(DepartmentHandler))
 	r.Methods("GET").Path("/course_search.json").Handler(DBInject(CourseSearchHandler, courseDB))
-	r.Methods("GET").Path("/search_results").Handler(DBInject(SearchResultsHandler, db))
+	r.Methods("GET").Path("/search_results").Handler(CourseDBInject(SearchResultsHandler))
+	r.Methods("GET").Path("/wanted").Handler(CourseDBInject(WantedBooksHandler))
+	r.Methods("GET").Path("/wanted/json").Handler(CourseDBInject(WantedBooksJSONHandler))
+	r.Methods("GET", "POST").Path("/wanted/new").Handler(DBInject(NewWantedBookHandler, db))
+	r.Methods("GET").Path("/wanted/{id}").Handler(CourseDBInject(WantedBookHandler))
+	r.Methods("POST").Path("/wanted/{id}/delete").Handler(DBInject(DeleteWantedBookHandler, db))
+	r.Methods("GET").Path("/schedule").Handler(CourseDBInject(ScheduleHandler))
+	r.Methods("POST").Path("/schedule").Handler(CourseDBInject(AddScheduledCourseHandler))
+	r.Methods("GET").Path("/schedule/json").Handler(CourseDBInject(ScheduleJSONHandler))
+	r.Methods("POST").Path("/schedule/{id}/delete").Handler(DBInject(DeleteScheduledCourseHandler, db))
+	r.Methods("GET").Path("/searches").Handler(CourseDBInject(SavedSearchesHandler))
+	r.Methods("POST").Path("/searches").Handler(DBInject(NewSavedSearchHandler, db))
+	r.Methods("GET").Path("/searches/json").Handler(CourseDBInject(SavedSearchesJSONHandler))
+	r.Methods("POST").Path("/searches/{id}/delete").Handler(DBInject(DeleteSavedSearchHandler, db))
+	r.Methods("GET").Path("/notifications/json").Handler(DBInject(NotificationsJSONHandler, db))
 	r.Methods("GET").Path("/messages").Handler(DBInject(MessagesHandler, db))
 	r.Methods("GET").Path("/past_messages/{id}").Handler(DBInject(PastMessagesHandler, db))
 	r.Methods("GET").Path("/message/{id}").Handler(DBInject(ChatHandler, db))
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// AllTerms is the term parameter that searches the courses of every term
+const AllTerms = "all"
+
+// FindTerms returns every term in the course database, newest first
+func FindTerms(courseDB gorm.DB) ([]Term, error) {
+	terms := []Term{}
+	result := courseDB.Order("starts_on desc, id desc").Find(&terms)
+	return terms, result.Error
+}
+
+// CurrentTerm returns the term that is in session at a time
+// Between terms it is the term that started last, and it is an empty term if there are no terms yet
+func CurrentTerm(courseDB gorm.DB, now time.Time) (Term, error) {
+	var term Term
+	result := courseDB.Where("starts_on <= ?", now).Order("starts_on desc, id desc").First(&term)
+	if result.RecordNotFound() {
+		return Term{}, nil
+	}
+	return term, result.Error
+}
+
+// ParseTerm returns the ID of the term that a term parameter names
+// An empty parameter is the current term and AllTerms is 0, which does not filter by term
+func ParseTerm(courseDB gorm.DB, param string) (int, error) {
+	switch param {
+	case "":
+		term, err := CurrentTerm(courseDB, time.Now())
+		return term.ID, err
+	case AllTerms:
+		return 0, nil
+	}
+
+	termID, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, errors.New("Term has to be a number or " + AllTerms)
+	}
+	var term Term
+	if result := courseDB.First(&term, termID); result.Error != nil {
+		return 0, errors.New("Term does not exist")
+	}
+	return term.ID, nil
+}
+
+// filterTerm limits a course query to the courses of a term and the courses that are not tied to a term
+// Courses from before terms were added have a NULL term_id, which is the same as no term
+func filterTerm(db *gorm.DB, termID int) *gorm.DB {
+	if termID == 0 {
+		return db
+	}
+	return db.Where("term_id = ? OR term_id = 0 OR term_id IS NULL", termID)
+}
+
+// termName returns the name of a term or an empty string if it does not exist
+func termName(courseDB gorm.DB, termID int) string {
+	if termID == 0 {
+		return ""
+	}
+	var term Term
+	if result := courseDB.First(&term, termID); result.Error != nil {
+		return ""
+	}
+	return term.Name
+}
+
+// TermByName looks up a term by name, or returns a new term with the given dates that still has
+// to be saved if it does not exist
+func TermByName(courseDB gorm.DB, name string, startsOn, endsOn time.Time) (Term, error) {
+	var term Term
+	result := courseDB.Where("name = ?", name).First(&term)
+	if result.Error == nil {
+		return term, nil
+	}
+	if !result.RecordNotFound() {
+		return term, result.Error
+	}
+
+	if startsOn.IsZero() || endsOn.IsZero() {
+		return term, errors.New("Term " + strconv.Quote(name) + " does not exist, give its start and end dates to create it")
+	}
+	if endsOn.Before(startsOn) {
+		return term, errors.New("A term has to end after it starts")
+	}
+	return Term{Name: name, StartsOn: startsOn, EndsOn: endsOn}, nil
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Adoption list fields that a column mapping can map a column to, along with the course fields
+// The professor is optional and rows without one are adopted by every professor of the course
+const (
+	TextbookFieldISBN     = "isbn"
+	TextbookFieldTitle    = "title"
+	TextbookFieldRequired = "required"
+)
+
+// TextbookFields lists every field of an adoption list row
+var TextbookFields = append(append([]string{}, CourseFields...), TextbookFieldISBN, TextbookFieldTitle, TextbookFieldRequired)
+
+// TextbookChange is an adoption list row that added or changed a textbook of a course
+// Old is the textbook before the import and is empty for created textbooks
+type TextbookChange struct {
+	Row    int            `json:"row"`
+	Course Course         `json:"course"`
+	Old    CourseTextbook `json:"old"`
+	New    CourseTextbook `json:"new"`
+}
+
+// TextbookImportReport is the result of importing a bookstore adoption list
+type TextbookImportReport struct {
+	Created   []TextbookChange   `json:"created"`
+	Updated   []TextbookChange   `json:"updated"`
+	Unchanged int                `json:"unchanged"`
+	Skipped   []SkippedCourseRow `json:"skipped"`
+	DryRun    bool               `json:"dry_run"`
+}
+
+// ParseTextbookColumnMapping reads a column mapping like "isbn=ISBN,required=Req/Opt,course_id=Course"
+func ParseTextbookColumnMapping(s string) (CourseColumnMapping, error) {
+	return parseColumnMapping(s, TextbookFields)
+}
+
+// parseRequired reads the required column of an adoption list, which is required when it is empty
+func parseRequired(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "required", "req", "r", "yes", "y", "true", "1":
+		return true, nil
+	case "optional", "opt", "o", "recommended", "rec", "no", "n", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not required or optional", value)
+}
+
+// sectionKey is the key that catalog rows are matched to adoption list rows by, ignoring case
+func sectionKey(department, courseID string) string {
+	return strings.ToLower(department + "\x00" + courseID)
+}
+
+// ImportTextbooks reads a CSV or JSON bookstore adoption list and upserts the textbooks of every
+// course it names by ISBN. Rows are matched to the courses that are not retired of a campus and a term, or of every campus or
+// term if they are empty, by department and course number and by professor when the row has one. Rows
+// with an invalid ISBN or without a matching course are skipped. Nothing is saved if dryRun is set
+func ImportTextbooks(courseDB gorm.DB, file io.Reader, format string, mapping CourseColumnMapping, institution Institution, term Term,
+	dryRun bool) (TextbookImportReport, error) {
+	report := TextbookImportReport{
+		Created: []TextbookChange{},
+		Updated: []TextbookChange{},
+		Skipped: []SkippedCourseRow{},
+		DryRun:  dryRun,
+	}
+
+	rows, err := readCourseRecords(file, format)
+	if err != nil {
+		return report, err
+	}
+
+	var courses []Course
+	if result := filterActive(filterInstitution(filterTerm(&courseDB, term.ID), institution.ID)).Find(&courses); result.Error != nil {
+		return report, result.Error
+	}
+	sections := map[string][]Course{}
+	for _, course := range courses {
+		key := sectionKey(course.Department, course.CourseID)
+		sections[key] = append(sections[key], course)
+	}
+
+	var existing []CourseTextbook
+	if result := courseDB.Find(&existing); result.Error != nil {
+		return report, result.Error
+	}
+	textbooks := make(map[string]CourseTextbook, len(existing))
+	for _, textbook := range existing {
+		textbooks[fmt.Sprintf("%d\x00%s", textbook.CourseID, textbook.ISBN)] = textbook
+	}
+
+	firstRow := 1
+	if format == "csv" {
+		firstRow = 2
+	}
+	seen := map[string]int{}
+	for i, row := range rows {
+		rowNumber := firstRow + i
+		skip := func(reason string) {
+			report.Skipped = append(report.Skipped, SkippedCourseRow{Row: rowNumber, Reason: reason})
+		}
+
+		department := cleanCourseField(row[mapping.column(CourseFieldDepartment)])
+		courseID := cleanCourseField(row[mapping.column(CourseFieldCourseID)])
+		professor := catalogRowProfessor(row, mapping)
+		originalISBN := strings.TrimSpace(row[mapping.column(TextbookFieldISBN)])
+		isbn, err := NormalizeISBN(originalISBN)
+		if err != nil {
+			skip(err.Error())
+			continue
+		}
+		required, err := parseRequired(row[mapping.column(TextbookFieldRequired)])
+		if err != nil {
+			skip(err.Error())
+			continue
+		}
+
+		var matched []Course
+		for _, section := range sections[sectionKey(department, courseID)] {
+			if professor == "" || strings.EqualFold(section.Professor, professor) {
+				matched = append(matched, section)
+			}
+		}
+		if len(matched) == 0 {
+			skip(strings.TrimSpace(fmt.Sprintf("No course %s %s %s", department, courseID, professor)))
+			continue
+		}
+
+		adopted := false
+		for _, section := range matched {
+			key := fmt.Sprintf("%d\x00%s", section.ID, isbn)
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = rowNumber
+			adopted = true
+
+			textbook := CourseTextbook{
+				CourseID:     section.ID,
+				ISBN:         isbn,
+				OriginalISBN: originalISBN,
+				Title:        cleanCourseField(row[mapping.column(TextbookFieldTitle)]),
+				Required:     required,
+			}
+			old, ok := textbooks[key]
+			switch {
+			case !ok:
+				report.Created = append(report.Created, TextbookChange{Row: rowNumber, Course: section, New: textbook})
+			case old.Required != textbook.Required || (textbook.Title != "" && old.Title != textbook.Title):
+				updated := old
+				updated.Required = textbook.Required
+				if textbook.Title != "" {
+					updated.Title = textbook.Title
+				}
+				report.Updated = append(report.Updated, TextbookChange{Row: rowNumber, Course: section, Old: old, New: updated})
+			default:
+				report.Unchanged++
+			}
+		}
+		if !adopted {
+			skip(fmt.Sprintf("Same textbook as row %d", seen[fmt.Sprintf("%d\x00%s", matched[0].ID, isbn)]))
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	tx := courseDB.Begin()
+	now := time.Now()
+	for i := range report.Created {
+		textbook := &report.Created[i].New
+		textbook.CreatedAt, textbook.UpdatedAt = now, now
+		if result := tx.Create(textbook); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	for i := range report.Updated {
+		textbook := &report.Updated[i].New
+		textbook.UpdatedAt = now
+		if result := tx.Save(textbook); result.Error != nil {
+			tx.Rollback()
+			return report, result.Error
+		}
+	}
+	return report, tx.Commit().Error
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"html/template"
+	"log"
 	"net/http"
+	"strings"
 
 	"github.com/jinzhu/gorm"
 	"github.com/justinas/nosurf"
@@ -102,21 +104,29 @@ func (u *UserNewTemplate) postRoute(w http.ResponseWriter, r *http.Request, db g
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	// the campus is only assigned once the email is verified
+	newUser.InstitutionID = 0
+	newUser.EmailVerified = false
 	if result := db.Create(&newUser); result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusUnauthorized)
 		return
 	}
+	// a failed email is not fatal since the user can ask for another one
+	if err := sendVerificationEmail(r, db, newUser); err != nil {
+		log.Println("Error sending verification email to", newUser.Email, err)
+	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 // UserEditTemplate is a user handler for route /users/edit
 type UserEditTemplate struct {
 	UserHandlerTemplate
+	courseDB gorm.DB // where the campus of a verified email is looked up
 }
 
 // NewUserEditTemplate constructs a new UserEditTemplate
-func NewUserEditTemplate() UserEditTemplate {
-	b := UserEditTemplate{UserHandlerTemplate{}}
+func NewUserEditTemplate(courseDB gorm.DB) UserEditTemplate {
+	b := UserEditTemplate{UserHandlerTemplate{}, courseDB}
 	b.userFactory = NewMuxUserFactory()
 	b.i = &b
 	return b
@@ -125,7 +135,16 @@ func NewUserEditTemplate() UserEditTemplate {
 func (u UserEditTemplate) isDisabled() bool { return false }
 
 func (u UserEditTemplate) user(r *http.Request, db gorm.DB) (User, error) {
-	return CurrentUser(r)
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		return currentUser, err
+	}
+	// the email may have been verified since the user logged in
+	var user User
+	if result := db.First(&user, currentUser.ID); result.Error != nil {
+		return currentUser, result.Error
+	}
+	return user, nil
 }
 
 func (u UserEditTemplate) postRoute(w http.ResponseWriter, r *http.Request, db gorm.DB) {
@@ -139,16 +158,46 @@ func (u UserEditTemplate) postRoute(w http.ResponseWriter, r *http.Request, db g
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	var savedUser User
+	if result := db.First(&savedUser, currentUser.ID); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusUnauthorized)
+		return
+	}
 	if result := db.Model(&currentUser).Updates(editedUser); result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusUnauthorized)
 		return
 	}
+	// a changed email has to be verified again before the user is put on its campus
+	emailChanged := editedUser.Email != "" && !strings.EqualFold(editedUser.Email, savedUser.Email)
+	if emailChanged {
+		result := db.Model(&currentUser).UpdateColumns(map[string]interface{}{"email_verified": false, "institution_id": 0})
+		if result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else if savedUser.EmailVerified {
+		// the campus is updated separately since Updates skips the zero ID of an email that is not from a campus
+		institution, err := InstitutionForEmail(u.courseDB, savedUser.Email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if result := db.Model(&currentUser).UpdateColumn("institution_id", institution.ID); result.Error != nil {
+			http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	// get edited user from database
 	var newUser User
 	if result := db.First(&newUser, currentUser.ID); result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusUnauthorized)
 		return
 	}
+	if emailChanged {
+		if err := sendVerificationEmail(r, db, newUser); err != nil {
+			log.Println("Error sending verification email to", newUser.Email, err)
+		}
+	}
 	if err = SetUserInSession(r, w, newUser); err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
@@ -0,0 +1,399 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jinzhu/gorm"
+)
+
+// WantedBookSearch is a search of the wanted books that sellers use to find buyers
+// Zero values mean that the filter is not applied
+type WantedBookSearch struct {
+	Query      string  `json:"query"`
+	ISBN       string  `json:"isbn,omitempty"`
+	CourseID   int     `json:"course_id,omitempty"`
+	Department string  `json:"department,omitempty"`
+	MinPrice   float64 `json:"min_price,omitempty"` // the lowest price the buyer has to be willing to pay
+}
+
+// WantedBooksPage is a page of wanted books in JSON format
+type WantedBooksPage struct {
+	Search      WantedBookSearch `json:"search"`
+	WantedBooks []WantedBook     `json:"wanted_books"`
+	Page        Page             `json:"page"`
+}
+
+// WantedBooksTemplateType is the type for the wanted books template
+type WantedBooksTemplateType struct {
+	UserTemplateType
+	WantedBooksPage
+}
+
+// WantedBookTemplateType is the type for the wanted book and new wanted book templates
+type WantedBookTemplateType struct {
+	UserTemplateType
+	WantedBook WantedBook
+	Listings   []Book
+	CanDelete  bool
+	Conditions []BookCondition
+}
+
+// NewWantedBook reads a wanted book from POST parameters
+// A wanted book has to have an ISBN or a course and the title defaults to the catalog title for the ISBN
+func NewWantedBook(db gorm.DB, values url.Values, userID int) (WantedBook, error) {
+	var err error
+	wanted := WantedBook{
+		UserID:  userID,
+		Title:   strings.TrimSpace(values.Get("title")),
+		Details: strings.TrimSpace(values.Get("details")),
+	}
+
+	if isbn := strings.TrimSpace(values.Get("isbn")); isbn != "" {
+		if wanted.ISBN, err = NormalizeISBN(isbn); err != nil {
+			return wanted, err
+		}
+		wanted.OriginalISBN = isbn
+	}
+	if courseID := values.Get("course_id"); courseID != "" {
+		if wanted.CourseID, err = strconv.Atoi(courseID); err != nil {
+			return wanted, errors.New("Course ID has to be a number")
+		}
+	}
+	if wanted.ISBN == "" && wanted.CourseID == 0 {
+		return wanted, errors.New("A wanted book needs an ISBN or a course")
+	}
+	if wanted.MaxPrice, err = strconv.ParseFloat(values.Get("max_price"), 64); err != nil || wanted.MaxPrice <= 0 {
+		return wanted, errors.New("Maximum price has to be a positive number")
+	}
+	if condition := values.Get("condition"); condition != "" {
+		if wanted.Condition, err = ParseBookCondition(condition); err != nil {
+			return wanted, err
+		}
+	}
+
+	if wanted.Title == "" && wanted.ISBN != "" {
+		if metadata, err := FindBookMetadata(db, wanted.ISBN); err == nil {
+			wanted.Title = metadata.Title
+		}
+	}
+	return wanted, nil
+}
+
+// Description returns a readable summary of the wanted book like `Calculus for Math 31A up to $20.00`
+// The course is only named if CourseName has been loaded
+func (w WantedBook) Description() string {
+	var parts []string
+	if w.Title != "" {
+		parts = append(parts, w.Title)
+	} else if w.ISBN != "" {
+		parts = append(parts, "ISBN "+w.OriginalISBN)
+	} else {
+		parts = append(parts, "Any book")
+	}
+	if w.CourseName != "" {
+		parts = append(parts, "for "+w.CourseName)
+	}
+	parts = append(parts, fmt.Sprintf("up to $%.2f", w.MaxPrice))
+	if w.Condition != "" {
+		parts = append(parts, "in "+w.Condition.Label()+" condition or better")
+	}
+	return strings.Join(parts, " ")
+}
+
+// listings restricts a book query to the available listings of other users that match the wanted book
+func (w WantedBook) listings(db *gorm.DB) *gorm.DB {
+	db = db.Where("user_id <> ? AND status = ? AND price <= ?", w.UserID, BookAvailable, w.MaxPrice)
+	if w.ISBN != "" {
+		db = db.Where("i_s_b_n = ?", w.ISBN)
+	}
+	if w.CourseID != 0 {
+		db = db.Where("course_id = ?", w.CourseID)
+	}
+	if w.Condition != "" {
+		conditions := []string{}
+		for _, condition := range w.Condition.AtLeast() {
+			conditions = append(conditions, string(condition))
+		}
+		db = db.Where("condition_grade IN (?)", conditions)
+	}
+	return db
+}
+
+// matchingWantedBooks returns the wanted books of other users that a new book matches
+// The ISBN, course and price are checked in SQL and the condition is checked without another query
+func matchingWantedBooks(db gorm.DB, book Book) ([]WantedBook, error) {
+	if book.Status != BookAvailable {
+		return nil, nil
+	}
+	var candidates []WantedBook
+	result := db.Where("user_id <> ? AND (i_s_b_n = ? OR i_s_b_n = '') AND (course_id = ? OR course_id = 0) AND max_price >= ?",
+		book.UserID, book.ISBN, book.CourseID, book.Price).Find(&candidates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var matches []WantedBook
+	for _, wanted := range candidates {
+		if wanted.Condition.Accepts(book.Condition) {
+			matches = append(matches, wanted)
+		}
+	}
+	return matches, nil
+}
+
+// matchWantedBooks notifies the buyer of every wanted book that a new book matches and the seller of the new book
+func matchWantedBooks(db gorm.DB, courseDB gorm.DB, book Book) {
+	wantedBooks, err := matchingWantedBooks(db, book)
+	if err != nil {
+		log.Println("Error matching wanted books for book", book.ID, err)
+		return
+	}
+
+	for _, wanted := range wantedBooks {
+		wanted.CourseName = courseName(courseDB, wanted.CourseID)
+		notify(db, Notification{
+			UserID:       wanted.UserID,
+			WantedBookID: wanted.ID,
+			BookID:       book.ID,
+			Message: fmt.Sprintf("%s was listed for $%.2f and matches your wanted book %s",
+				book.Title, book.Price, wanted.Description()),
+		})
+		notify(db, Notification{
+			UserID:       book.UserID,
+			WantedBookID: wanted.ID,
+			BookID:       book.ID,
+			Message: fmt.Sprintf("A buyer is looking for %s and your listing of %s for $%.2f matches",
+				wanted.Description(), book.Title, book.Price),
+		})
+	}
+}
+
+// ParseWantedBookSearch reads a wanted book search from GET parameters
+// GET parameters:
+// query string (an ISBN or words in the title or details)
+// course_id int
+// department string
+// min_price float
+func ParseWantedBookSearch(values url.Values) (WantedBookSearch, error) {
+	var err error
+	search := WantedBookSearch{
+		Query:      strings.TrimSpace(values.Get("query")),
+		Department: strings.TrimSpace(values.Get("department")),
+	}
+	if isbn, err := NormalizeISBN(search.Query); err == nil {
+		search.ISBN = isbn
+	}
+	if courseID := values.Get("course_id"); courseID != "" {
+		if search.CourseID, err = strconv.Atoi(courseID); err != nil {
+			return search, errors.New("Course ID has to be a number")
+		}
+	}
+	if minPrice := values.Get("min_price"); minPrice != "" {
+		if search.MinPrice, err = strconv.ParseFloat(minPrice, 64); err != nil {
+			return search, errors.New("Minimum price has to be a number")
+		}
+	}
+	return search, nil
+}
+
+// filter restricts a wanted book query to the wanted books that match the search
+func (s WantedBookSearch) filter(db *gorm.DB, courseDB gorm.DB) (*gorm.DB, error) {
+	if s.ISBN != "" {
+		db = db.Where("i_s_b_n = ?", s.ISBN)
+	} else if s.Query != "" {
+		db = db.Where("title LIKE ? OR details LIKE ?", "%"+s.Query+"%", "%"+s.Query+"%")
+	}
+	if s.CourseID != 0 {
+		db = db.Where("course_id = ?", s.CourseID)
+	}
+	if s.MinPrice != 0 {
+		db = db.Where("max_price >= ?", s.MinPrice)
+	}
+	if s.Department != "" {
+		return filterDepartment(db, courseDB, s.Department)
+	}
+	return db, nil
+}
+
+// wantedBooksRequest finds a page of the wanted books that match the search in a request, newest first
+func wantedBooksRequest(r *http.Request, db gorm.DB, courseDB gorm.DB) (WantedBooksPage, error) {
+	wantedBooksPage := WantedBooksPage{WantedBooks: []WantedBook{}}
+	search, err := ParseWantedBookSearch(r.URL.Query())
+	if err != nil {
+		return wantedBooksPage, err
+	}
+	wantedBooksPage.Search = search
+	pageRequest, err := parsePageRequest(r.URL.Query(), DefaultPageSize)
+	if err != nil {
+		return wantedBooksPage, err
+	}
+
+	filtered, err := search.filter(&db, courseDB)
+	if err != nil {
+		return wantedBooksPage, err
+	}
+	wantedBooksPage.Page, err = paginate(filtered, pageRequest, newestFirst, &wantedBooksPage.WantedBooks, func(i int) pageCursor {
+		wanted := wantedBooksPage.WantedBooks[i]
+		return pageCursor{CreatedAt: wanted.CreatedAt, ID: wanted.ID}
+	})
+	wantedBooksPage.Page.setLinks(r.URL)
+	for i := range wantedBooksPage.WantedBooks {
+		wantedBooksPage.WantedBooks[i].CourseName = courseName(courseDB, wantedBooksPage.WantedBooks[i].CourseID)
+	}
+	return wantedBooksPage, err
+}
+
+// WantedBooksHandler is a route for /wanted that shows a page of the books that buyers want, newest first
+// GET parameters are the same as ParseWantedBookSearch with cursor and page_size for paging
+func WantedBooksHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	wantedBooksPage, err := wantedBooksRequest(r, db, courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/wanted_books.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, WantedBooksTemplateType{
+		UserTemplateType: params,
+		WantedBooksPage:  wantedBooksPage,
+	})
+}
+
+// WantedBooksJSONHandler is a route for /wanted/json that returns a page of the books that buyers want in JSON format
+// GET parameters are the same as WantedBooksHandler
+func WantedBooksJSONHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	wantedBooksPage, err := wantedBooksRequest(r, db, courseDB)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	wantedBooksJSON, err := json.Marshal(wantedBooksPage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(wantedBooksJSON)
+}
+
+// WantedBookHandler is a route for /wanted/{id} that shows a wanted book and the available listings that match it
+func WantedBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB, courseDB gorm.DB) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var wanted WantedBook
+	if result := db.Where("id = ?", id).First(&wanted); result.Error != nil {
+		http.NotFound(w, r)
+		return
+	}
+	wanted.CourseName = courseName(courseDB, wanted.CourseID)
+
+	var listings []Book
+	if result := wanted.listings(&db).Order("price, id").Find(&listings); result.Error != nil {
+		http.Error(w, "Error retrieving listings", http.StatusInternalServerError)
+		return
+	}
+
+	t, params, err := GenerateFullTemplate(r, "templates/wanted_book.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	t.Execute(w, WantedBookTemplateType{
+		UserTemplateType: params,
+		WantedBook:       wanted,
+		Listings:         listings,
+		CanDelete:        params.CurrentUser.ID == wanted.UserID,
+	})
+}
+
+// NewWantedBookHandler is a route for /wanted/new that shows the form for a wanted book and saves it
+// GET parameters isbn and course_id fill in the form
+// POST parameters:
+// isbn string (optional if course_id is set)
+// title string (optional, defaults to the catalog title for the isbn)
+// course_id int (optional if isbn is set)
+// max_price float
+// condition string (the worst condition the buyer accepts, or empty for any condition)
+// details string
+func NewWantedBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to post a wanted book", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == "GET" {
+		t, params, err := GenerateFullTemplate(r, "templates/new_wanted_book.html")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		wanted := WantedBook{ISBN: r.URL.Query().Get("isbn")}
+		wanted.CourseID, _ = strconv.Atoi(r.URL.Query().Get("course_id"))
+
+		t.Execute(w, WantedBookTemplateType{
+			UserTemplateType: params,
+			WantedBook:       wanted,
+			Conditions:       BookConditions,
+		})
+		return
+	}
+
+	r.ParseForm()
+	wanted, err := NewWantedBook(db, r.PostForm, currentUser.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if result := db.Create(&wanted); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/wanted/%d", wanted.ID), http.StatusFound)
+}
+
+// DeleteWantedBookHandler is a route for /wanted/{id}/delete that removes a wanted book of the logged in user
+func DeleteWantedBookHandler(w http.ResponseWriter, r *http.Request, db gorm.DB) {
+	currentUser, err := CurrentUser(r)
+	if err != nil {
+		http.Error(w, "You have to be logged in to delete a wanted book", http.StatusUnauthorized)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var wanted WantedBook
+	if result := db.Where("id = ? AND user_id = ?", id, currentUser.ID).First(&wanted); result.Error != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if result := db.Delete(&wanted); result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/wanted", http.StatusFound)
+}
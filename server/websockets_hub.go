@@ -2,6 +2,7 @@ package server
 
 import (
 	"encoding/json"
+	"log"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -21,12 +22,26 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan *connection
+
+	// Saved search notifications to send to their users.
+	notify chan Notification
+}
+
+// notificationMessage is the websocket message for a notification
+// The type field tells it apart from chat messages
+type notificationMessage struct {
+	Type string `json:"type"`
+	Notification
 }
 
+// notifyBufferSize is the number of notifications that can wait for the hub before notify waits too
+const notifyBufferSize = 256
+
 var h = hub{
 	broadcast:   make(chan []byte),
 	register:    make(chan *connection),
 	unregister:  make(chan *connection),
+	notify:      make(chan Notification, notifyBufferSize),
 	connections: make(map[*connection]bool),
 }
 
@@ -35,6 +50,7 @@ func (h *hub) run(db gorm.DB) {
 		select {
 		case c := <-h.register:
 			h.connections[c] = true
+			h.sendPendingNotifications(db, c.user.ID)
 		case c := <-h.unregister:
 			if _, ok := h.connections[c]; ok {
 				delete(h.connections, c)
@@ -72,6 +88,62 @@ func (h *hub) run(db gorm.DB) {
 				}
 				db.Create(&parsedMessage)
 			}
+		case n := <-h.notify:
+			if h.sendNotification(n) {
+				db.Model(&n).UpdateColumn("delivered", true)
+			}
+		}
+	}
+}
+
+// sendNotification sends a notification to every connection of its user and
+// returns true if at least one connection received it
+func (h *hub) sendNotification(n Notification) bool {
+	m, err := json.Marshal(notificationMessage{Type: "notification", Notification: n})
+	if err != nil {
+		return false
+	}
+
+	delivered := false
+	for c := range h.connections {
+		if c.user.ID != n.UserID {
+			continue
 		}
+		select {
+		case c.send <- m:
+			delivered = true
+		default:
+			close(c.send)
+			delete(h.connections, c)
+		}
+	}
+	return delivered
+}
+
+// sendPendingNotifications sends the notifications that were saved while a user was offline
+func (h *hub) sendPendingNotifications(db gorm.DB, userID int) {
+	var pending []Notification
+	if result := db.Where("user_id = ? AND delivered = ?", userID, false).Order("created_at, id").Find(&pending); result.Error != nil {
+		return
+	}
+
+	var delivered []int
+	for _, n := range pending {
+		if h.sendNotification(n) {
+			delivered = append(delivered, n.ID)
+		}
+	}
+	if len(delivered) > 0 {
+		db.Model(&Notification{}).Where("id IN (?)", delivered).UpdateColumn("delivered", true)
+	}
+}
+
+// notify saves a notification and sends it through the hub, which delivers it now
+// if its user is online or when they next connect
+func notify(db gorm.DB, n Notification) {
+	if result := db.Create(&n); result.Error != nil {
+		log.Println("Error saving notification for user", n.UserID, result.Error)
+		return
 	}
+	h.notify <- n
 }
@@ -55,7 +55,7 @@ function Message (senderId, receiverId) {
       type: 'GET',
       url: '/past_messages/' + receiverId
     }).success(function (data, textStatus, jqXHR) {
-      var parsedResults = data
+      var parsedResults = data.messages
       if (parsedResults !== null) {
         for (var i = parsedResults.length - 1; i >= 0; i--) {
           addMessage(parsedResults[i])
@@ -106,6 +106,10 @@ function Message (senderId, receiverId) {
 
       conn.onmessage = function (evt) {
         var parsedMessage = JSON.parse(evt.data)
+        // saved search notifications are shown by the navbar
+        if (parsedMessage.type === 'notification') {
+          return
+        }
         addMessage(parsedMessage)
         $('#log').scrollTop($('#log')[0].scrollHeight)
       }
@@ -24,6 +24,24 @@ $(document).ready(function () {
     return this
   }
 
+  // show saved search notifications as they arrive
+  if (window.WebSocket) {
+    var notifications = new WebSocket('ws://' + window.location.host + '/ws')
+    notifications.onmessage = function (evt) {
+      var data = JSON.parse(evt.data)
+      if (data.type !== 'notification') {
+        return
+      }
+      $('#notificationsBody').prepend(
+        '<a class="search-notification" href="/books/' + data.book_id +
+          '"><div class="unreadmsg"><span id="msgpreview">' +
+          $('<div/>').text(data.message).html() +
+          '</span></div></a>'
+      )
+      $('.messages').append('<span id="notification_count">!</span>')
+    }
+  }
+
   setInterval(function () {
     $.ajax({
       type: 'GET',
@@ -36,24 +54,25 @@ $(document).ready(function () {
       var message = []
       var senderName
       var read = false
+      var messages = data.messages
 
-      for (var i = 0; i < data.length; i++) {
-        read = data[i]['read']
+      for (var i = 0; i < messages.length; i++) {
+        read = messages[i]['read']
         if (!read) {
           msgCounter++
         }
 
-        if (($.inArray(data[i]['senderId'], senderIdList)) === -1) {
-          if ((data[i]['message']).length > 60) {
-            message.push((data[i]['message']).substring(0, 60) + '...')
+        if (($.inArray(messages[i]['senderId'], senderIdList)) === -1) {
+          if ((messages[i]['message']).length > 60) {
+            message.push((messages[i]['message']).substring(0, 60) + '...')
           } else {
-            message.push(data[i]['message'])
+            message.push(messages[i]['message'])
           }
-          senderIdList.push(data[i]['senderId'])
+          senderIdList.push(messages[i]['senderId'])
           ;(function (messageNum, read) {
             $.ajax({
               type: 'GET',
-              url: '/users/' + data[i]['senderId'] + '/json'
+              url: '/users/' + messages[i]['senderId'] + '/json'
             }).success(function (data, textStatus, jqXHR) {
               senderName = data['first_name'] + ' ' + data['last_name']
               if (read) {
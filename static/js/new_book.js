@@ -6,6 +6,12 @@ $(document).ready(function () {
   var professorSelector = '#professor'
   var isbnSelector = '#isbn_enter'
   var isbnSearchSelector = '#search_button'
+  var termSelector = '#term'
+
+  // courses are searched in the selected term, or in every term if the term is not specified
+  function termParam () {
+    return '&term=' + encodeURIComponent($(termSelector).val() || 'all')
+  }
 
   function SearchReplace (wildcard, url, urlEncodedQuery) {
     var department = $(departmentSelector).val()
@@ -26,7 +32,7 @@ $(document).ready(function () {
     } else {
       throw new Error('Wildcard error')
     }
-    return result
+    return result + termParam()
   }
 
   var departmentSuggestion = new Bloodhound({
@@ -91,7 +97,7 @@ $(document).ready(function () {
     var url = '/course_search.json?type=professor&department=' +
       encodeURIComponent(department) + '&course_id=' +
       encodeURIComponent(courseID) + '&professor=' +
-      encodeURIComponent(professor)
+      encodeURIComponent(professor) + termParam()
 
     $.ajax({
       type: 'GET',
@@ -113,14 +119,14 @@ $(document).ready(function () {
     }
     $.ajax({
       method: 'GET',
-      url: 'https://www.googleapis.com/books/v1/volumes?q=isbn:' + encodeURIComponent(isbn)
+      url: '/isbn/' + encodeURIComponent(isbn.trim()) + '/json'
     }).success(function (data) {
-      if (data.totalItems === 0) {
+      return callback(null, data)
+    }).error(function (jqXHR) {
+      if (jqXHR.status === 400) {
         return callback(new Error('ISBN is invalid'), null)
-      } else if (data.totalItems > 1) {
-        return callback(new Error('ISBN is ambiguous'), null)
       }
-      return callback(null, data.items[0])
+      return callback(new Error('No book found for ISBN'), null)
     })
   }
 
@@ -133,16 +139,13 @@ $(document).ready(function () {
       }
 
       if ($('#dropdown_isbn').hasClass('hidden')) {
-        $('.book_title').text('Title: ' + data.volumeInfo.title)
-        $('.book_authors').text('Authors: ' + data.volumeInfo.authors.join(', '))
-        $('.book_date').text('Published Date: ' + data.volumeInfo.publishedDate)
-        if (typeof (data.volumeInfo.imageLinks) === 'undefined' ||
-            data.volumeInfo.imageLinks === null ||
-            typeof (data.volumeInfo.imageLinks.thumbnail) === 'undefined' ||
-            data.volumeInfo.imageLinks.thumbnail === null) {
+        $('.book_title').text('Title: ' + data.title)
+        $('.book_authors').text('Authors: ' + data.authors)
+        $('.book_date').text('Published Date: ' + data.published_date)
+        if (data.cover_url === '') {
           $('.book_image').attr('src', '/images/no_image.png')
         } else {
-          $('.book_image').attr('src', data.volumeInfo.imageLinks.thumbnail)
+          $('.book_image').attr('src', data.cover_url)
         }
 
         $('#dropdown_isbn').removeClass('hidden')
@@ -160,18 +163,10 @@ $(document).ready(function () {
         alert('Price cannot be empty')
         return
       }
-      if ($('#condition').val().trim().length === 0) {
-        alert('Condition cannot be empty')
-        return
-      }
       if (isNaN(parseFloat($('#price').val()))) {
         alert('Price has to be a decimal')
         return
       }
-      if (isNaN(parseInt($('#condition').val(), 10))) {
-        alert('Condition has to be an integer')
-        return
-      }
 
       getCourseID($(departmentSelector).val(), $(courseIDSelector).val(), $(professorSelector).val(), function (err, course) {
         if (err) {
@@ -188,7 +183,7 @@ $(document).ready(function () {
           }
 
           $('#isbn').val(isbn)
-          $('#title').val(book.volumeInfo.title)
+          $('#title').val(book.title)
           canSubmit = true
           $('#post-edit').submit()
         })
@@ -2,17 +2,81 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/DarinM223/bookcycle/server"
+	"github.com/gorilla/websocket"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/jinzhu/gorm"
 )
 
+// metadataLookups counts the requests made to the stand-in Google Books server
+var metadataLookups int32
+
+// testVolumes is the Google Books volumes response returned by the stand-in server for each ISBN
+var testVolumes = map[string]string{
+	"9780735619678": `{"totalItems": 1, "items": [{"volumeInfo": {
+		"title": "Code Complete",
+		"subtitle": "A Practical Handbook of Software Construction, Second Edition",
+		"authors": ["Steve McConnell"],
+		"publisher": "Microsoft Press",
+		"publishedDate": "2004-06-09",
+		"imageLinks": {"thumbnail": "http://books.example.com/code_complete.jpg"}
+	}}]}`,
+}
+
+// NewMetadataTestServer starts a stand-in for the Google Books API that only knows about testVolumes
+func NewMetadataTestServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&metadataLookups, 1)
+		isbn := strings.TrimPrefix(r.URL.Query().Get("q"), "isbn:")
+		volume, ok := testVolumes[isbn]
+		if !ok {
+			volume = `{"totalItems": 0}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(volume))
+	}))
+}
+
+// TestCoursesDatabase is the copy of courses.database that the test servers use so that tests can change
+// the catalog without changing courses.database
+const TestCoursesDatabase = "./sqlite_courses_copy_test.db"
+
+// copyCoursesDatabase copies courses.database to TestCoursesDatabase once for every test server
+var copyCoursesDatabase sync.Once
+
+// copyFile copies the file at src to dst, replacing dst if it exists
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 // SetUpTesting starts a test http server and sets up a test database
 func SetUpTesting(testing bool) (*httptest.Server, gorm.DB) {
 	// Set up database
@@ -20,11 +84,37 @@ func SetUpTesting(testing bool) (*httptest.Server, gorm.DB) {
 	db.LogMode(false)
 	db.DropTable(&server.User{})
 	db.DropTable(&server.Book{})
-	db.DropTable(&server.Book{})
-	db.AutoMigrate(&server.User{}, &server.Book{}, &server.Message{})
+	db.DropTable(&server.BookStatusChange{})
+	db.DropTable(&server.BookPhoto{})
+	db.DropTable(&server.BookMetadata{})
+	db.DropTable(&server.SavedSearch{})
+	db.DropTable(&server.Notification{})
+	db.DropTable(&server.WantedBook{})
+	db.DropTable(&server.ScheduledCourse{})
+	db.DropTable(&server.CourseAudit{})
+	db.Exec("DROP TABLE IF EXISTS book_search")
+	db.Exec("DROP TABLE IF EXISTS search_trigrams")
+
+	copyCoursesDatabase.Do(func() {
+		if err := copyFile("./courses.database", TestCoursesDatabase); err != nil {
+			panic(err)
+		}
+	})
+	coursesDB, _ := gorm.Open("sqlite3", TestCoursesDatabase)
+	coursesDB.AutoMigrate(&server.Course{}, &server.Term{}, &server.CourseTextbook{}, &server.Institution{},
+		&server.DepartmentAlias{}, &server.CrossListing{}, &server.Professor{}, &server.CourseProfessor{})
+	server.MigrateCourses(coursesDB)
+	server.MigrateProfessors(coursesDB)
+
+	server.SetSearchIndex(server.NewSQLiteSearchIndex(coursesDB))
+	server.MigrateDB(db)
+	server.MigrateInstitutions(db, coursesDB)
+
+	// verification emails are not sent, tests read the token from the database instead
+	server.SetMailer(server.NewLogMailer(ioutil.Discard))
 
-	coursesDB, _ := gorm.Open("sqlite3", "./courses.database")
-	coursesDB.AutoMigrate(&server.Course{})
+	// look up book metadata from a local stand-in instead of Google Books
+	server.SetMetadataProvider(server.NewGoogleBooksProvider(NewMetadataTestServer().URL))
 
 	// set up test db
 	server := httptest.NewServer(server.Routes(db, coursesDB, RequestsPerMinute, testing))
@@ -91,6 +181,23 @@ func (n UserTesting) MakeTestUser(u server.User, password string, passwordConfir
 	return nil
 }
 
+// VerifyTestUser opens the link of the latest verification email of a test user
+func (n UserTesting) VerifyTestUser(email string) error {
+	var user server.User
+	if result := n.DB.Where("email LIKE ?", email).First(&user); result.Error != nil {
+		return result.Error
+	}
+	res, err := http.Get(fmt.Sprintf("%s/users/verify?token=%s", n.Server.URL, url.QueryEscape(user.VerificationToken)))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("Verifying %s should be 200: %d", email, res.StatusCode)
+	}
+	return nil
+}
+
 // EditTestUser edits an existing user
 func (n UserTesting) EditTestUser(u server.User, c *http.Cookie, password string, passwordConfirm string) error {
 	userJSON := url.Values{}
@@ -169,20 +276,59 @@ func (b BookTesting) DeleteBookURL(id int) string {
 	return fmt.Sprintf("%s/books/%d/delete", b.Server.URL, id)
 }
 
+// TrashURL returns the trash url
+func (b BookTesting) TrashURL() string {
+	return fmt.Sprintf("%s/books/trash", b.Server.URL)
+}
+
+// RestoreBookURL returns the restore book url
+func (b BookTesting) RestoreBookURL(id int) string {
+	return fmt.Sprintf("%s/books/%d/restore", b.Server.URL, id)
+}
+
+// PurgeBookURL returns the purge book url
+func (b BookTesting) PurgeBookURL(id int) string {
+	return fmt.Sprintf("%s/books/%d/purge", b.Server.URL, id)
+}
+
+// EditBookURL returns the edit book url
+func (b BookTesting) EditBookURL(id int) string {
+	return fmt.Sprintf("%s/books/%d/edit", b.Server.URL, id)
+}
+
+// BookStatusURL returns the change book status url
+func (b BookTesting) BookStatusURL(id int) string {
+	return fmt.Sprintf("%s/books/%d/status", b.Server.URL, id)
+}
+
+// RenewBookURL returns the renew book url
+func (b BookTesting) RenewBookURL(id int) string {
+	return fmt.Sprintf("%s/books/%d/renew", b.Server.URL, id)
+}
+
 // ShowBookURL returns the show book url
 func (b BookTesting) ShowBookURL(id int) string {
 	return fmt.Sprintf("%s/books/%d", b.Server.URL, id)
 }
 
-// MakeTestBook makes a new test book
-func (b BookTesting) MakeTestBook(book server.Book, loginCookie *http.Cookie) error {
+// bookForm returns the post form values for a book
+func bookForm(book server.Book) url.Values {
 	bookJSON := url.Values{}
 	bookJSON.Set("title", book.Title)
 	bookJSON.Set("isbn", book.ISBN)
 	bookJSON.Set("course_id", strconv.Itoa(book.CourseID))
 	bookJSON.Set("price", fmt.Sprintf("%f", book.Price))
-	bookJSON.Set("condition", strconv.Itoa(book.Condition))
+	bookJSON.Set("condition", string(book.Condition))
 	bookJSON.Set("details", book.Details)
+	if book.TermID != 0 {
+		bookJSON.Set("term_id", strconv.Itoa(book.TermID))
+	}
+	return bookJSON
+}
+
+// MakeTestBook makes a new test book
+func (b BookTesting) MakeTestBook(book server.Book, loginCookie *http.Cookie) error {
+	bookJSON := bookForm(book)
 
 	request, err := http.NewRequest("POST", b.NewBookURL(), bytes.NewBufferString(bookJSON.Encode()))
 	if err != nil {
@@ -198,3 +344,348 @@ func (b BookTesting) MakeTestBook(book server.Book, loginCookie *http.Cookie) er
 
 	return nil
 }
+
+// EditTestBook edits an existing book
+func (b BookTesting) EditTestBook(id int, book server.Book, loginCookie *http.Cookie) error {
+	bookJSON := bookForm(book)
+
+	request, err := http.NewRequest("POST", b.EditBookURL(id), bytes.NewBufferString(bookJSON.Encode()))
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
+
+// ChangeTestBookStatus changes the status of an existing book
+func (b BookTesting) ChangeTestBookStatus(id int, status server.BookStatus, loginCookie *http.Cookie) error {
+	statusJSON := url.Values{}
+	statusJSON.Set("status", string(status))
+
+	request, err := http.NewRequest("POST", b.BookStatusURL(id), bytes.NewBufferString(statusJSON.Encode()))
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
+
+// MakeTestBookWithPhotos makes a new test book with photos uploaded as a multipart form
+func (b BookTesting) MakeTestBookWithPhotos(book server.Book, photos [][]byte, loginCookie *http.Cookie) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for key, values := range bookForm(book) {
+		writer.WriteField(key, values[0])
+	}
+	for i, photo := range photos {
+		part, err := writer.CreateFormFile("photos", fmt.Sprintf("photo%d.png", i))
+		if err != nil {
+			return err
+		}
+		part.Write(photo)
+	}
+	writer.Close()
+
+	request, err := http.NewRequest("POST", b.NewBookURL(), &body)
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
+
+// ImportBooksURL returns the JSON book import url
+func (b BookTesting) ImportBooksURL() string {
+	return fmt.Sprintf("%s/books/import/json", b.Server.URL)
+}
+
+// ImportTestBooks uploads a CSV file of books and returns the import report
+func (b BookTesting) ImportTestBooks(csv string, allOrNothing bool, loginCookie *http.Cookie) (server.ImportReport, error) {
+	var report server.ImportReport
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if allOrNothing {
+		writer.WriteField("all_or_nothing", "1")
+	}
+	part, err := writer.CreateFormFile("file", "books.csv")
+	if err != nil {
+		return report, err
+	}
+	part.Write([]byte(csv))
+	writer.Close()
+
+	request, err := http.NewRequest("POST", b.ImportBooksURL(), &body)
+	if err != nil {
+		return report, err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", writer.FormDataContentType())
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return report, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return report, errors.New("POST Success should be 200")
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&report)
+	return report, err
+}
+
+// SearchBooksURL returns the JSON search url for a set of search parameters
+func (b BookTesting) SearchBooksURL(params url.Values) string {
+	return fmt.Sprintf("%s/search_results.json?%s", b.Server.URL, params.Encode())
+}
+
+// SearchTestBooksWith returns the search results for a set of search parameters
+func (b BookTesting) SearchTestBooksWith(params url.Values) (server.BookSearchResults, error) {
+	var results server.BookSearchResults
+
+	res, err := http.Get(b.SearchBooksURL(params))
+	if err != nil {
+		return results, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return results, errors.New("GET Success should be 200")
+	}
+
+	err = json.NewDecoder(res.Body).Decode(&results)
+	return results, err
+}
+
+// SearchTestBooks returns the titles of the books that match a search query, most relevant first
+func (b BookTesting) SearchTestBooks(query string) ([]string, error) {
+	results, err := b.SearchTestBooksWith(url.Values{"query": {query}})
+	if err != nil {
+		return nil, err
+	}
+	titles := make([]string, len(results.Books))
+	for i, book := range results.Books {
+		titles[i] = book.Title
+	}
+	return titles, nil
+}
+
+// CourseURL returns the course page url
+func (b BookTesting) CourseURL(id int) string {
+	return fmt.Sprintf("%s/courses/%d", b.Server.URL, id)
+}
+
+// DepartmentURL returns the department page url
+func (b BookTesting) DepartmentURL(department string) string {
+	return fmt.Sprintf("%s/departments/%s", b.Server.URL, strings.Replace(url.QueryEscape(department), "+", "%20", -1))
+}
+
+// CourseSearchURL returns the course typeahead url
+func (b BookTesting) CourseSearchURL(params url.Values) string {
+	return fmt.Sprintf("%s/course_search.json?%s", b.Server.URL, params.Encode())
+}
+
+// GetTestJSON decodes the JSON response of a GET request into out
+func (b BookTesting) GetTestJSON(url string, out interface{}) error {
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GET %s should be 200: %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// ISBNStatsURL returns the price stats url for an ISBN
+func (b BookTesting) ISBNStatsURL(isbn string) string {
+	return fmt.Sprintf("%s/isbn/%s/stats", b.Server.URL, isbn)
+}
+
+// SavedSearchesURL returns the saved searches url
+func (b BookTesting) SavedSearchesURL() string {
+	return fmt.Sprintf("%s/searches", b.Server.URL)
+}
+
+// NotificationsURL returns the JSON notifications url
+func (b BookTesting) NotificationsURL() string {
+	return fmt.Sprintf("%s/notifications/json", b.Server.URL)
+}
+
+// SaveTestSearch saves a search for the logged in user
+func (b BookTesting) SaveTestSearch(search server.SavedSearch, loginCookie *http.Cookie) error {
+	searchJSON := url.Values{}
+	searchJSON.Set("query", search.Query)
+	if search.CourseID != 0 {
+		searchJSON.Set("course_id", strconv.Itoa(search.CourseID))
+	}
+	if search.MaxPrice != 0 {
+		searchJSON.Set("max_price", fmt.Sprintf("%f", search.MaxPrice))
+	}
+	searchJSON.Set("condition", string(search.Condition))
+
+	request, err := http.NewRequest("POST", b.SavedSearchesURL(), bytes.NewBufferString(searchJSON.Encode()))
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
+
+// GetTestJSONWithCookie decodes the JSON response of a GET request made by a logged in user into out
+func (b BookTesting) GetTestJSONWithCookie(url string, loginCookie *http.Cookie, out interface{}) error {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GET %s should be 200: %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// PostTestJSONWithCookie decodes the JSON response of a form POST request made by a logged in user into out
+// It returns the status code of the response so that rejected requests can be tested
+func (b BookTesting) PostTestJSONWithCookie(url string, values url.Values, loginCookie *http.Cookie, out interface{}) (int, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewBufferString(values.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return res.StatusCode, fmt.Errorf("POST %s should be 200: %d", url, res.StatusCode)
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(out)
+}
+
+// AdminCoursesURL returns the admin course catalog url
+func (b BookTesting) AdminCoursesURL() string {
+	return fmt.Sprintf("%s/admin/courses", b.Server.URL)
+}
+
+// GetTestPageWithCookie returns the body of a page as a logged in user
+func (b BookTesting) GetTestPageWithCookie(url string, loginCookie *http.Cookie) (string, error) {
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	request.AddCookie(loginCookie)
+
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GET %s should be 200: %d", url, res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	return string(body), err
+}
+
+// DialTestWebsocket opens a websocket connection to the hub as a logged in user
+func (b BookTesting) DialTestWebsocket(loginCookie *http.Cookie) (*websocket.Conn, error) {
+	header := http.Header{}
+	header.Add("Cookie", loginCookie.String())
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(b.Server.URL, "http")+"/ws", header)
+	return ws, err
+}
+
+// WantedBooksURL returns the wanted books url
+func (b BookTesting) WantedBooksURL() string {
+	return fmt.Sprintf("%s/wanted", b.Server.URL)
+}
+
+// PostTestWantedBook posts a new wanted book
+func (b BookTesting) PostTestWantedBook(wanted server.WantedBook, loginCookie *http.Cookie) error {
+	wantedJSON := url.Values{}
+	wantedJSON.Set("isbn", wanted.ISBN)
+	wantedJSON.Set("title", wanted.Title)
+	if wanted.CourseID != 0 {
+		wantedJSON.Set("course_id", strconv.Itoa(wanted.CourseID))
+	}
+	wantedJSON.Set("max_price", fmt.Sprintf("%f", wanted.MaxPrice))
+	wantedJSON.Set("condition", string(wanted.Condition))
+
+	request, err := http.NewRequest("POST", b.WantedBooksURL()+"/new", bytes.NewBufferString(wantedJSON.Encode()))
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
+
+// ScheduleURL returns the schedule url
+func (b BookTesting) ScheduleURL() string {
+	return fmt.Sprintf("%s/schedule", b.Server.URL)
+}
+
+// AddTestScheduledCourse adds a course to the schedule of the logged in user
+func (b BookTesting) AddTestScheduledCourse(courseID int, loginCookie *http.Cookie) error {
+	scheduleJSON := url.Values{}
+	scheduleJSON.Set("course_id", strconv.Itoa(courseID))
+
+	request, err := http.NewRequest("POST", b.ScheduleURL(), bytes.NewBufferString(scheduleJSON.Encode()))
+	if err != nil {
+		return err
+	}
+	request.AddCookie(loginCookie)
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	// Test that POST request returns success
+	if res, err := http.DefaultClient.Do(request); err != nil || res.StatusCode != 200 {
+		return errors.New("POST Success should be 200")
+	}
+
+	return nil
+}
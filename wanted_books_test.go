@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/DarinM223/bookcycle/server"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWantedBooks(t *testing.T) {
+	users := []server.User{
+		{Firstname: "Buying", Lastname: "User", Email: "buyer@gmail.com", Phone: 123456789},
+		{Firstname: "Selling", Lastname: "User", Email: "seller@gmail.com", Phone: 123456789},
+	}
+	cookies := make([]*http.Cookie, len(users))
+	for i, testUser := range users {
+		if err := bookTesting.MakeTestUser(testUser, "password", "password"); err != nil {
+			t.Fatal(err)
+		}
+		loginCookie, err := bookTesting.LoginUser(testUser.Email, "password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies[i] = loginCookie
+		bookTesting.DB.Where("email LIKE ?", testUser.Email).First(&users[i])
+	}
+	buyer, seller := users[0], users[1]
+	buyerCookie, sellerCookie := cookies[0], cookies[1]
+
+	wantedBooks := []server.WantedBook{
+		{ISBN: "0201633612", Title: "Writing Solid Code", MaxPrice: 25.0, Condition: server.ConditionGood},
+		// course 100 is Anthropology 297
+		{CourseID: 100, MaxPrice: 40.0},
+	}
+	for _, wanted := range wantedBooks {
+		if err := bookTesting.PostTestWantedBook(wanted, buyerCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bookTesting.PostTestWantedBook(server.WantedBook{MaxPrice: 10.0}, buyerCookie); err == nil {
+		t.Error("Posting a wanted book without an ISBN or a course should fail")
+	}
+
+	// test that sellers can search the wanted books
+	tests := []struct {
+		params url.Values
+		count  int
+	}{
+		{url.Values{}, 2},
+		{url.Values{"query": {"978-0-201-63361-0"}}, 1},
+		{url.Values{"query": {"solid"}}, 1},
+		{url.Values{"department": {"Anthropology"}}, 1},
+		{url.Values{"min_price": {"30"}}, 1},
+	}
+	for _, test := range tests {
+		var page server.WantedBooksPage
+		if err := bookTesting.GetTestJSON(bookTesting.WantedBooksURL()+"/json?"+test.params.Encode(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.WantedBooks) != test.count {
+			t.Errorf("Search for %v expected %d wanted books: %d", test.params, test.count, len(page.WantedBooks))
+		}
+	}
+
+	// test that only listings within the price and condition of a wanted book are matched
+	testBooks := []server.Book{
+		{Title: "Writing Solid Code", ISBN: "0201633612", CourseID: 1, Price: 20.0, Condition: server.ConditionLikeNew},
+		{Title: "Writing Solid Code", ISBN: "0201633612", CourseID: 1, Price: 30.0, Condition: server.ConditionNew},
+		{Title: "Writing Solid Code", ISBN: "0201633612", CourseID: 1, Price: 5.0, Condition: server.ConditionPoor},
+		{Title: "Anthropology Reader", ISBN: "0735619670", CourseID: 100, Price: 35.0, Condition: server.ConditionPoor},
+	}
+	for _, book := range testBooks {
+		if err := bookTesting.MakeTestBook(book, sellerCookie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// test that both the buyer and the seller are notified of every match
+	server.WaitForNewBooks()
+	for _, user := range users {
+		var notifications []server.Notification
+		bookTesting.DB.Where("user_id = ? AND wanted_book_id <> 0", user.ID).Order("id").Find(&notifications)
+		if len(notifications) != 2 {
+			t.Fatalf("\"2\" notifications expected for %s: %+v", user.Email, notifications)
+		}
+		var book server.Book
+		bookTesting.DB.First(&book, notifications[0].BookID)
+		if book.Price != 20.0 || book.UserID != seller.ID {
+			t.Errorf("Notification for the $20 listing expected: %+v", book)
+		}
+	}
+
+	// test that the wanted book page shows the matching listings
+	var wanted server.WantedBook
+	bookTesting.DB.Where("user_id = ? AND i_s_b_n <> ''", buyer.ID).First(&wanted)
+	res, err := http.Get(bookTesting.WantedBooksURL() + "/" + strconv.Itoa(wanted.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != 200 || strings.Count(string(body), "href=\"/books/") != 1 {
+		t.Errorf("Wanted book page should show \"1\" matching listing")
+	}
+
+	// test that wanted book ids have to be numbers and that the form is only shown to logged in users
+	res, err = http.Get(bookTesting.WantedBooksURL() + "/" + url.PathEscape("0 OR 1 = 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Wanted book page for an id that is not a number should be 404: %d", res.StatusCode)
+	}
+	res, err = http.Get(bookTesting.WantedBooksURL() + "/new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Wanted book form should be 401 when logged out: %d", res.StatusCode)
+	}
+
+	// Delete mock created users, books, wanted books and notifications
+	for _, user := range users {
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Book{})
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.WantedBook{})
+		bookTesting.DB.Where("user_id = ?", user.ID).Delete(server.Notification{})
+		bookTesting.DB.Delete(&user)
+	}
+}